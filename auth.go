@@ -5,60 +5,90 @@ import (
 	"bytes"
 	"crypto/rand"
 	"crypto/sha1"
+	"crypto/subtle"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"os"
+	"os/user"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
-type authenticator interface {
+// Authenticator implements the client side of a D-Bus authentication
+// mechanism.  The same Authenticator is used each time a connection is
+// established, including when it is re-established, so it should not
+// keep state between authentication attempts.
+//
+// Data is passed to and from an Authenticator decoded: the hex
+// encoding used by the protocol is handled by the connection.
+type Authenticator interface {
+	// Mechanism returns the name of the mechanism, such as
+	// "EXTERNAL".
 	Mechanism() []byte
+	// InitialResponse returns the data sent with the AUTH command,
+	// or nil if there is none.
 	InitialResponse() []byte
+	// ProcessData returns the response to a challenge sent by the
+	// server.  Returning an error cancels the mechanism, so that
+	// the next one can be tried.
 	ProcessData(challenge []byte) (response []byte, err error)
 }
 
-type authExternal struct {
+// AuthExternal authenticates with the EXTERNAL mechanism, claiming
+// the effective user ID of the process.  The server checks the claim
+// against the credentials of the socket.
+type AuthExternal struct {
 }
 
-func (p *authExternal) Mechanism() []byte {
+func (p *AuthExternal) Mechanism() []byte {
 	return []byte("EXTERNAL")
 }
 
-func (p *authExternal) InitialResponse() []byte {
-	uid := []byte(strconv.Itoa(os.Geteuid()))
-	uidHex := make([]byte, hex.EncodedLen(len(uid)))
-	hex.Encode(uidHex, uid)
-	return uidHex
+func (p *AuthExternal) InitialResponse() []byte {
+	return []byte(strconv.Itoa(os.Geteuid()))
 }
 
-func (p *authExternal) ProcessData([]byte) ([]byte, error) {
+func (p *AuthExternal) ProcessData([]byte) ([]byte, error) {
 	return nil, errors.New("Unexpected Response")
 }
 
-type authDbusCookieSha1 struct {
+// AuthDbusCookieSha1 authenticates with the DBUS_COOKIE_SHA1
+// mechanism, proving that the user can read a secret cookie from the
+// keyrings in their home directory.  The user is identified by $USER,
+// or by the effective user ID if it is not set.
+type AuthDbusCookieSha1 struct {
 }
 
-func (p *authDbusCookieSha1) Mechanism() []byte {
+func (p *AuthDbusCookieSha1) Mechanism() []byte {
 	return []byte("DBUS_COOKIE_SHA1")
 }
 
-func (p *authDbusCookieSha1) InitialResponse() []byte {
-	user := []byte(os.Getenv("USER"))
-	userHex := make([]byte, hex.EncodedLen(len(user)))
-	hex.Encode(userHex, user)
-	return userHex
+func (p *AuthDbusCookieSha1) InitialResponse() []byte {
+	if user := os.Getenv("USER"); user != "" {
+		return []byte(user)
+	}
+	return []byte(strconv.Itoa(os.Geteuid()))
 }
 
-func (p *authDbusCookieSha1) ProcessData(mesg []byte) ([]byte, error) {
-	decodedLen, err := hex.Decode(mesg, mesg)
+func (p *AuthDbusCookieSha1) ProcessData(mesg []byte) ([]byte, error) {
+	mesgTokens := bytes.SplitN(mesg, []byte(" "), 3)
+	if len(mesgTokens) != 3 {
+		return nil, errors.New("Malformed cookie challenge")
+	}
+	if !validCookieContext(string(mesgTokens[0])) {
+		return nil, errors.New("Invalid cookie context")
+	}
+	dir, err := defaultKeyringDir()
 	if err != nil {
 		return nil, err
 	}
-	mesgTokens := bytes.SplitN(mesg[:decodedLen], []byte(" "), 3)
 
-	file, err := os.Open(os.Getenv("HOME") + "/.dbus-keyrings/" + string(mesgTokens[0]))
+	file, err := os.Open(filepath.Join(dir, string(mesgTokens[0])))
 	if err != nil {
 		return nil, err
 	}
@@ -74,7 +104,7 @@ func (p *authDbusCookieSha1) ProcessData(mesg []byte) ([]byte, error) {
 			return nil, err
 		}
 		cookieTokens := bytes.SplitN(line, []byte(" "), 3)
-		if bytes.Compare(cookieTokens[0], mesgTokens[1]) == 0 {
+		if len(cookieTokens) == 3 && bytes.Compare(cookieTokens[0], mesgTokens[1]) == 0 {
 			cookie = cookieTokens[2]
 			break
 		}
@@ -100,25 +130,73 @@ func (p *authDbusCookieSha1) ProcessData(mesg []byte) ([]byte, error) {
 		return nil, err
 	}
 
-	resp := bytes.Join([][]byte{challenge, []byte(hex.EncodeToString(hash.Sum(nil)))}, []byte(" "))
-	respHex := make([]byte, hex.EncodedLen(len(resp)))
-	hex.Encode(respHex, resp)
-	return respHex, nil
+	return bytes.Join([][]byte{challenge, []byte(hex.EncodeToString(hash.Sum(nil)))}, []byte(" ")), nil
+}
+
+// AuthAnonymous authenticates with the ANONYMOUS mechanism, which
+// identifies no user.  Servers only accept it if they have been told
+// to allow anonymous clients.
+type AuthAnonymous struct {
+	// Trace optionally describes the client to the server, such
+	// as for logging.  It must be valid UTF-8.
+	Trace string
+}
+
+func (p *AuthAnonymous) Mechanism() []byte {
+	return []byte("ANONYMOUS")
+}
+
+func (p *AuthAnonymous) InitialResponse() []byte {
+	if p.Trace == "" {
+		return nil
+	}
+	return []byte(p.Trace)
+}
+
+func (p *AuthAnonymous) ProcessData([]byte) ([]byte, error) {
+	// The trace is sent in response to the server's empty
+	// challenge if there was no initial response.
+	return []byte(p.Trace), nil
+}
+
+// defaultAuthenticators returns the mechanisms tried when none are
+// given.
+func defaultAuthenticators() []Authenticator {
+	return []Authenticator{
+		new(AuthExternal),
+		new(AuthDbusCookieSha1),
+		new(AuthAnonymous)}
+}
+
+// AuthError is returned when a connection could not be authenticated
+// with any of the mechanisms tried.
+type AuthError struct {
+	// The mechanisms tried, in order.
+	Tried []string
+	// The mechanisms the server offered when it last rejected
+	// one, which may be empty if it did not say.
+	Offered []string
 }
 
-func authenticate(conn net.Conn, authenticators []authenticator) error {
-	// If no authenticators are provided, try them all
+func (e *AuthError) Error() string {
+	return "Could not authenticate with any mechanism (tried: " + strings.Join(e.Tried, ", ") + "; server offers: " + strings.Join(e.Offered, ", ") + ")"
+}
+
+// authenticate performs the client side of the authentication
+// protocol, trying each mechanism in turn, or the defaults if none
+// are given.  Mechanisms the server has said it does not support are
+// skipped.  On success, it reports whether passing file descriptors
+// was negotiated.
+func authenticate(conn net.Conn, authenticators []Authenticator) (unixFDs bool, err error) {
 	if authenticators == nil {
-		authenticators = []authenticator{
-			new(authExternal),
-			new(authDbusCookieSha1)}
+		authenticators = defaultAuthenticators()
 	}
 
 	// The authentication process starts by writing a nul byte
 	// writing at this point does not need to be synced as the connection
 	// is not shared at this point.
 	if _, err := conn.Write([]byte{0}); err != nil {
-		return err
+		return false, err
 	}
 
 	inStream := bufio.NewReader(conn)
@@ -139,39 +217,77 @@ func authenticate(conn net.Conn, authenticators []authenticator) error {
 		}
 		return bytes.Split(line, []byte(" ")), err
 	}
+	encode := func(data []byte) []byte {
+		encoded := make([]byte, hex.EncodedLen(len(data)))
+		hex.Encode(encoded, data)
+		return encoded
+	}
+	authErr := &AuthError{}
+	offered := func(mechanism string) bool {
+		if len(authErr.Offered) == 0 {
+			return true
+		}
+		for _, name := range authErr.Offered {
+			if name == mechanism {
+				return true
+			}
+		}
+		return false
+	}
 	success := false
 	for _, auth := range authenticators {
-		reply, err := send([]byte("AUTH"), auth.Mechanism(), auth.InitialResponse())
+		mechanism := auth.Mechanism()
+		if !offered(string(mechanism)) {
+			continue
+		}
+		authErr.Tried = append(authErr.Tried, string(mechanism))
+		command := [][]byte{[]byte("AUTH"), mechanism}
+		if initialResponse := auth.InitialResponse(); initialResponse != nil {
+			command = append(command, encode(initialResponse))
+		}
+		reply, err := send(command...)
 	StatementLoop:
 		for {
 			if err != nil {
-				return err
+				return false, err
 			}
 			if len(reply) < 1 {
-				return errors.New("No response command from server")
+				return false, errors.New("No response command from server")
 			}
 			switch string(reply[0]) {
 			case "OK":
 				success = true
 				break StatementLoop
 			case "REJECTED":
-				// XXX: should note the list of
-				// supported mechanisms
+				authErr.Offered = authErr.Offered[:0]
+				for _, name := range reply[1:] {
+					if len(name) != 0 {
+						authErr.Offered = append(authErr.Offered, string(name))
+					}
+				}
 				break StatementLoop
 			case "ERROR":
-				return errors.New("Received error from server: " + string(bytes.Join(reply, []byte(" "))))
+				return false, errors.New("Received error from server: " + string(bytes.Join(reply, []byte(" "))))
 			case "DATA":
-				var response []byte
-				response, err = auth.ProcessData(reply[1])
+				var challenge, response []byte
+				if len(reply) > 1 {
+					challenge = make([]byte, hex.DecodedLen(len(reply[1])))
+					var n int
+					n, err = hex.Decode(challenge, reply[1])
+					challenge = challenge[:n]
+				}
 				if err == nil {
-					reply, err = send([]byte("DATA"), response)
+					response, err = auth.ProcessData(challenge)
+				}
+				if err == nil {
+					reply, err = send([]byte("DATA"), encode(response))
 				} else {
 					// Cancel so we can move on to
 					// the next mechanism.
 					reply, err = send([]byte("CANCEL"))
 				}
 			default:
-				return errors.New("Unknown response from server: " + string(bytes.Join(reply, []byte(" "))))
+				return false, errors.New("Unknown response from server: " + string(bytes.Join(reply, []byte(" "))))
 			}
 		}
 		if success {
@@ -179,13 +295,296 @@ func authenticate(conn net.Conn, authenticators []authenticator) error {
 		}
 	}
 	if !success {
-		return errors.New("Could not authenticate with any mechanism")
+		return false, authErr
+	}
+	if canPassFDs(conn) {
+		reply, err := send([]byte("NEGOTIATE_UNIX_FD"))
+		if err != nil {
+			return false, err
+		}
+		unixFDs = len(reply) > 0 && string(reply[0]) == "AGREE_UNIX_FD"
 	}
-	// XXX: UNIX FD negotiation would go here.
 	// writing at this point does not need to be synced as the connection
 	// is not shared at this point.
 	if _, err := conn.Write([]byte("BEGIN\r\n")); err != nil {
+		return false, err
+	}
+	return unixFDs, nil
+}
+
+// serverAuthenticator implements the server side of an authentication
+// mechanism.  A new value is created for each authentication attempt,
+// so implementations may keep state between calls to ProcessData.
+type serverAuthenticator interface {
+	Mechanism() []byte
+	// ProcessData handles data sent by the client.  If the
+	// client is authenticated, ok will be true.  Otherwise a
+	// non-nil challenge should be sent back to the client.
+	ProcessData(conn net.Conn, response []byte) (challenge []byte, ok bool, err error)
+}
+
+// serverAuthExternal implements the server side of EXTERNAL, which
+// identifies the client by the credentials of a unix socket.
+type serverAuthExternal struct {
+	// Decides whether the client may connect, or nil to only
+	// allow the user running the server.
+	allow func(*Credentials) bool
+}
+
+func (p *serverAuthExternal) Mechanism() []byte {
+	return []byte("EXTERNAL")
+}
+
+func (p *serverAuthExternal) ProcessData(conn net.Conn, response []byte) ([]byte, bool, error) {
+	creds, err := peerCredentials(conn)
+	if err == errCredentialsUnsupported && p.allow == nil {
+		// Without credentials to check it against, the client
+		// must claim to be the user running the server.
+		if string(response) != strconv.Itoa(os.Geteuid()) {
+			return nil, false, errors.New("User ID " + string(response) + " is not allowed")
+		}
+		return nil, true, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	// The client need not say who it is, but if it does the
+	// claim must match its credentials.
+	uid := strconv.FormatUint(uint64(creds.Uid), 10)
+	if len(response) != 0 && string(response) != uid {
+		return nil, false, errors.New("User ID " + string(response) + " does not match the peer credentials")
+	}
+	allowed := uid == strconv.Itoa(os.Geteuid())
+	if p.allow != nil {
+		allowed = p.allow(creds)
+	}
+	if !allowed {
+		return nil, false, errors.New("User ID " + uid + " is not allowed")
+	}
+	return nil, true, nil
+}
+
+type serverAuthAnonymous struct {
+}
+
+func (p *serverAuthAnonymous) Mechanism() []byte {
+	return []byte("ANONYMOUS")
+}
+
+func (p *serverAuthAnonymous) ProcessData(conn net.Conn, response []byte) ([]byte, bool, error) {
+	// Any trace the client sends is accepted, as long as it is
+	// valid UTF-8.
+	if !utf8.Valid(response) || bytes.IndexByte(response, 0) != -1 {
+		return nil, false, errors.New("Invalid trace")
+	}
+	return nil, true, nil
+}
+
+// serverAuthDbusCookieSha1 implements the server side of
+// DBUS_COOKIE_SHA1.  The client must be the user running the server,
+// since it proves itself by reading a cookie from the server's
+// keyring.
+type serverAuthDbusCookieSha1 struct {
+	keyring *cookieKeyring
+	// The cookie and challenge sent to the client, once it has
+	// said who it is.
+	cookie    *keyringCookie
+	challenge []byte
+}
+
+func (p *serverAuthDbusCookieSha1) Mechanism() []byte {
+	return []byte("DBUS_COOKIE_SHA1")
+}
+
+func (p *serverAuthDbusCookieSha1) ProcessData(conn net.Conn, response []byte) ([]byte, bool, error) {
+	if p.cookie == nil {
+		return p.issueChallenge(response)
+	}
+
+	// The client replies with its own challenge and the hash of
+	// both challenges and the cookie.
+	tokens := bytes.SplitN(response, []byte(" "), 2)
+	if len(tokens) != 2 || len(tokens[0]) == 0 {
+		return nil, false, errors.New("Malformed cookie response")
+	}
+	hash := sha1.New()
+	hash.Write(bytes.Join([][]byte{p.challenge, tokens[0], []byte(p.cookie.cookie)}, []byte(":")))
+	expected := []byte(hex.EncodeToString(hash.Sum(nil)))
+	if subtle.ConstantTimeCompare(expected, tokens[1]) != 1 {
+		return nil, false, errors.New("Cookie response did not match")
+	}
+	return nil, true, nil
+}
+
+// issueChallenge checks that the client claims to be the user running
+// the server, and picks the cookie it must prove it can read.
+func (p *serverAuthDbusCookieSha1) issueChallenge(identity []byte) ([]byte, bool, error) {
+	if len(identity) == 0 {
+		return nil, false, errors.New("No user supplied")
+	}
+	uid := string(identity)
+	if _, err := strconv.Atoi(uid); err != nil {
+		u, err := user.Lookup(uid)
+		if err != nil {
+			return nil, false, err
+		}
+		uid = u.Uid
+	}
+	if uid != strconv.Itoa(os.Geteuid()) {
+		return nil, false, errors.New("User " + string(identity) + " is not allowed")
+	}
+
+	cookie, err := p.keyring.currentCookie()
+	if err != nil {
+		return nil, false, err
+	}
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return nil, false, err
+	}
+	p.cookie = &cookie
+	p.challenge = []byte(hex.EncodeToString(random))
+	return []byte(fmt.Sprintf("%s %d %s", p.keyring.context, cookie.id, p.challenge)), false, nil
+}
+
+// readAuthLine reads a single line of the authentication protocol.
+// The connection is read a byte at a time so that we don't consume
+// any message data the client sends immediately after BEGIN.
+func readAuthLine(conn net.Conn) ([][]byte, error) {
+	line := make([]byte, 0, 64)
+	b := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return nil, err
+		}
+		line = append(line, b[0])
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			break
+		}
+		if len(line) > 16384 {
+			return nil, errors.New("Received line is too long")
+		}
+	}
+	return bytes.Split(line[:len(line)-2], []byte(" ")), nil
+}
+
+// serverAuthenticate performs the server side of the authentication
+// protocol, using the given mechanisms to verify the client.  On
+// success, it reports whether passing file descriptors was negotiated.
+func serverAuthenticate(conn net.Conn, guid string, mechanisms []func() serverAuthenticator) (unixFDs bool, err error) {
+	// The client starts by sending a nul byte.
+	zero := make([]byte, 1)
+	if _, err := io.ReadFull(conn, zero); err != nil {
+		return false, err
+	}
+	if zero[0] != 0 {
+		return false, errors.New("Expected nul byte at start of authentication")
+	}
+
+	names := make([][]byte, 0, len(mechanisms))
+	for _, newAuth := range mechanisms {
+		names = append(names, newAuth().Mechanism())
+	}
+	send := func(command ...[]byte) error {
+		msg := bytes.Join(command, []byte(" "))
+		// writing at this point does not need to be synced as the connection
+		// is not shared at this point.
+		_, err := conn.Write(append(msg, []byte("\r\n")...))
 		return err
 	}
-	return nil
+	rejected := func() error {
+		return send(append([][]byte{[]byte("REJECTED")}, names...)...)
+	}
+
+	var auth serverAuthenticator
+	success := false
+	process := func(response []byte) error {
+		decoded := make([]byte, hex.DecodedLen(len(response)))
+		n, err := hex.Decode(decoded, response)
+		if err != nil {
+			auth = nil
+			return send([]byte("ERROR"), []byte("Could not decode response"))
+		}
+		challenge, ok, err := auth.ProcessData(conn, decoded[:n])
+		switch {
+		case err != nil:
+			auth = nil
+			return rejected()
+		case ok:
+			success = true
+			return send([]byte("OK"), []byte(guid))
+		default:
+			challengeHex := make([]byte, hex.EncodedLen(len(challenge)))
+			hex.Encode(challengeHex, challenge)
+			return send([]byte("DATA"), challengeHex)
+		}
+	}
+
+	for {
+		command, err := readAuthLine(conn)
+		if err != nil {
+			return false, err
+		}
+		switch string(command[0]) {
+		case "AUTH":
+			if success {
+				err = send([]byte("ERROR"), []byte("Already authenticated"))
+				break
+			}
+			auth = nil
+			if len(command) >= 2 {
+				for _, newAuth := range mechanisms {
+					if candidate := newAuth(); bytes.Equal(candidate.Mechanism(), command[1]) {
+						auth = candidate
+						break
+					}
+				}
+			}
+			switch {
+			case auth == nil:
+				err = rejected()
+			case len(command) >= 3:
+				err = process(command[2])
+			default:
+				// No initial response: send an
+				// empty challenge.
+				err = send([]byte("DATA"))
+			}
+		case "DATA":
+			switch {
+			case success || auth == nil:
+				err = send([]byte("ERROR"), []byte("Unexpected DATA command"))
+			case len(command) >= 2:
+				err = process(command[1])
+			default:
+				err = process(nil)
+			}
+		case "CANCEL", "ERROR":
+			if success {
+				err = send([]byte("ERROR"), []byte("Already authenticated"))
+				break
+			}
+			auth = nil
+			err = rejected()
+		case "NEGOTIATE_UNIX_FD":
+			switch {
+			case !success:
+				err = send([]byte("ERROR"), []byte("Not yet authenticated"))
+			case !canPassFDs(conn):
+				err = send([]byte("ERROR"), []byte("File descriptor passing is not supported on this transport"))
+			default:
+				unixFDs = true
+				err = send([]byte("AGREE_UNIX_FD"))
+			}
+		case "BEGIN":
+			if !success {
+				return false, errors.New("Client sent BEGIN before authenticating")
+			}
+			return unixFDs, nil
+		default:
+			err = send([]byte("ERROR"), []byte("Unknown command"))
+		}
+		if err != nil {
+			return false, err
+		}
+	}
 }
@@ -2,8 +2,14 @@ package dbus
 
 import (
 	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
 	. "launchpad.net/gocheck"
 	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 )
 
 func (s *S) TestAuthenticate(c *C) {
@@ -27,9 +33,335 @@ func (s *S) TestAuthenticate(c *C) {
 		complete <- 1
 	}()
 
-	c.Check(authenticate(client, nil), Equals, nil)
+	_, err := authenticate(client, nil)
+	c.Check(err, Equals, nil)
 	<-complete
 	c.Check(clientWrites[0], Equals, "\x00")
 	c.Check(clientWrites[1][:13], Equals, "AUTH EXTERNAL")
 	c.Check(clientWrites[2], Equals, "BEGIN")
 }
+
+// testAuthenticator implements a mechanism for tests, recording the
+// challenges it is sent.
+type testAuthenticator struct {
+	mechanism       string
+	initialResponse []byte
+	response        []byte
+	challenges      [][]byte
+}
+
+func (p *testAuthenticator) Mechanism() []byte {
+	return []byte(p.mechanism)
+}
+
+func (p *testAuthenticator) InitialResponse() []byte {
+	return p.initialResponse
+}
+
+func (p *testAuthenticator) ProcessData(challenge []byte) ([]byte, error) {
+	p.challenges = append(p.challenges, challenge)
+	return p.response, nil
+}
+
+func (s *S) TestAuthenticateMechanisms(c *C) {
+	server, client := socketPair(c)
+	complete := make(chan error, 1)
+	go func() {
+		_, err := serverAuthenticate(server, "0123456789abcdef", []func() serverAuthenticator{
+			func() serverAuthenticator { return new(serverAuthExternal) }})
+		complete <- err
+	}()
+
+	// Mechanisms are tried in order, and the response to the
+	// challenge is hex encoded.
+	uid := strconv.Itoa(os.Geteuid())
+	external := &testAuthenticator{mechanism: "EXTERNAL", response: []byte(uid)}
+	_, err := authenticate(client, []Authenticator{
+		&testAuthenticator{mechanism: "UNKNOWN"}, external})
+	c.Check(err, IsNil)
+	c.Check(<-complete, IsNil)
+	c.Check(external.challenges, DeepEquals, [][]byte{nil})
+}
+
+func (s *S) TestAuthenticateRejected(c *C) {
+	server, client := socketPair(c)
+	defer client.Close()
+	go func() {
+		serverAuthenticate(server, "0123456789abcdef", []func() serverAuthenticator{
+			func() serverAuthenticator { return new(serverAuthExternal) }})
+		server.Close()
+	}()
+
+	// Mechanisms the server does not offer are not tried once it
+	// has listed those it supports.
+	_, err := authenticate(client, []Authenticator{
+		&testAuthenticator{mechanism: "UNKNOWN"},
+		&testAuthenticator{mechanism: "ANOTHER"},
+		&testAuthenticator{mechanism: "EXTERNAL", initialResponse: []byte("not-a-uid")}})
+	c.Assert(err, FitsTypeOf, &AuthError{})
+	c.Check(err.(*AuthError).Tried, DeepEquals, []string{"UNKNOWN", "EXTERNAL"})
+	c.Check(err.(*AuthError).Offered, DeepEquals, []string{"EXTERNAL"})
+	c.Check(err, ErrorMatches, "Could not authenticate with any mechanism \\(tried: UNKNOWN, EXTERNAL; server offers: EXTERNAL\\)")
+}
+
+func (s *S) TestServerAuthenticateAnonymous(c *C) {
+	server, client := net.Pipe()
+	complete := make(chan error, 1)
+	go func() {
+		_, err := serverAuthenticate(server, "0123456789abcdef", []func() serverAuthenticator{
+			func() serverAuthenticator { return new(serverAuthExternal) },
+			func() serverAuthenticator { return new(serverAuthAnonymous) }})
+		complete <- err
+	}()
+
+	r := bufio.NewReader(client)
+	send := func(line string) string {
+		client.Write([]byte(line + "\r\n"))
+		reply, _, _ := r.ReadLine()
+		return string(reply)
+	}
+	client.Write([]byte{0})
+	c.Check(send("AUTH"), Equals, "REJECTED EXTERNAL ANONYMOUS")
+	c.Check(send("AUTH ANONYMOUS "+hex.EncodeToString([]byte("bad\xff"))), Equals, "REJECTED EXTERNAL ANONYMOUS")
+
+	// The trace is optional.
+	c.Check(send("AUTH ANONYMOUS"), Equals, "DATA")
+	c.Check(send("DATA"), Equals, "OK 0123456789abcdef")
+
+	client.Write([]byte("BEGIN\r\n"))
+	c.Check(<-complete, IsNil)
+}
+
+func (s *S) TestAuthenticateAnonymous(c *C) {
+	for _, trace := range []string{"", "go-dbus test"} {
+		server, client := net.Pipe()
+		complete := make(chan error, 1)
+		go func() {
+			_, err := serverAuthenticate(server, "0123456789abcdef", []func() serverAuthenticator{
+				func() serverAuthenticator { return new(serverAuthAnonymous) }})
+			complete <- err
+		}()
+
+		_, err := authenticate(client, []Authenticator{&AuthAnonymous{Trace: trace}})
+		c.Check(err, IsNil)
+		c.Check(<-complete, IsNil)
+	}
+}
+
+func (s *S) TestAuthenticateCookieSha1(c *C) {
+	dir, err := defaultKeyringDir()
+	c.Assert(err, IsNil)
+
+	authenticateWith := func(keyringDir string) error {
+		server, client := net.Pipe()
+		defer client.Close()
+		complete := make(chan error, 1)
+		go func() {
+			_, err := serverAuthenticate(server, "0123456789abcdef", []func() serverAuthenticator{
+				func() serverAuthenticator {
+					return &serverAuthDbusCookieSha1{keyring: &cookieKeyring{keyringDir, defaultCookieContext}}
+				}})
+			server.Close()
+			complete <- err
+		}()
+		_, err := authenticate(client, []Authenticator{new(AuthDbusCookieSha1)})
+		client.Close()
+		<-complete
+		return err
+	}
+
+	// The client reads the cookie from the same keyring.
+	c.Check(authenticateWith(dir), IsNil)
+
+	// It can't prove itself if the server uses another keyring.
+	err = authenticateWith(filepath.Join(c.MkDir(), "other"))
+	c.Assert(err, FitsTypeOf, &AuthError{})
+	c.Check(err.(*AuthError).Tried, DeepEquals, []string{"DBUS_COOKIE_SHA1"})
+}
+
+func (s *S) TestServerAuthenticateCookieSha1(c *C) {
+	server, client := net.Pipe()
+	complete := make(chan error, 1)
+	keyring := &cookieKeyring{filepath.Join(c.MkDir(), "keyrings"), defaultCookieContext}
+	go func() {
+		_, err := serverAuthenticate(server, "0123456789abcdef", []func() serverAuthenticator{
+			func() serverAuthenticator { return &serverAuthDbusCookieSha1{keyring: keyring} }})
+		complete <- err
+	}()
+
+	r := bufio.NewReader(client)
+	send := func(line string) string {
+		client.Write([]byte(line + "\r\n"))
+		reply, _, _ := r.ReadLine()
+		return string(reply)
+	}
+	client.Write([]byte{0})
+
+	// Only the user running the server may authenticate.
+	c.Check(send("AUTH DBUS_COOKIE_SHA1 "+hex.EncodeToString([]byte("1234567"))), Equals, "REJECTED DBUS_COOKIE_SHA1")
+
+	uid := strconv.Itoa(os.Geteuid())
+	reply := send("AUTH DBUS_COOKIE_SHA1 " + hex.EncodeToString([]byte(uid)))
+	c.Assert(reply, Matches, "DATA [0-9a-f]+")
+	data, err := hex.DecodeString(reply[5:])
+	c.Assert(err, IsNil)
+	challenge := strings.Split(string(data), " ")
+	c.Assert(challenge, HasLen, 3)
+	c.Check(challenge[0], Equals, defaultCookieContext)
+	cookie, err := keyring.currentCookie()
+	c.Assert(err, IsNil)
+	c.Check(challenge[1], Equals, strconv.FormatUint(uint64(cookie.id), 10))
+
+	// A wrong hash is rejected.
+	c.Check(send("DATA "+hex.EncodeToString([]byte("abc 0000"))), Equals, "REJECTED DBUS_COOKIE_SHA1")
+
+	// The same cookie is used for the next attempt.
+	reply = send("AUTH DBUS_COOKIE_SHA1 " + hex.EncodeToString([]byte(uid)))
+	data, err = hex.DecodeString(reply[5:])
+	c.Assert(err, IsNil)
+	challenge = strings.Split(string(data), " ")
+	hash := sha1.Sum([]byte(challenge[2] + ":abc:" + cookie.cookie))
+	c.Check(send("DATA "+hex.EncodeToString([]byte("abc "+hex.EncodeToString(hash[:])))), Equals, "OK 0123456789abcdef")
+
+	client.Write([]byte("BEGIN\r\n"))
+	c.Check(<-complete, IsNil)
+}
+
+func (s *S) TestConnectAddress(c *C) {
+	bus, err := ConnectAddress(s.broker.Address(), &ConnectOptions{
+		Authenticators: []Authenticator{
+			&testAuthenticator{mechanism: "UNKNOWN"},
+			new(AuthExternal)}})
+	c.Assert(err, IsNil)
+	defer bus.Close()
+	c.Check(bus.UniqueName, Matches, ":1\\.[0-9]+")
+
+	_, err = ConnectAddress(s.broker.Address(), &ConnectOptions{
+		Authenticators: []Authenticator{&testAuthenticator{mechanism: "UNKNOWN"}}})
+	c.Check(err, FitsTypeOf, &AuthError{})
+}
+
+func (s *S) TestServerAuthenticate(c *C) {
+	server, client := socketPair(c)
+	complete := make(chan error, 1)
+	go func() {
+		_, err := serverAuthenticate(server, "0123456789abcdef", []func() serverAuthenticator{
+			func() serverAuthenticator { return new(serverAuthExternal) }})
+		complete <- err
+	}()
+
+	r := bufio.NewReader(client)
+	send := func(line string) string {
+		client.Write([]byte(line + "\r\n"))
+		reply, _, _ := r.ReadLine()
+		return string(reply)
+	}
+	client.Write([]byte{0})
+	c.Check(send("AUTH"), Equals, "REJECTED EXTERNAL")
+	c.Check(send("AUTH UNKNOWN"), Equals, "REJECTED EXTERNAL")
+	c.Check(send("AUTH EXTERNAL "+hex.EncodeToString([]byte("not-a-uid"))), Equals, "REJECTED EXTERNAL")
+
+	// Try again, sending the user ID in response to a challenge.
+	c.Check(send("AUTH EXTERNAL"), Equals, "DATA")
+	uid := strconv.Itoa(os.Geteuid())
+	c.Check(send("DATA "+hex.EncodeToString([]byte(uid))), Equals, "OK 0123456789abcdef")
+
+	client.Write([]byte("BEGIN\r\n"))
+	c.Check(<-complete, IsNil)
+}
+
+func (s *S) TestServerAuthenticateClient(c *C) {
+	server, client := socketPair(c)
+	complete := make(chan error, 1)
+	go func() {
+		_, err := serverAuthenticate(server, "0123456789abcdef", []func() serverAuthenticator{
+			func() serverAuthenticator { return new(serverAuthExternal) }})
+		complete <- err
+	}()
+
+	_, err := authenticate(client, nil)
+	c.Check(err, IsNil)
+	c.Check(<-complete, IsNil)
+}
+
+func (s *S) TestServerAuthenticateExternal(c *C) {
+	var allowed *Credentials
+	authenticateWith := func(server, client net.Conn, allow bool) error {
+		defer client.Close()
+		go func() {
+			serverAuthenticate(server, "0123456789abcdef", []func() serverAuthenticator{
+				func() serverAuthenticator {
+					return &serverAuthExternal{allow: func(creds *Credentials) bool {
+						allowed = creds
+						return allow
+					}}
+				}})
+			server.Close()
+		}()
+		_, err := authenticate(client, []Authenticator{new(AuthExternal)})
+		return err
+	}
+
+	// The client's credentials are checked.
+	server, client := socketPair(c)
+	c.Check(authenticateWith(server, client, false), FitsTypeOf, &AuthError{})
+	c.Assert(allowed, NotNil)
+	c.Check(allowed.Pid, Equals, os.Getpid())
+	c.Check(allowed.Uid, Equals, uint32(os.Geteuid()))
+	c.Check(allowed.Gid, Equals, uint32(os.Getegid()))
+	server, client = socketPair(c)
+	c.Check(authenticateWith(server, client, true), IsNil)
+
+	// EXTERNAL needs a unix socket.
+	allowed = nil
+	server, client = net.Pipe()
+	c.Check(authenticateWith(server, client, true), FitsTypeOf, &AuthError{})
+	c.Check(allowed, IsNil)
+}
+
+func (s *S) TestServerAuthenticateExternalMismatch(c *C) {
+	server, client := socketPair(c)
+	defer client.Close()
+	go func() {
+		serverAuthenticate(server, "0123456789abcdef", []func() serverAuthenticator{
+			func() serverAuthenticator {
+				return &serverAuthExternal{allow: func(*Credentials) bool { return true }}
+			}})
+		server.Close()
+	}()
+
+	r := bufio.NewReader(client)
+	send := func(line string) string {
+		client.Write([]byte(line + "\r\n"))
+		reply, _, _ := r.ReadLine()
+		return string(reply)
+	}
+	client.Write([]byte{0})
+	// A user ID other than that of the peer is refused, even if it
+	// would be allowed.
+	other := strconv.Itoa(os.Geteuid() + 1)
+	c.Check(send("AUTH EXTERNAL "+hex.EncodeToString([]byte(other))), Equals, "REJECTED EXTERNAL")
+	// Without a user ID, the peer credentials are used.
+	c.Check(send("AUTH EXTERNAL"), Equals, "DATA")
+	c.Check(send("DATA"), Equals, "OK 0123456789abcdef")
+}
+
+// socketPair returns both ends of a connected unix socket.
+func socketPair(c *C) (net.Conn, net.Conn) {
+	listener, err := net.Listen("unix", filepath.Join(c.MkDir(), "socket"))
+	c.Assert(err, IsNil)
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			c.Error(err)
+		}
+		accepted <- conn
+	}()
+	client, err := net.Dial("unix", listener.Addr().String())
+	c.Assert(err, IsNil)
+	server := <-accepted
+	c.Assert(server, NotNil)
+	return server, client
+}
@@ -0,0 +1,30 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package dbus
+
+import (
+	. "launchpad.net/gocheck"
+)
+
+func (s *S) TestAuthenticateUnixFDs(c *C) {
+	server, client := socketPair(c)
+	defer server.Close()
+	defer client.Close()
+
+	type result struct {
+		unixFDs bool
+		err     error
+	}
+	complete := make(chan result, 1)
+	go func() {
+		unixFDs, err := serverAuthenticate(server, "0123456789abcdef", []func() serverAuthenticator{
+			func() serverAuthenticator { return new(serverAuthExternal) }})
+		complete <- result{unixFDs, err}
+	}()
+
+	unixFDs, err := authenticate(client, nil)
+	c.Check(err, IsNil)
+	c.Check(unixFDs, Equals, true)
+	c.Check(<-complete, Equals, result{true, nil})
+}
@@ -0,0 +1,729 @@
+package dbus
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Broker is a minimal message bus daemon.
+//
+// It implements enough of the org.freedesktop.DBus interface to
+// route messages between its clients, manage well known bus names
+// and deliver signals according to match rules.  It is intended for
+// running tests and sandboxed services without a system or session
+// bus.
+type Broker struct {
+	server *Server
+
+	lock         sync.Mutex
+	closed       bool
+	lastClientId uint32
+	clients      map[string]*brokerClient
+	names        map[string]*brokerName
+	// Clients that have become monitors, which no longer appear in
+	// clients.
+	monitors []*brokerClient
+	// Whether to refuse BecomeMonitor as older buses do, leaving
+	// clients to eavesdrop instead.
+	noMonitoring bool
+}
+
+// brokerClient represents a connection to the broker.
+type brokerClient struct {
+	broker     *Broker
+	conn       net.Conn
+	reader     io.Reader
+	unixFDs    bool
+	creds      *Credentials
+	name       string
+	lastSerial uint32
+	matchRules []*MatchRule
+	// Whether the client has become a monitor, in which case
+	// matchRules select the messages it observes.
+	monitor bool
+
+	// Outgoing messages are queued so that delivering a message
+	// never blocks the broker.
+	queueLock sync.Mutex
+	queueCond *sync.Cond
+	queue     []*Message
+	closed    bool
+}
+
+// brokerName tracks the primary owner and queued owners of a well
+// known bus name.  The first entry of owners is the primary owner.
+type brokerName struct {
+	name   string
+	owners []*brokerNameOwner
+}
+
+type brokerNameOwner struct {
+	client *brokerClient
+	flags  NameFlags
+}
+
+// Result codes for RequestName and ReleaseName.
+const (
+	requestNameReplyPrimaryOwner = 1
+	requestNameReplyInQueue      = 2
+	requestNameReplyExists       = 3
+	requestNameReplyAlreadyOwner = 4
+
+	releaseNameReplyReleased    = 1
+	releaseNameReplyNonExistent = 2
+	releaseNameReplyNotOwner    = 3
+)
+
+// NewBroker starts a message bus listening on the given address.
+func NewBroker(address string) (*Broker, error) {
+	server, err := Listen(address)
+	if err != nil {
+		return nil, err
+	}
+	broker := &Broker{
+		server:  server,
+		clients: make(map[string]*brokerClient),
+		names:   make(map[string]*brokerName)}
+	go broker.acceptLoop()
+	return broker, nil
+}
+
+// Address returns the D-Bus address clients can use to connect to
+// the broker.
+func (b *Broker) Address() string {
+	return b.server.Address()
+}
+
+// Close stops the broker and disconnects all of its clients.
+func (b *Broker) Close() error {
+	b.lock.Lock()
+	b.closed = true
+	clients := make([]*brokerClient, 0, len(b.clients)+len(b.monitors))
+	for _, client := range b.clients {
+		clients = append(clients, client)
+	}
+	clients = append(clients, b.monitors...)
+	b.lock.Unlock()
+
+	err := b.server.Close()
+	for _, client := range clients {
+		client.conn.Close()
+	}
+	return err
+}
+
+func (b *Broker) acceptLoop() {
+	for {
+		conn, unixFDs, creds, err := b.server.acceptConn()
+		if err != nil {
+			b.lock.Lock()
+			closed := b.closed
+			b.lock.Unlock()
+			if !closed {
+				log.Println("Broker failed to accept connection:", err)
+			}
+			return
+		}
+		client := &brokerClient{
+			broker:  b,
+			conn:    conn,
+			reader:  newMessageReader(conn),
+			unixFDs: unixFDs,
+			creds:   creds}
+		client.queueCond = sync.NewCond(&client.queueLock)
+		go client.writeLoop()
+		go client.readLoop()
+	}
+}
+
+func (client *brokerClient) readLoop() {
+	defer client.disconnect()
+	for {
+		msg, err := readMessage(client.reader)
+		if err != nil {
+			if err != io.EOF {
+				client.broker.lock.Lock()
+				closed := client.broker.closed
+				client.broker.lock.Unlock()
+				if !closed {
+					log.Println("Broker failed to read message:", err)
+				}
+			}
+			return
+		}
+		if err := client.broker.route(client, msg); err != nil {
+			log.Println("Broker disconnecting client:", err)
+			return
+		}
+	}
+}
+
+// send queues a message for delivery to the client.  Messages
+// originating from the broker itself are copied and given a serial
+// number from the client's sequence.  Messages carrying file
+// descriptors are copied with duplicates of the descriptors, which
+// are closed once written.
+func (client *brokerClient) send(msg *Message) {
+	client.queueLock.Lock()
+	defer client.queueLock.Unlock()
+	if client.closed {
+		return
+	}
+	if msg.Sender == BUS_DAEMON_NAME {
+		copy := *msg
+		copy.serial = atomic.AddUint32(&client.lastSerial, 1)
+		msg = &copy
+	}
+	if len(msg.fds) != 0 {
+		copy := *msg
+		copy.fds = make([]int, 0, len(msg.fds))
+		for _, fd := range msg.fds {
+			dup, err := dupFD(fd)
+			if err != nil {
+				log.Println("Broker could not duplicate file descriptor:", err)
+				closeFDs(copy.fds)
+				return
+			}
+			copy.fds = append(copy.fds, dup)
+		}
+		msg = &copy
+	}
+	client.queue = append(client.queue, msg)
+	client.queueCond.Signal()
+}
+
+func (client *brokerClient) writeLoop() {
+	for {
+		client.queueLock.Lock()
+		for len(client.queue) == 0 && !client.closed {
+			client.queueCond.Wait()
+		}
+		if client.closed {
+			client.queueLock.Unlock()
+			return
+		}
+		msg := client.queue[0]
+		client.queue = client.queue[1:]
+		client.queueLock.Unlock()
+
+		err := writeMessage(client.conn, msg)
+		closeFDs(msg.fds)
+		if err != nil {
+			client.conn.Close()
+			return
+		}
+	}
+}
+
+func (client *brokerClient) disconnect() {
+	client.queueLock.Lock()
+	client.closed = true
+	for _, msg := range client.queue {
+		closeFDs(msg.fds)
+	}
+	client.queue = nil
+	client.queueCond.Signal()
+	client.queueLock.Unlock()
+	client.conn.Close()
+
+	b := client.broker
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if client.monitor {
+		for i, monitor := range b.monitors {
+			if monitor == client {
+				b.monitors = append(b.monitors[:i], b.monitors[i+1:]...)
+				break
+			}
+		}
+		return
+	}
+	if client.name == "" {
+		return
+	}
+	delete(b.clients, client.name)
+	// Give up all names owned by the client, in a predictable order.
+	names := make([]string, 0, len(b.names))
+	for name := range b.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b.releaseName(client, name)
+	}
+	b.emitNameOwnerChanged(client.name, client.name, "")
+}
+
+// route delivers a message received from a client.
+func (b *Broker) route(client *brokerClient, msg *Message) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	// Recipients are sent copies of any file descriptors.
+	defer closeFDs(msg.fds)
+
+	if client.monitor {
+		return errors.New("Monitor sent a message")
+	}
+	if client.name == "" {
+		if msg.Type != TypeMethodCall || msg.Dest != BUS_DAEMON_NAME || msg.Interface != BUS_DAEMON_IFACE || msg.Member != "Hello" {
+			return errors.New("Client did not call Hello")
+		}
+	}
+	msg.Sender = client.name
+	b.observe(msg)
+
+	if msg.Dest == BUS_DAEMON_NAME {
+		if msg.Type == TypeMethodCall {
+			b.handleBusMethod(client, msg)
+		}
+		return nil
+	}
+
+	if msg.Dest != "" {
+		recipient := b.clients[b.ownerOf(msg.Dest)]
+		if recipient == nil {
+			if msg.Type == TypeMethodCall && msg.Flags&FlagNoReplyExpected == 0 {
+				client.send(b.errorReply(msg, "org.freedesktop.DBus.Error.ServiceUnknown", "The name "+msg.Dest+" was not provided by any .service files"))
+			}
+			return nil
+		}
+		if len(msg.fds) != 0 && !recipient.unixFDs {
+			if msg.Type == TypeMethodCall && msg.Flags&FlagNoReplyExpected == 0 {
+				client.send(b.errorReply(msg, "org.freedesktop.DBus.Error.NotSupported", "The recipient does not support file descriptor passing"))
+			}
+			return nil
+		}
+		recipient.send(msg)
+		return nil
+	}
+
+	if msg.Type == TypeSignal {
+		b.broadcast(msg)
+	}
+	return nil
+}
+
+// broadcast delivers a message to each client with a matching rule.
+func (b *Broker) broadcast(msg *Message) {
+	for _, client := range b.sortedClients() {
+		if len(msg.fds) != 0 && !client.unixFDs {
+			continue
+		}
+		for _, rule := range client.matchRules {
+			if b.matches(rule, msg) {
+				client.send(msg)
+				break
+			}
+		}
+	}
+}
+
+// observe delivers a message sent by a client to monitors, and to
+// clients eavesdropping on messages addressed to others.  Messages
+// sent by the bus itself are not observed.
+func (b *Broker) observe(msg *Message) {
+	for _, monitor := range b.monitors {
+		if len(msg.fds) != 0 && !monitor.unixFDs {
+			continue
+		}
+		matched := len(monitor.matchRules) == 0
+		for _, rule := range monitor.matchRules {
+			if b.matches(rule, msg) {
+				matched = true
+				break
+			}
+		}
+		if matched {
+			monitor.send(msg)
+		}
+	}
+
+	// Broadcasts are delivered through match rules regardless of
+	// eavesdropping.
+	if msg.Dest == "" {
+		return
+	}
+	recipient := b.ownerOf(msg.Dest)
+	for _, client := range b.sortedClients() {
+		if client.name == recipient || client.name == msg.Sender || (len(msg.fds) != 0 && !client.unixFDs) {
+			continue
+		}
+		for _, rule := range client.matchRules {
+			if rule.Eavesdrop && b.matches(rule, msg) {
+				client.send(msg)
+				break
+			}
+		}
+	}
+}
+
+func (b *Broker) matches(rule *MatchRule, msg *Message) bool {
+	r := *rule
+	if r.Sender != "" && r.Sender[0] != ':' {
+		r.senderNameOwner = b.ownerOf(r.Sender)
+	}
+	return r.Match(msg)
+}
+
+func (b *Broker) sortedClients() []*brokerClient {
+	names := make([]string, 0, len(b.clients))
+	for name := range b.clients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	clients := make([]*brokerClient, 0, len(names))
+	for _, name := range names {
+		clients = append(clients, b.clients[name])
+	}
+	return clients
+}
+
+// ownerOf returns the unique name of the owner of the given bus name,
+// or an empty string if it has no owner.
+func (b *Broker) ownerOf(busName string) string {
+	if busName == BUS_DAEMON_NAME {
+		return BUS_DAEMON_NAME
+	}
+	if strings.HasPrefix(busName, ":") {
+		if _, ok := b.clients[busName]; ok {
+			return busName
+		}
+		return ""
+	}
+	if name, ok := b.names[busName]; ok && len(name.owners) > 0 {
+		return name.owners[0].client.name
+	}
+	return ""
+}
+
+func (b *Broker) newSignal(member string, args ...interface{}) *Message {
+	msg := NewSignalMessage(BUS_DAEMON_PATH, BUS_DAEMON_IFACE, member)
+	msg.Sender = BUS_DAEMON_NAME
+	if err := msg.AppendArgs(args...); err != nil {
+		panic(err)
+	}
+	return msg
+}
+
+func (b *Broker) emitNameOwnerChanged(name, oldOwner, newOwner string) {
+	b.broadcast(b.newSignal("NameOwnerChanged", name, oldOwner, newOwner))
+}
+
+func (b *Broker) emitNameAcquired(client *brokerClient, name string) {
+	msg := b.newSignal("NameAcquired", name)
+	msg.Dest = client.name
+	client.send(msg)
+}
+
+func (b *Broker) emitNameLost(client *brokerClient, name string) {
+	msg := b.newSignal("NameLost", name)
+	msg.Dest = client.name
+	client.send(msg)
+}
+
+func (b *Broker) errorReply(call *Message, errorName, message string) *Message {
+	reply := NewErrorMessage(call, errorName, message)
+	reply.Sender = BUS_DAEMON_NAME
+	return reply
+}
+
+// handleBusMethod implements the org.freedesktop.DBus interface.
+func (b *Broker) handleBusMethod(client *brokerClient, msg *Message) {
+	reply, err := b.callBusMethod(client, msg)
+	if msg.Flags&FlagNoReplyExpected != 0 || (reply == nil && err == nil) {
+		return
+	}
+	if err != nil {
+		dbusErr, ok := err.(*Error)
+		if !ok {
+			dbusErr = &Error{"org.freedesktop.DBus.Error.Failed", err.Error()}
+		}
+		client.send(b.errorReply(msg, dbusErr.Name, dbusErr.Message))
+		return
+	}
+	client.send(reply)
+}
+
+func (b *Broker) callBusMethod(client *brokerClient, msg *Message) (*Message, error) {
+	reply := NewMethodReturnMessage(msg)
+	reply.Sender = BUS_DAEMON_NAME
+	invalidArgs := func(err error) error {
+		return &Error{"org.freedesktop.DBus.Error.InvalidArgs", err.Error()}
+	}
+
+	switch {
+	case msg.Interface == "org.freedesktop.DBus.Peer" && msg.Member == "Ping":
+		return reply, nil
+	case msg.Interface == MONITORING_IFACE && msg.Member == "BecomeMonitor" && !b.noMonitoring:
+		var ruleStrings []string
+		var flags uint32
+		if err := msg.Args(&ruleStrings, &flags); err != nil {
+			return nil, invalidArgs(err)
+		}
+		var rules []*MatchRule
+		for _, ruleString := range ruleStrings {
+			rule, err := ParseMatchRule(ruleString)
+			if err != nil {
+				return nil, &Error{"org.freedesktop.DBus.Error.MatchRuleInvalid", err.Error()}
+			}
+			rules = append(rules, rule)
+		}
+		b.becomeMonitor(client, rules, reply)
+		return nil, nil
+	case msg.Interface != "" && msg.Interface != BUS_DAEMON_IFACE:
+		break
+	case msg.Member == "Hello":
+		if client.name != "" {
+			return nil, &Error{"org.freedesktop.DBus.Error.Failed", "Already handled an Hello message"}
+		}
+		b.lastClientId++
+		client.name = fmt.Sprintf(":1.%d", b.lastClientId)
+		b.clients[client.name] = client
+		reply.Dest = client.name
+		if err := reply.AppendArgs(client.name); err != nil {
+			return nil, err
+		}
+		// The reply must be sent before the signals announcing
+		// the new name.
+		client.send(reply)
+		b.emitNameOwnerChanged(client.name, "", client.name)
+		b.emitNameAcquired(client, client.name)
+		return nil, nil
+	case msg.Member == "RequestName":
+		var name string
+		var flags uint32
+		if err := msg.Args(&name, &flags); err != nil {
+			return nil, invalidArgs(err)
+		}
+		if name == "" || name[0] == ':' || name == BUS_DAEMON_NAME {
+			return nil, &Error{"org.freedesktop.DBus.Error.InvalidArgs", "Cannot acquire a service named '" + name + "'"}
+		}
+		err := reply.AppendArgs(b.requestName(client, name, NameFlags(flags)))
+		return reply, err
+	case msg.Member == "ReleaseName":
+		var name string
+		if err := msg.Args(&name); err != nil {
+			return nil, invalidArgs(err)
+		}
+		if name == "" || name[0] == ':' || name == BUS_DAEMON_NAME {
+			return nil, &Error{"org.freedesktop.DBus.Error.InvalidArgs", "Cannot release a service named '" + name + "'"}
+		}
+		err := reply.AppendArgs(b.releaseName(client, name))
+		return reply, err
+	case msg.Member == "GetNameOwner":
+		var name string
+		if err := msg.Args(&name); err != nil {
+			return nil, invalidArgs(err)
+		}
+		owner := b.ownerOf(name)
+		if owner == "" {
+			return nil, &Error{"org.freedesktop.DBus.Error.NameHasNoOwner", "Could not get owner of name '" + name + "': no such name"}
+		}
+		err := reply.AppendArgs(owner)
+		return reply, err
+	case msg.Member == "GetConnectionUnixUser", msg.Member == "GetConnectionUnixProcessID":
+		var name string
+		if err := msg.Args(&name); err != nil {
+			return nil, invalidArgs(err)
+		}
+		var creds *Credentials
+		if owner := b.ownerOf(name); owner == BUS_DAEMON_NAME {
+			creds = &Credentials{Pid: os.Getpid(), Uid: uint32(os.Geteuid()), Gid: uint32(os.Getegid())}
+		} else if client := b.clients[owner]; client != nil {
+			creds = client.creds
+		} else {
+			return nil, &Error{"org.freedesktop.DBus.Error.NameHasNoOwner", "Could not get owner of name '" + name + "': no such name"}
+		}
+		switch {
+		case creds == nil && msg.Member == "GetConnectionUnixProcessID":
+			return nil, &Error{"org.freedesktop.DBus.Error.UnixProcessIdUnknown", "Could not determine PID for '" + name + "'"}
+		case creds == nil:
+			return nil, &Error{"org.freedesktop.DBus.Error.Failed", "Could not determine UID for '" + name + "'"}
+		case msg.Member == "GetConnectionUnixProcessID":
+			return reply, reply.AppendArgs(uint32(creds.Pid))
+		}
+		return reply, reply.AppendArgs(creds.Uid)
+	case msg.Member == "NameHasOwner":
+		var name string
+		if err := msg.Args(&name); err != nil {
+			return nil, invalidArgs(err)
+		}
+		err := reply.AppendArgs(b.ownerOf(name) != "")
+		return reply, err
+	case msg.Member == "ListNames":
+		names := []string{BUS_DAEMON_NAME}
+		for name := range b.clients {
+			names = append(names, name)
+		}
+		for name, info := range b.names {
+			if len(info.owners) > 0 {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names[1:])
+		err := reply.AppendArgs(names)
+		return reply, err
+	case msg.Member == "ListActivatableNames":
+		err := reply.AppendArgs([]string{BUS_DAEMON_NAME})
+		return reply, err
+	case msg.Member == "ListQueuedOwners":
+		var name string
+		if err := msg.Args(&name); err != nil {
+			return nil, invalidArgs(err)
+		}
+		owners := []string{}
+		if info, ok := b.names[name]; ok {
+			for _, owner := range info.owners {
+				owners = append(owners, owner.client.name)
+			}
+		} else if owner := b.ownerOf(name); owner != "" {
+			owners = append(owners, owner)
+		}
+		if len(owners) == 0 {
+			return nil, &Error{"org.freedesktop.DBus.Error.NameHasNoOwner", "Could not get owners of name '" + name + "': no such name"}
+		}
+		err := reply.AppendArgs(owners)
+		return reply, err
+	case msg.Member == "AddMatch":
+		var ruleString string
+		if err := msg.Args(&ruleString); err != nil {
+			return nil, invalidArgs(err)
+		}
+		rule, err := ParseMatchRule(ruleString)
+		if err != nil {
+			return nil, &Error{"org.freedesktop.DBus.Error.MatchRuleInvalid", err.Error()}
+		}
+		client.matchRules = append(client.matchRules, rule)
+		return reply, nil
+	case msg.Member == "RemoveMatch":
+		var ruleString string
+		if err := msg.Args(&ruleString); err != nil {
+			return nil, invalidArgs(err)
+		}
+		rule, err := ParseMatchRule(ruleString)
+		if err != nil {
+			return nil, &Error{"org.freedesktop.DBus.Error.MatchRuleInvalid", err.Error()}
+		}
+		for i, other := range client.matchRules {
+			if other.String() == rule.String() {
+				client.matchRules = append(client.matchRules[:i], client.matchRules[i+1:]...)
+				return reply, nil
+			}
+		}
+		return nil, &Error{"org.freedesktop.DBus.Error.MatchRuleNotFound", "The given match rule wasn't found and can't be removed"}
+	case msg.Member == "GetId":
+		err := reply.AppendArgs(b.server.guid)
+		return reply, err
+	}
+	return nil, &Error{"org.freedesktop.DBus.Error.UnknownMethod", "Unknown method '" + msg.Member + "' on interface '" + msg.Interface + "'"}
+}
+
+// becomeMonitor turns a client into a monitor.  The client gives up
+// its names as if it had disconnected, after the reply is sent.
+func (b *Broker) becomeMonitor(client *brokerClient, rules []*MatchRule, reply *Message) {
+	client.send(reply)
+	names := make([]string, 0, len(b.names))
+	for name := range b.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b.releaseName(client, name)
+	}
+	delete(b.clients, client.name)
+	client.monitor = true
+	client.matchRules = rules
+	b.monitors = append(b.monitors, client)
+	b.emitNameOwnerChanged(client.name, client.name, "")
+	b.emitNameLost(client, client.name)
+}
+
+func (b *Broker) requestName(client *brokerClient, name string, flags NameFlags) uint32 {
+	info, ok := b.names[name]
+	if !ok || len(info.owners) == 0 {
+		b.names[name] = &brokerName{name, []*brokerNameOwner{{client, flags}}}
+		b.emitNameOwnerChanged(name, "", client.name)
+		b.emitNameAcquired(client, name)
+		return requestNameReplyPrimaryOwner
+	}
+
+	primary := info.owners[0]
+	if primary.client == client {
+		primary.flags = flags
+		return requestNameReplyAlreadyOwner
+	}
+
+	// Find any existing queue entry for this client.
+	var queued *brokerNameOwner
+	for i, owner := range info.owners {
+		if owner.client == client {
+			queued = owner
+			if flags&NameFlagDoNotQueue != 0 || (primary.flags&NameFlagAllowReplacement != 0 && flags&NameFlagReplaceExisting != 0) {
+				// The entry is replaced or dropped below.
+				info.owners = append(info.owners[:i], info.owners[i+1:]...)
+			}
+			break
+		}
+	}
+
+	if primary.flags&NameFlagAllowReplacement != 0 && flags&NameFlagReplaceExisting != 0 {
+		rest := info.owners[1:]
+		if primary.flags&NameFlagDoNotQueue == 0 {
+			// The old owner goes to the head of the queue.
+			rest = append([]*brokerNameOwner{primary}, rest...)
+		}
+		info.owners = append([]*brokerNameOwner{{client, flags}}, rest...)
+		b.emitNameLost(primary.client, name)
+		b.emitNameOwnerChanged(name, primary.client.name, client.name)
+		b.emitNameAcquired(client, name)
+		return requestNameReplyPrimaryOwner
+	}
+
+	if flags&NameFlagDoNotQueue != 0 {
+		return requestNameReplyExists
+	}
+	if queued != nil {
+		// A client already in the queue keeps its place.
+		queued.flags = flags
+		return requestNameReplyInQueue
+	}
+	info.owners = append(info.owners, &brokerNameOwner{client, flags})
+	return requestNameReplyInQueue
+}
+
+func (b *Broker) releaseName(client *brokerClient, name string) uint32 {
+	info, ok := b.names[name]
+	if !ok || len(info.owners) == 0 {
+		return releaseNameReplyNonExistent
+	}
+	for i, owner := range info.owners {
+		if owner.client != client {
+			continue
+		}
+		info.owners = append(info.owners[:i], info.owners[i+1:]...)
+		if i == 0 {
+			b.emitNameLost(client, name)
+			newOwner := ""
+			if len(info.owners) > 0 {
+				newOwner = info.owners[0].client.name
+			}
+			b.emitNameOwnerChanged(name, client.name, newOwner)
+			if len(info.owners) > 0 {
+				b.emitNameAcquired(info.owners[0].client, name)
+			}
+		}
+		if len(info.owners) == 0 {
+			delete(b.names, name)
+		}
+		return releaseNameReplyReleased
+	}
+	return releaseNameReplyNotOwner
+}
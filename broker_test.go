@@ -0,0 +1,231 @@
+package dbus
+
+import (
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+	"time"
+)
+
+func (s *S) TestBrokerHello(c *C) {
+	bus1, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer bus1.Close()
+	bus2, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer bus2.Close()
+
+	c.Check(bus1.UniqueName, Matches, ":1\\.[0-9]+")
+	c.Check(bus2.UniqueName, Matches, ":1\\.[0-9]+")
+	c.Check(bus1.UniqueName, Not(Equals), bus2.UniqueName)
+
+	// A second Hello call fails.
+	_, err = bus1.busProxy.Hello()
+	c.Check(err, NotNil)
+
+	names, err := bus1.busProxy.ListNames()
+	c.Assert(err, IsNil)
+	c.Check(names, DeepEquals, []string{BUS_DAEMON_NAME, bus1.UniqueName, bus2.UniqueName})
+
+	owner, err := bus1.busProxy.GetNameOwner(bus2.UniqueName)
+	c.Check(err, IsNil)
+	c.Check(owner, Equals, bus2.UniqueName)
+}
+
+func (s *S) TestBrokerNameOwnership(c *C) {
+	bus1, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer bus1.Close()
+	bus2, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer bus2.Close()
+
+	_, err = bus1.busProxy.GetNameOwner("com.example.GoDbus")
+	c.Check(err, FitsTypeOf, &Error{})
+	c.Check(err.(*Error).Name, Equals, "org.freedesktop.DBus.Error.NameHasNoOwner")
+
+	result, err := bus1.busProxy.RequestName("com.example.GoDbus", 0)
+	c.Check(err, IsNil)
+	c.Check(result, Equals, uint32(requestNameReplyPrimaryOwner))
+	result, err = bus1.busProxy.RequestName("com.example.GoDbus", 0)
+	c.Check(err, IsNil)
+	c.Check(result, Equals, uint32(requestNameReplyAlreadyOwner))
+	result, err = bus2.busProxy.RequestName("com.example.GoDbus", uint32(NameFlagDoNotQueue))
+	c.Check(err, IsNil)
+	c.Check(result, Equals, uint32(requestNameReplyExists))
+	result, err = bus2.busProxy.RequestName("com.example.GoDbus", 0)
+	c.Check(err, IsNil)
+	c.Check(result, Equals, uint32(requestNameReplyInQueue))
+
+	owners, err := bus1.busProxy.ListQueuedOwners("com.example.GoDbus")
+	c.Check(err, IsNil)
+	c.Check(owners, DeepEquals, []string{bus1.UniqueName, bus2.UniqueName})
+
+	// Releasing the name passes it to the next in the queue.
+	result, err = bus2.busProxy.ReleaseName("com.example.Other")
+	c.Check(err, IsNil)
+	c.Check(result, Equals, uint32(releaseNameReplyNonExistent))
+	result, err = bus1.busProxy.ReleaseName("com.example.GoDbus")
+	c.Check(err, IsNil)
+	c.Check(result, Equals, uint32(releaseNameReplyReleased))
+	result, err = bus1.busProxy.ReleaseName("com.example.GoDbus")
+	c.Check(err, IsNil)
+	c.Check(result, Equals, uint32(releaseNameReplyNotOwner))
+
+	owner, err := bus1.busProxy.GetNameOwner("com.example.GoDbus")
+	c.Check(err, IsNil)
+	c.Check(owner, Equals, bus2.UniqueName)
+
+	// The name is released when its owner disconnects.
+	watch, err := bus1.WatchName("com.example.GoDbus")
+	c.Assert(err, IsNil)
+	defer watch.Cancel()
+	c.Check(<-watch.C, Equals, bus2.UniqueName)
+	bus2.Close()
+	c.Check(<-watch.C, Equals, "")
+}
+
+func (s *S) TestBrokerNameRequeue(c *C) {
+	bus1, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer bus1.Close()
+	bus2, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer bus2.Close()
+	bus3, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer bus3.Close()
+
+	for _, bus := range []*Connection{bus1, bus2, bus3} {
+		_, err = bus.busProxy.RequestName("com.example.GoDbus", 0)
+		c.Assert(err, IsNil)
+	}
+
+	// Requesting the name again while queued updates the flags but
+	// keeps the place in the queue.
+	result, err := bus2.busProxy.RequestName("com.example.GoDbus", uint32(NameFlagAllowReplacement))
+	c.Check(err, IsNil)
+	c.Check(result, Equals, uint32(requestNameReplyInQueue))
+	owners, err := bus1.busProxy.ListQueuedOwners("com.example.GoDbus")
+	c.Check(err, IsNil)
+	c.Check(owners, DeepEquals, []string{bus1.UniqueName, bus2.UniqueName, bus3.UniqueName})
+
+	// Once bus2 owns the name, the new flags let bus3 replace it.
+	_, err = bus1.busProxy.ReleaseName("com.example.GoDbus")
+	c.Assert(err, IsNil)
+	result, err = bus3.busProxy.RequestName("com.example.GoDbus", uint32(NameFlagReplaceExisting))
+	c.Check(err, IsNil)
+	c.Check(result, Equals, uint32(requestNameReplyPrimaryOwner))
+	owners, err = bus1.busProxy.ListQueuedOwners("com.example.GoDbus")
+	c.Check(err, IsNil)
+	c.Check(owners, DeepEquals, []string{bus3.UniqueName, bus2.UniqueName})
+
+	// A queued request with DoNotQueue leaves the queue.
+	result, err = bus2.busProxy.RequestName("com.example.GoDbus", uint32(NameFlagDoNotQueue))
+	c.Check(err, IsNil)
+	c.Check(result, Equals, uint32(requestNameReplyExists))
+	owners, err = bus1.busProxy.ListQueuedOwners("com.example.GoDbus")
+	c.Check(err, IsNil)
+	c.Check(owners, DeepEquals, []string{bus3.UniqueName})
+}
+
+func (s *S) TestBrokerServiceUnknown(c *C) {
+	bus, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer bus.Close()
+
+	_, err = bus.Object("com.example.GoDbus", "/").Call("org.freedesktop.DBus.Peer", "Ping")
+	c.Check(err, FitsTypeOf, &Error{})
+	c.Check(err.(*Error).Name, Equals, "org.freedesktop.DBus.Error.ServiceUnknown")
+}
+
+func (s *S) TestBrokerMatchRules(c *C) {
+	bus, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer bus.Close()
+
+	c.Check(bus.busProxy.AddMatch("type='signal',member='Foo'"), IsNil)
+	c.Check(bus.busProxy.RemoveMatch("type='signal',member='Foo'"), IsNil)
+
+	err = bus.busProxy.RemoveMatch("type='signal',member='Foo'")
+	c.Check(err, FitsTypeOf, &Error{})
+	c.Check(err.(*Error).Name, Equals, "org.freedesktop.DBus.Error.MatchRuleNotFound")
+
+	err = bus.busProxy.AddMatch("type='bogus'")
+	c.Check(err, FitsTypeOf, &Error{})
+	c.Check(err.(*Error).Name, Equals, "org.freedesktop.DBus.Error.MatchRuleInvalid")
+}
+
+func (s *S) TestBrokerRequiresHello(c *C) {
+	trans, err := newTransport(s.broker.Address())
+	c.Assert(err, IsNil)
+	conn, err := trans.Dial()
+	c.Assert(err, IsNil)
+	defer conn.Close()
+	_, err = authenticate(conn, nil)
+	c.Assert(err, IsNil)
+
+	// The broker disconnects clients that send a message before
+	// calling Hello.
+	msg := NewMethodCallMessage(BUS_DAEMON_NAME, BUS_DAEMON_PATH, BUS_DAEMON_IFACE, "GetId")
+	msg.setSerial(1)
+	_, err = msg.WriteTo(conn)
+	c.Assert(err, IsNil)
+	_, err = readMessage(conn)
+	c.Check(err, NotNil)
+}
+
+func (s *S) TestBrokerIdleClient(c *C) {
+	// A client that connects but never authenticates does not
+	// hold up other clients.
+	trans, err := newTransport(s.broker.Address())
+	c.Assert(err, IsNil)
+	idle, err := trans.Dial()
+	c.Assert(err, IsNil)
+	defer idle.Close()
+
+	start := time.Now()
+	bus, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer bus.Close()
+	c.Check(bus.UniqueName, Matches, ":1\\.[0-9]+")
+	c.Check(time.Since(start) < 5*time.Second, Equals, true)
+}
+
+type fdTest struct {
+	fd uintptr
+}
+
+func (t *fdTest) Open() UnixFD {
+	return UnixFD(t.fd)
+}
+
+func (s *S) TestBrokerUnixFD(c *C) {
+	server, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer server.Close()
+	client, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer client.Close()
+	c.Check(client.unixFDs, Equals, true)
+
+	r, w, err := os.Pipe()
+	c.Assert(err, IsNil)
+	defer r.Close()
+	_, err = w.Write([]byte("hello"))
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+
+	c.Assert(server.Export(&fdTest{r.Fd()}, "/go/dbus/test", "com.example.GoDbus"), IsNil)
+	reply, err := client.Object(server.UniqueName, "/go/dbus/test").Call("com.example.GoDbus", "Open")
+	c.Assert(err, IsNil)
+	var fd UnixFD
+	c.Assert(reply.Args(&fd), IsNil)
+	c.Check(uintptr(fd), Not(Equals), r.Fd())
+
+	file := os.NewFile(uintptr(fd), "pipe")
+	defer file.Close()
+	data, err := ioutil.ReadAll(file)
+	c.Check(err, IsNil)
+	c.Check(string(data), Equals, "hello")
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"launchpad.net/go-dbus/v1"
+	"strings"
+)
+
+const dbusImport = "launchpad.net/go-dbus/v1"
+
+// generator accumulates the generated source for a package.
+type generator struct {
+	buf     bytes.Buffer
+	imports map[string]bool
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// generate returns the formatted Go source for client and/or server
+// bindings to the given interfaces.
+func generate(packageName string, interfaces []dbus.InterfaceInfo, client, server bool) ([]byte, error) {
+	g := &generator{imports: map[string]bool{dbusImport: true}}
+	typeNames := make(map[string]string)
+	for _, iface := range interfaces {
+		typeName := interfaceTypeName(iface.Name)
+		if other, ok := typeNames[typeName]; ok {
+			return nil, fmt.Errorf("Interfaces %s and %s would both generate type %s", other, iface.Name, typeName)
+		}
+		typeNames[typeName] = iface.Name
+		g.printf("\n// %sInterface is the name of the %s D-Bus interface.\n", typeName, iface.Name)
+		g.printf("const %sInterface = %q\n", typeName, iface.Name)
+		if client {
+			if err := g.generateClient(typeName, &iface); err != nil {
+				return nil, fmt.Errorf("Interface %s: %v", iface.Name, err)
+			}
+		}
+		if server {
+			if err := g.generateServer(typeName, &iface); err != nil {
+				return nil, fmt.Errorf("Interface %s: %v", iface.Name, err)
+			}
+		}
+	}
+
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "// Code generated by dbus-codegen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&src, "package %s\n\n", packageName)
+	fmt.Fprintf(&src, "import (\n")
+	for _, path := range []string{"log", "sync", dbusImport} {
+		if g.imports[path] {
+			fmt.Fprintf(&src, "%q\n", path)
+		}
+	}
+	fmt.Fprintf(&src, ")\n")
+	src.Write(g.buf.Bytes())
+	return format.Source(src.Bytes())
+}
+
+// interfaceTypeName returns the Go type name for an interface, based
+// on the last component of its name.
+func interfaceTypeName(name string) string {
+	return exportedName(name[strings.LastIndex(name, ".")+1:])
+}
+
+// Identifiers used in the bodies of generated functions, which
+// argument names must avoid.
+var reservedNames = []string{"o", "w", "adapter", "conn", "path", "reply", "err", "value", "watch", "msg", "signal", "dbus", "log"}
+
+func newNameSet() nameSet {
+	names := make(nameSet)
+	for _, name := range reservedNames {
+		names[name] = true
+	}
+	return names
+}
+
+type goArg struct {
+	name, goType string
+}
+
+// goArgs converts D-Bus arguments to Go names and types.
+func goArgs(names nameSet, args []dbus.ArgInfo, prefix string) ([]goArg, error) {
+	result := make([]goArg, len(args))
+	for i, arg := range args {
+		t, err := goType(string(arg.Type))
+		if err != nil {
+			return nil, fmt.Errorf("Argument %s: %v", arg.Name, err)
+		}
+		result[i] = goArg{argName(names, arg.Name, prefix, i), t}
+	}
+	return result, nil
+}
+
+// params formats arguments as a parameter list.
+func params(args []goArg) string {
+	list := make([]string, len(args))
+	for i, arg := range args {
+		list[i] = arg.name + " " + arg.goType
+	}
+	return strings.Join(list, ", ")
+}
+
+// argNames formats a list of argument names, each with the given
+// prefix.
+func argNames(args []goArg, prefix string) string {
+	list := make([]string, len(args))
+	for i, arg := range args {
+		list[i] = prefix + arg.name
+	}
+	return strings.Join(list, ", ")
+}
+
+// methodArgs returns the input and output arguments of a method.
+func methodArgs(method *dbus.MethodInfo) (in, out []goArg, err error) {
+	names := newNameSet()
+	if in, err = goArgs(names, method.InArgs(), "arg"); err != nil {
+		return nil, nil, fmt.Errorf("Method %s: %v", method.Name, err)
+	}
+	if out, err = goArgs(names, method.OutArgs(), "out"); err != nil {
+		return nil, nil, fmt.Errorf("Method %s: %v", method.Name, err)
+	}
+	return in, out, nil
+}
+
+// signalArgs returns the arguments of a signal.
+func signalArgs(signal *dbus.SignalInfo) ([]goArg, error) {
+	args, err := goArgs(newNameSet(), signal.Args, "arg")
+	if err != nil {
+		return nil, fmt.Errorf("Signal %s: %v", signal.Name, err)
+	}
+	return args, nil
+}
+
+func (g *generator) generateClient(typeName string, iface *dbus.InterfaceInfo) error {
+	g.printf("\n// %s is a client for the %s D-Bus interface.\n", typeName, iface.Name)
+	g.printf("type %s struct {\n*dbus.ObjectProxy\n}\n", typeName)
+
+	// Method names must not clash with those of ObjectProxy.
+	members := nameSet{"ObjectProxy": true, "Call": true, "CallWithContext": true, "WatchSignal": true, "ObjectPath": true}
+	for i := range iface.Methods {
+		if err := g.generateMethod(typeName, members, iface, &iface.Methods[i]); err != nil {
+			return err
+		}
+	}
+	for i := range iface.Properties {
+		if err := g.generateProperty(typeName, members, &iface.Properties[i]); err != nil {
+			return err
+		}
+	}
+	for i := range iface.Signals {
+		if err := g.generateSignalWatch(typeName, members, &iface.Signals[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *generator) generateMethod(typeName string, members nameSet, iface *dbus.InterfaceInfo, method *dbus.MethodInfo) error {
+	in, out, err := methodArgs(method)
+	if err != nil {
+		return err
+	}
+	funcName := members.add(exportedName(method.Name))
+
+	callArgs := fmt.Sprintf("%sInterface, %q", typeName, method.Name)
+	if len(in) > 0 {
+		callArgs += ", " + argNames(in, "")
+	}
+	g.printf("\n// %s calls the %s method.\n", funcName, method.Name)
+	if len(out) == 0 {
+		g.printf("func (o *%s) %s(%s) (err error) {\n", typeName, funcName, params(in))
+		g.printf("_, err = o.ObjectProxy.Call(%s)\n", callArgs)
+		g.printf("return\n}\n")
+		return nil
+	}
+	g.printf("func (o *%s) %s(%s) (%s, err error) {\n", typeName, funcName, params(in), params(out))
+	g.printf("reply, err := o.ObjectProxy.Call(%s)\n", callArgs)
+	g.printf("if err != nil {\nreturn\n}\n")
+	g.printf("err = reply.Args(%s)\n", argNames(out, "&"))
+	g.printf("return\n}\n")
+	return nil
+}
+
+func (g *generator) generateProperty(typeName string, members nameSet, property *dbus.PropertyInfo) error {
+	t, err := goType(string(property.Type))
+	if err != nil {
+		return fmt.Errorf("Property %s: %v", property.Name, err)
+	}
+	name := exportedName(property.Name)
+	if property.Readable() {
+		funcName := members.add("Get" + name)
+		g.printf("\n// %s returns the value of the %s property.\n", funcName, property.Name)
+		g.printf("func (o *%s) %s() (value %s, err error) {\n", typeName, funcName, t)
+		g.printf("reply, err := o.ObjectProxy.Call(\"org.freedesktop.DBus.Properties\", \"Get\", %sInterface, %q)\n", typeName, property.Name)
+		g.printf("if err != nil {\nreturn\n}\n")
+		g.printf("err = reply.Args(&value)\n")
+		g.printf("return\n}\n")
+	}
+	if property.Writable() {
+		funcName := members.add("Set" + name)
+		variant := "dbus.Variant{Value: value}"
+		if t == "dbus.Variant" {
+			variant = "value"
+		}
+		g.printf("\n// %s sets the value of the %s property.\n", funcName, property.Name)
+		g.printf("func (o *%s) %s(value %s) (err error) {\n", typeName, funcName, t)
+		g.printf("_, err = o.ObjectProxy.Call(\"org.freedesktop.DBus.Properties\", \"Set\", %sInterface, %q, %s)\n", typeName, property.Name, variant)
+		g.printf("return\n}\n")
+	}
+	return nil
+}
+
+func (g *generator) generateSignalWatch(typeName string, members nameSet, signal *dbus.SignalInfo) error {
+	fieldNames := make(nameSet)
+	fields := make([]goArg, len(signal.Args))
+	for i, arg := range signal.Args {
+		t, err := goType(string(arg.Type))
+		if err != nil {
+			return fmt.Errorf("Signal %s: argument %s: %v", signal.Name, arg.Name, err)
+		}
+		name := fmt.Sprintf("Arg%d", i)
+		if arg.Name != "" {
+			name = exportedName(arg.Name)
+		}
+		fields[i] = goArg{fieldNames.add(name), t}
+	}
+	signalName := exportedName(signal.Name)
+	signalType := typeName + signalName + "Signal"
+	watchType := typeName + signalName + "Watch"
+	funcName := members.add("Watch" + signalName)
+	g.imports["log"] = true
+	g.imports["sync"] = true
+
+	g.printf("\n// %s holds the arguments of the %s signal.\n", signalType, signal.Name)
+	g.printf("type %s struct {\n", signalType)
+	for _, field := range fields {
+		g.printf("%s %s\n", field.name, field.goType)
+	}
+	g.printf("}\n")
+
+	g.printf("\n// %s delivers %s signals on its channel, which is closed\n", watchType, signal.Name)
+	g.printf("// when the watch is cancelled.\n")
+	g.printf("type %s struct {\n", watchType)
+	g.printf("watch *dbus.SignalWatch\n")
+	g.printf("C chan *%s\n", signalType)
+	g.printf("done chan struct{}\n")
+	g.printf("doneOnce sync.Once\n")
+	g.printf("}\n")
+
+	g.printf("\n// %s watches for the %s signal from the object.\n", funcName, signal.Name)
+	g.printf("func (o *%s) %s() (*%s, error) {\n", typeName, funcName, watchType)
+	g.printf("watch, err := o.ObjectProxy.WatchSignal(%sInterface, %q)\n", typeName, signal.Name)
+	g.printf("if err != nil {\nreturn nil, err\n}\n")
+	g.printf("w := &%s{watch: watch, C: make(chan *%s), done: make(chan struct{})}\n", watchType, signalType)
+	g.printf("go func() {\n")
+	g.printf("for msg := range watch.C {\n")
+	g.printf("var signal %s\n", signalType)
+	g.printf("if err := msg.Args(%s); err != nil {\n", argNames(fields, "&signal."))
+	g.printf("log.Println(\"Could not decode %s signal:\", err)\n", signal.Name)
+	g.printf("continue\n}\n")
+	// Signals arriving after Cancel are dropped rather than
+	// blocking on a channel that is no longer read.
+	g.printf("select {\ncase w.C <- &signal:\ncase <-w.done:\n}\n")
+	g.printf("}\n")
+	g.printf("close(w.C)\n")
+	g.printf("}()\n")
+	g.printf("return w, nil\n}\n")
+
+	g.printf("\n// Cancel stops watching for the signal.\n")
+	g.printf("func (w *%s) Cancel() error {\n", watchType)
+	g.printf("w.doneOnce.Do(func() {\nclose(w.done)\n})\n")
+	g.printf("return w.watch.Cancel()\n}\n")
+	return nil
+}
+
+// The dbus.EmitsChanged constants for each value of the
+// EmitsChangedSignal annotation.  The default is left out.
+var emitsChangedConstants = map[string]string{
+	"true":        "",
+	"invalidates": "dbus.EmitsChangedInvalidates",
+	"const":       "dbus.EmitsChangedConst",
+	"false":       "dbus.EmitsChangedFalse",
+}
+
+func (g *generator) generateServer(typeName string, iface *dbus.InterfaceInfo) error {
+	serverType := typeName + "Server"
+	adapterType := strings.ToLower(typeName[:1]) + typeName[1:] + "Adapter"
+
+	type serverMethod struct {
+		name    string
+		in, out []goArg
+	}
+	// Server method names must match the D-Bus method names, so
+	// property accessors are named around them.
+	members := make(nameSet)
+	methods := make([]serverMethod, len(iface.Methods))
+	for i := range iface.Methods {
+		method := &iface.Methods[i]
+		// Exported methods are dispatched by their Go name, so
+		// it must match the D-Bus name.
+		if exportedName(method.Name) != method.Name {
+			return fmt.Errorf("Method %s can not be implemented in Go", method.Name)
+		}
+		in, out, err := methodArgs(method)
+		if err != nil {
+			return err
+		}
+		methods[i] = serverMethod{method.Name, in, out}
+		members[method.Name] = true
+	}
+
+	type serverProperty struct {
+		name, goType, getter, setter, emitsChanged string
+	}
+	properties := make([]serverProperty, len(iface.Properties))
+	for i := range iface.Properties {
+		property := &iface.Properties[i]
+		t, err := goType(string(property.Type))
+		if err != nil {
+			return fmt.Errorf("Property %s: %v", property.Name, err)
+		}
+		emitsChanged, ok := emitsChangedConstants[propertyEmitsChanged(iface, property)]
+		if !ok {
+			return fmt.Errorf("Property %s: invalid %s annotation %q", property.Name, emitsChangedAnnotation, propertyEmitsChanged(iface, property))
+		}
+		properties[i] = serverProperty{name: property.Name, goType: t, emitsChanged: emitsChanged}
+		name := exportedName(property.Name)
+		if property.Readable() {
+			properties[i].getter = members.add("Get" + name)
+		}
+		if property.Writable() {
+			properties[i].setter = members.add("Set" + name)
+		}
+	}
+
+	g.printf("\n// %s is implemented by objects providing the %s\n", serverType, iface.Name)
+	g.printf("// D-Bus interface.  A non-nil error returned by a method is sent\n")
+	g.printf("// as an error reply.\n")
+	g.printf("type %s interface {\n", serverType)
+	for _, method := range methods {
+		results := "error"
+		if len(method.out) > 0 {
+			results = "(" + params(method.out) + ", err error)"
+		}
+		g.printf("%s(%s) %s\n", method.name, params(method.in), results)
+	}
+	for _, property := range properties {
+		if property.getter != "" {
+			g.printf("%s() (value %s, err error)\n", property.getter, property.goType)
+		}
+		if property.setter != "" {
+			g.printf("%s(value %s) error\n", property.setter, property.goType)
+		}
+	}
+	g.printf("}\n")
+
+	g.printf("\n// %s exposes only the methods of the D-Bus interface.\n", adapterType)
+	g.printf("type %s struct {\nserver %s\n}\n", adapterType, serverType)
+	for _, method := range methods {
+		results := "error"
+		if len(method.out) > 0 {
+			types := make([]string, len(method.out))
+			for i, arg := range method.out {
+				types[i] = arg.goType
+			}
+			results = "(" + strings.Join(types, ", ") + ", error)"
+		}
+		g.printf("\nfunc (adapter *%s) %s(%s) %s {\n", adapterType, method.name, params(method.in), results)
+		g.printf("return adapter.server.%s(%s)\n}\n", method.name, argNames(method.in, ""))
+	}
+
+	g.printf("\n// Export%s makes server available as the %s\n", typeName, iface.Name)
+	g.printf("// interface of the object at path.  Method calls with arguments not\n")
+	g.printf("// matching the interface are rejected.\n")
+	g.printf("func Export%s(conn *dbus.Connection, path dbus.ObjectPath, server %s) error {\n", typeName, serverType)
+	if len(properties) == 0 {
+		g.printf("return conn.Export(&%s{server}, path, %sInterface)\n}\n", adapterType, typeName)
+	} else {
+		g.printf("if err := conn.Export(&%s{server}, path, %sInterface); err != nil {\nreturn err\n}\n", adapterType, typeName)
+		g.printf("err := conn.ExportProperties(path, %sInterface,\n", typeName)
+		for _, property := range properties {
+			fields := []string{fmt.Sprintf("Name: %q", property.name)}
+			if property.getter != "" {
+				fields = append(fields, "Get: server."+property.getter)
+			}
+			if property.setter != "" {
+				fields = append(fields, "Set: server."+property.setter)
+			}
+			if property.emitsChanged != "" {
+				fields = append(fields, "EmitsChanged: "+property.emitsChanged)
+			}
+			g.printf("dbus.Property{%s},\n", strings.Join(fields, ", "))
+		}
+		g.printf(")\n")
+		g.printf("if err != nil {\nconn.Unexport(path, %sInterface)\n}\n", typeName)
+		g.printf("return err\n}\n")
+	}
+
+	for i := range iface.Signals {
+		signal := &iface.Signals[i]
+		args, err := signalArgs(signal)
+		if err != nil {
+			return err
+		}
+		funcName := "Emit" + typeName + exportedName(signal.Name)
+		g.printf("\n// %s emits the %s signal from the object at path.\n", funcName, signal.Name)
+		g.printf("func %s(conn *dbus.Connection, path dbus.ObjectPath", funcName)
+		if len(args) > 0 {
+			g.printf(", %s", params(args))
+		}
+		g.printf(") error {\n")
+		g.printf("signal := dbus.NewSignalMessage(path, %sInterface, %q)\n", typeName, signal.Name)
+		if len(args) > 0 {
+			g.printf("if err := signal.AppendArgs(%s); err != nil {\nreturn err\n}\n", argNames(args, ""))
+		}
+		g.printf("return conn.Send(signal)\n}\n")
+	}
+	return nil
+}
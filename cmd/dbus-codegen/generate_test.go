@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"launchpad.net/go-dbus/v1"
+	. "launchpad.net/gocheck"
+)
+
+var update = flag.Bool("update", false, "update the expected output of the generator tests")
+
+// checkGenerated compares the code generated for an introspection
+// file with the expected output.
+func checkGenerated(c *C, input, expected string, client, server bool) {
+	data, err := ioutil.ReadFile(input)
+	c.Assert(err, IsNil)
+	interfaces, err := parseIntrospection(data)
+	c.Assert(err, IsNil)
+	src, err := generate("sample", interfaces, client, server)
+	c.Assert(err, IsNil)
+	if *update {
+		c.Assert(ioutil.WriteFile(expected, src, 0644), IsNil)
+	}
+	golden, err := ioutil.ReadFile(expected)
+	c.Assert(err, IsNil)
+	c.Check(string(src), Equals, string(golden))
+}
+
+func (s *S) TestGenerateClient(c *C) {
+	checkGenerated(c, "testdata/example.xml", "testdata/example.golden", true, false)
+}
+
+func (s *S) TestGenerateServer(c *C) {
+	checkGenerated(c, "testdata/example.xml", "testdata/example_server.golden", false, true)
+}
+
+func (s *S) TestGenerateServerUnexportableMethod(c *C) {
+	_, err := generate("sample", []dbus.InterfaceInfo{{
+		Name:    "com.example.Sample",
+		Methods: []dbus.MethodInfo{{Name: "do_thing"}}}}, false, true)
+	c.Check(err, ErrorMatches, "Interface com.example.Sample: Method do_thing can not be implemented in Go")
+}
+
+func (s *S) TestParseIntrospection(c *C) {
+	data, err := ioutil.ReadFile("testdata/example.xml")
+	c.Assert(err, IsNil)
+	interfaces, err := parseIntrospection(data)
+	c.Assert(err, IsNil)
+	// Standard interfaces and duplicates in child nodes are
+	// skipped.
+	c.Assert(interfaces, HasLen, 1)
+	iface := interfaces[0]
+	c.Check(iface.Name, Equals, "com.example.GoDbus.Sample")
+	c.Check(iface.Methods, HasLen, 3)
+	c.Check(iface.Methods[1].InArgs(), DeepEquals, []dbus.ArgInfo{{Name: "keys", Type: "as"}, {Name: "type", Type: "s"}})
+	c.Check(iface.Methods[1].OutArgs(), DeepEquals, []dbus.ArgInfo{{Type: "a{sv}", Direction: "out"}, {Name: "found", Type: "a(ob)", Direction: "out"}})
+	c.Check(iface.Properties[0].Readable(), Equals, true)
+	c.Check(iface.Properties[0].Writable(), Equals, false)
+	c.Check(iface.Properties[1].Writable(), Equals, true)
+	c.Check(iface.Properties[2].Readable(), Equals, false)
+	c.Check(iface.Signals, HasLen, 1)
+}
+
+func (s *S) TestGenerateNameClash(c *C) {
+	_, err := generate("sample", []dbus.InterfaceInfo{
+		{Name: "com.example.One.Sample"},
+		{Name: "com.example.Two.Sample"}}, true, false)
+	c.Check(err, ErrorMatches, "Interfaces com.example.One.Sample and com.example.Two.Sample would both generate type Sample")
+}
+
+func (s *S) TestGenerateInvalidType(c *C) {
+	_, err := generate("sample", []dbus.InterfaceInfo{{
+		Name:    "com.example.Sample",
+		Methods: []dbus.MethodInfo{{Name: "Foo", Args: []dbus.ArgInfo{{Name: "bar", Type: "a{s", Direction: "in"}}}}}}, true, false)
+	c.Check(err, ErrorMatches, "Interface com.example.Sample: Method Foo: Argument bar: .*")
+}
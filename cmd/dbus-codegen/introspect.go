@@ -0,0 +1,54 @@
+package main
+
+import (
+	"launchpad.net/go-dbus/v1"
+)
+
+// Interfaces provided by every object, which the dbus package
+// already supports.
+var standardInterfaces = map[string]bool{
+	"org.freedesktop.DBus.Peer": true,
+	dbus.INTROSPECTABLE_IFACE:   true,
+	dbus.PROPERTIES_IFACE:       true,
+}
+
+// parseIntrospection returns the interfaces described by an
+// introspection document, including those of child nodes.  Standard
+// interfaces are skipped.
+func parseIntrospection(data []byte) ([]dbus.InterfaceInfo, error) {
+	node, err := dbus.ParseIntrospection(data)
+	if err != nil {
+		return nil, err
+	}
+	var interfaces []dbus.InterfaceInfo
+	seen := make(map[string]bool)
+	var collect func(node *dbus.NodeInfo)
+	collect = func(node *dbus.NodeInfo) {
+		for _, iface := range node.Interfaces {
+			if standardInterfaces[iface.Name] || seen[iface.Name] {
+				continue
+			}
+			seen[iface.Name] = true
+			interfaces = append(interfaces, iface)
+		}
+		for i := range node.Children {
+			collect(&node.Children[i])
+		}
+	}
+	collect(node)
+	return interfaces, nil
+}
+
+const emitsChangedAnnotation = "org.freedesktop.DBus.Property.EmitsChangedSignal"
+
+// propertyEmitsChanged returns the value of the EmitsChangedSignal annotation
+// for a property, which may be inherited from its interface.
+func propertyEmitsChanged(iface *dbus.InterfaceInfo, property *dbus.PropertyInfo) string {
+	if value, ok := property.Annotation(emitsChangedAnnotation); ok {
+		return value
+	}
+	if value, ok := iface.Annotation(emitsChangedAnnotation); ok {
+		return value
+	}
+	return "true"
+}
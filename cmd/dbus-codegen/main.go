@@ -0,0 +1,96 @@
+// Dbus-codegen generates Go bindings for D-Bus interfaces from
+// introspection XML.
+//
+// Usage:
+//
+//	dbus-codegen [-package name] [-o output.go] [-interfaces names] [-client] [-server] file.xml...
+//
+// For each interface described in the input files, a client type
+// embedding *dbus.ObjectProxy is generated, with a method for each
+// D-Bus method, Get and Set methods for each property, and a Watch
+// method for each signal delivering the signal arguments as a struct.
+// The standard interfaces provided by every object are skipped.
+//
+// With -server, a Go interface for implementations of each D-Bus
+// interface is generated, along with a function exporting an
+// implementation on a connection and functions emitting each signal.
+// Properties are implemented through Get and Set methods of the
+// interface, and changes are announced according to their
+// org.freedesktop.DBus.Property.EmitsChangedSignal annotation.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"launchpad.net/go-dbus/v1"
+	"log"
+	"os"
+	"strings"
+)
+
+var (
+	packageName = flag.String("package", "main", "package name for the generated code")
+	output      = flag.String("o", "", "output file (defaults to standard output)")
+	interfaces  = flag.String("interfaces", "", "comma separated list of interfaces to generate (defaults to all)")
+	client      = flag.Bool("client", true, "generate client bindings")
+	server      = flag.Bool("server", false, "generate server bindings")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [flags] file.xml...\n", os.Args[0])
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("dbus-codegen: ")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+	}
+
+	var wanted map[string]bool
+	if *interfaces != "" {
+		wanted = make(map[string]bool)
+		for _, name := range strings.Split(*interfaces, ",") {
+			wanted[strings.TrimSpace(name)] = true
+		}
+	}
+
+	var selected []dbus.InterfaceInfo
+	for _, filename := range flag.Args() {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			log.Fatal(err)
+		}
+		parsed, err := parseIntrospection(data)
+		if err != nil {
+			log.Fatalf("%s: %v", filename, err)
+		}
+		for _, iface := range parsed {
+			if wanted == nil || wanted[iface.Name] {
+				selected = append(selected, iface)
+				delete(wanted, iface.Name)
+			}
+		}
+	}
+	for name := range wanted {
+		log.Fatalf("Interface %s not found", name)
+	}
+
+	src, err := generate(*packageName, selected, *client, *server)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *output == "" {
+		_, err = os.Stdout.Write(src)
+	} else {
+		err = ioutil.WriteFile(*output, src, 0644)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	. "launchpad.net/gocheck"
+	"testing"
+)
+
+func TestAll(t *testing.T) {
+	TestingT(t)
+}
+
+type S struct{}
+
+var _ = Suite(&S{})
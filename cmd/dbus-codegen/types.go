@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+var basicTypes = map[byte]string{
+	'y': "byte",
+	'b': "bool",
+	'n': "int16",
+	'q': "uint16",
+	'i': "int32",
+	'u': "uint32",
+	'x': "int64",
+	't': "uint64",
+	'd': "float64",
+	's': "string",
+	'o': "dbus.ObjectPath",
+	'g': "dbus.Signature",
+	'h': "dbus.UnixFD",
+	'v': "dbus.Variant",
+}
+
+// goType returns the Go type used to represent values of a D-Bus
+// type signature, which must describe a single complete type.
+func goType(sig string) (string, error) {
+	t, rest, err := parseType(sig)
+	if err != nil {
+		return "", err
+	}
+	if rest != "" {
+		return "", fmt.Errorf("Signature %q is not a single complete type", sig)
+	}
+	return t, nil
+}
+
+// parseType converts the first complete type in a signature to a Go
+// type, and returns the rest of the signature.
+func parseType(sig string) (t, rest string, err error) {
+	if sig == "" {
+		return "", "", errors.New("Unexpected end of signature")
+	}
+	if t, ok := basicTypes[sig[0]]; ok {
+		return t, sig[1:], nil
+	}
+	switch sig[0] {
+	case 'a':
+		if len(sig) > 1 && sig[1] == '{' {
+			key, rest, err := parseType(sig[2:])
+			if err != nil {
+				return "", "", err
+			}
+			value, rest, err := parseType(rest)
+			if err != nil {
+				return "", "", err
+			}
+			if rest == "" || rest[0] != '}' {
+				return "", "", errors.New("Dictionary entry is missing '}'")
+			}
+			return "map[" + key + "]" + value, rest[1:], nil
+		}
+		elem, rest, err := parseType(sig[1:])
+		if err != nil {
+			return "", "", err
+		}
+		return "[]" + elem, rest, nil
+	case '(':
+		var fields []string
+		rest := sig[1:]
+		for rest != "" && rest[0] != ')' {
+			var field string
+			if field, rest, err = parseType(rest); err != nil {
+				return "", "", err
+			}
+			fields = append(fields, fmt.Sprintf("Field%d %s", len(fields), field))
+		}
+		if rest == "" {
+			return "", "", errors.New("Structure is missing ')'")
+		}
+		if len(fields) == 0 {
+			return "", "", errors.New("Empty structure")
+		}
+		return "struct {\n" + strings.Join(fields, "\n") + "\n}", rest[1:], nil
+	}
+	return "", "", fmt.Errorf("Unknown type code %q", sig[0])
+}
+
+// exportedName converts a D-Bus name to an exported Go identifier,
+// e.g. "app_name" becomes "AppName".
+func exportedName(name string) string {
+	var result []rune
+	upper := true
+	for _, r := range name {
+		switch {
+		case r == '_' || r == '-' || r == '.':
+			upper = true
+		case upper:
+			result = append(result, unicode.ToUpper(r))
+			upper = false
+		default:
+			result = append(result, r)
+		}
+	}
+	if len(result) == 0 || !unicode.IsLetter(result[0]) {
+		result = append([]rune("X"), result...)
+	}
+	return string(result)
+}
+
+var goKeywords = map[string]bool{
+	"break": true, "case": true, "chan": true, "const": true,
+	"continue": true, "default": true, "defer": true, "else": true,
+	"fallthrough": true, "for": true, "func": true, "go": true,
+	"goto": true, "if": true, "import": true, "interface": true,
+	"map": true, "package": true, "range": true, "return": true,
+	"select": true, "struct": true, "switch": true, "type": true,
+	"var": true,
+}
+
+// nameSet hands out unique identifiers.
+type nameSet map[string]bool
+
+// add returns name, changed if necessary to make it unique within the
+// set.
+func (names nameSet) add(name string) string {
+	unique := name
+	for i := 1; names[unique] || goKeywords[unique]; i++ {
+		unique = fmt.Sprintf("%s%d", name, i)
+	}
+	names[unique] = true
+	return unique
+}
+
+// argName converts a D-Bus argument name to an unexported Go
+// identifier unique within names.  Unnamed arguments are given a
+// name based on their position.
+func argName(names nameSet, name string, prefix string, index int) string {
+	if name == "" {
+		return names.add(fmt.Sprintf("%s%d", prefix, index))
+	}
+	name = exportedName(name)
+	runes := []rune(name)
+	runes[0] = unicode.ToLower(runes[0])
+	return names.add(string(runes))
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	. "launchpad.net/gocheck"
+)
+
+func (s *S) TestGoType(c *C) {
+	for _, test := range []struct {
+		sig, goType string
+	}{
+		{"y", "byte"},
+		{"b", "bool"},
+		{"n", "int16"},
+		{"q", "uint16"},
+		{"i", "int32"},
+		{"u", "uint32"},
+		{"x", "int64"},
+		{"t", "uint64"},
+		{"d", "float64"},
+		{"s", "string"},
+		{"o", "dbus.ObjectPath"},
+		{"g", "dbus.Signature"},
+		{"h", "dbus.UnixFD"},
+		{"v", "dbus.Variant"},
+		{"as", "[]string"},
+		{"aai", "[][]int32"},
+		{"a{sv}", "map[string]dbus.Variant"},
+		{"a{oa{sv}}", "map[dbus.ObjectPath]map[string]dbus.Variant"},
+		{"(is)", "struct {\nField0 int32\nField1 string\n}"},
+	} {
+		t, err := goType(test.sig)
+		c.Check(err, IsNil, Commentf("%s", test.sig))
+		c.Check(t, Equals, test.goType, Commentf("%s", test.sig))
+	}
+
+	for _, sig := range []string{"", "ii", "a", "a{s", "a{si", "(", "()", "z"} {
+		_, err := goType(sig)
+		c.Check(err, NotNil, Commentf("%s", sig))
+	}
+}
+
+func (s *S) TestExportedName(c *C) {
+	c.Check(exportedName("Notify"), Equals, "Notify")
+	c.Check(exportedName("app_name"), Equals, "AppName")
+	c.Check(exportedName("foo-bar"), Equals, "FooBar")
+	c.Check(exportedName("9lives"), Equals, "X9lives")
+}
+
+func (s *S) TestArgName(c *C) {
+	names := newNameSet()
+	c.Check(argName(names, "app_name", "arg", 0), Equals, "appName")
+	c.Check(argName(names, "app_name", "arg", 1), Equals, "appName1")
+	c.Check(argName(names, "", "arg", 2), Equals, "arg2")
+	c.Check(argName(names, "type", "arg", 3), Equals, "type1")
+	c.Check(argName(names, "reply", "arg", 4), Equals, "reply1")
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"launchpad.net/go-dbus/v1"
+	"reflect"
+	"strconv"
+)
+
+// argParser converts command line arguments to D-Bus values.
+//
+// Basic values are given as a single argument.  Arrays are given as
+// the number of elements followed by the elements, and dictionaries
+// as the number of entries followed by each key and value.  The
+// fields of a structure follow one another, and a variant is given as
+// the signature of its value followed by the value.  For example, the
+// signature "a{sv}" with arguments "1 Name s foo" gives a dictionary
+// with a single entry.
+type argParser struct {
+	args []string
+}
+
+// parseArgs converts command line arguments to values of the types
+// in sig.  All arguments must be used.
+func parseArgs(sig dbus.Signature, args []string) ([]interface{}, error) {
+	if err := sig.Validate(); err != nil {
+		return nil, err
+	}
+	p := &argParser{args}
+	var values []interface{}
+	for offset := 0; offset < len(sig); {
+		next, _ := sig.NextType(offset)
+		value, err := p.parse(sig[offset:next])
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value.Interface())
+		offset = next
+	}
+	if len(p.args) != 0 {
+		return nil, fmt.Errorf("Too many arguments for signature %q", sig)
+	}
+	return values, nil
+}
+
+func (p *argParser) next() (string, error) {
+	if len(p.args) == 0 {
+		return "", fmt.Errorf("Not enough arguments")
+	}
+	arg := p.args[0]
+	p.args = p.args[1:]
+	return arg, nil
+}
+
+// parse converts arguments to a value of the single complete type
+// sig.
+func (p *argParser) parse(sig dbus.Signature) (reflect.Value, error) {
+	t, err := signatureType(sig)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	value := reflect.New(t).Elem()
+	switch sig[0] {
+	case 'a':
+		count, err := p.nextCount()
+		if err != nil {
+			return value, err
+		}
+		if sig[1] == '{' {
+			keySig := sig[2:3]
+			valueSig := sig[3 : len(sig)-1]
+			value.Set(reflect.MakeMap(t))
+			for i := 0; i < count; i++ {
+				k, err := p.parse(keySig)
+				if err != nil {
+					return value, err
+				}
+				v, err := p.parse(valueSig)
+				if err != nil {
+					return value, err
+				}
+				value.SetMapIndex(k, v)
+			}
+			return value, nil
+		}
+		value.Set(reflect.MakeSlice(t, count, count))
+		for i := 0; i < count; i++ {
+			elem, err := p.parse(sig[1:])
+			if err != nil {
+				return value, err
+			}
+			value.Index(i).Set(elem)
+		}
+		return value, nil
+	case '(':
+		for i, offset := 0, 1; offset < len(sig)-1; i++ {
+			next, _ := sig.NextType(offset)
+			field, err := p.parse(sig[offset:next])
+			if err != nil {
+				return value, err
+			}
+			value.Field(i).Set(field)
+			offset = next
+		}
+		return value, nil
+	case 'v':
+		arg, err := p.next()
+		if err != nil {
+			return value, err
+		}
+		valueSig := dbus.Signature(arg)
+		if err := valueSig.Validate(); err != nil {
+			return value, err
+		}
+		if next, _ := valueSig.NextType(0); next != len(valueSig) {
+			return value, fmt.Errorf("Variant signature %q is not a single complete type", arg)
+		}
+		variant, err := p.parse(valueSig)
+		if err != nil {
+			return value, err
+		}
+		value.Set(reflect.ValueOf(dbus.Variant{Value: variant.Interface()}))
+		return value, nil
+	}
+
+	arg, err := p.next()
+	if err != nil {
+		return value, err
+	}
+	switch sig[0] {
+	case 'y', 'q', 'u', 't':
+		n, err := strconv.ParseUint(arg, 0, t.Bits())
+		if err != nil {
+			return value, err
+		}
+		value.SetUint(n)
+	case 'n', 'i', 'x', 'h':
+		n, err := strconv.ParseInt(arg, 0, t.Bits())
+		if err != nil {
+			return value, err
+		}
+		value.SetInt(n)
+	case 'd':
+		f, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return value, err
+		}
+		value.SetFloat(f)
+	case 'b':
+		switch arg {
+		case "true", "yes", "1":
+			value.SetBool(true)
+		case "false", "no", "0":
+			value.SetBool(false)
+		default:
+			return value, fmt.Errorf("Invalid boolean %q", arg)
+		}
+	case 's', 'o', 'g':
+		value.SetString(arg)
+	}
+	return value, nil
+}
+
+// nextCount reads the length of an array.
+func (p *argParser) nextCount() (int, error) {
+	arg, err := p.next()
+	if err != nil {
+		return 0, err
+	}
+	count, err := strconv.Atoi(arg)
+	if err != nil || count < 0 {
+		return 0, fmt.Errorf("Invalid array length %q", arg)
+	}
+	return count, nil
+}
+
+var basicTypes = map[byte]reflect.Type{
+	'y': reflect.TypeOf(uint8(0)),
+	'b': reflect.TypeOf(false),
+	'n': reflect.TypeOf(int16(0)),
+	'q': reflect.TypeOf(uint16(0)),
+	'i': reflect.TypeOf(int32(0)),
+	'u': reflect.TypeOf(uint32(0)),
+	'x': reflect.TypeOf(int64(0)),
+	't': reflect.TypeOf(uint64(0)),
+	'd': reflect.TypeOf(float64(0)),
+	's': reflect.TypeOf(""),
+	'o': reflect.TypeOf(dbus.ObjectPath("")),
+	'g': reflect.TypeOf(dbus.Signature("")),
+	'h': reflect.TypeOf(dbus.UnixFD(0)),
+	'v': reflect.TypeOf(dbus.Variant{}),
+}
+
+// signatureType returns the Go type used for values of the single
+// complete type sig.
+func signatureType(sig dbus.Signature) (reflect.Type, error) {
+	if t, ok := basicTypes[sig[0]]; ok {
+		return t, nil
+	}
+	switch sig[0] {
+	case 'a':
+		if sig[1] == '{' {
+			key, err := signatureType(sig[2:3])
+			if err != nil {
+				return nil, err
+			}
+			value, err := signatureType(sig[3 : len(sig)-1])
+			if err != nil {
+				return nil, err
+			}
+			return reflect.MapOf(key, value), nil
+		}
+		elem, err := signatureType(sig[1:])
+		if err != nil {
+			return nil, err
+		}
+		return reflect.SliceOf(elem), nil
+	case '(':
+		var fields []reflect.StructField
+		for offset := 1; offset < len(sig)-1; {
+			next, err := sig.NextType(offset)
+			if err != nil {
+				return nil, err
+			}
+			t, err := signatureType(sig[offset:next])
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, reflect.StructField{
+				Name: fmt.Sprintf("Field%d", len(fields)),
+				Type: t})
+			offset = next
+		}
+		return reflect.StructOf(fields), nil
+	}
+	return nil, fmt.Errorf("Unsupported type %q", sig)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"launchpad.net/go-dbus/v1"
+	. "launchpad.net/gocheck"
+	"reflect"
+)
+
+func (s *S) TestParseArgsBasic(c *C) {
+	values, err := parseArgs("ybnqiuxtdsogh", []string{
+		"255", "yes", "-3", "0x10", "-42", "42", "-1", "1", "1.5", "hello", "/go/dbus", "a{sv}", "3"})
+	c.Assert(err, IsNil)
+	c.Check(values, DeepEquals, []interface{}{
+		uint8(255), true, int16(-3), uint16(16), int32(-42), uint32(42), int64(-1), uint64(1), float64(1.5),
+		"hello", dbus.ObjectPath("/go/dbus"), dbus.Signature("a{sv}"), dbus.UnixFD(3)})
+}
+
+func (s *S) TestParseArgsContainers(c *C) {
+	values, err := parseArgs("asa{sv}(is)", []string{
+		"2", "foo", "bar",
+		"2", "Name", "s", "foo", "Sizes", "ai", "2", "1", "2",
+		"42", "answer"})
+	c.Assert(err, IsNil)
+	c.Assert(values, HasLen, 3)
+	c.Check(values[0], DeepEquals, []string{"foo", "bar"})
+	c.Check(values[1], DeepEquals, map[string]dbus.Variant{
+		"Name":  {Value: "foo"},
+		"Sizes": {Value: []int32{1, 2}}})
+	field0 := reflect.ValueOf(values[2]).Field(0).Interface()
+	field1 := reflect.ValueOf(values[2]).Field(1).Interface()
+	c.Check(field0, Equals, int32(42))
+	c.Check(field1, Equals, "answer")
+
+	// The values can be sent in a message.
+	msg := dbus.NewSignalMessage("/go/dbus", "com.example.GoDbus", "Test")
+	c.Assert(msg.AppendArgs(values...), IsNil)
+	var strs []string
+	var dict map[string]dbus.Variant
+	var st struct {
+		A int32
+		B string
+	}
+	c.Assert(msg.Args(&strs, &dict, &st), IsNil)
+	c.Check(strs, DeepEquals, []string{"foo", "bar"})
+	c.Check(st.A, Equals, int32(42))
+	c.Check(st.B, Equals, "answer")
+}
+
+func (s *S) TestParseArgsErrors(c *C) {
+	_, err := parseArgs("i", []string{"foo"})
+	c.Check(err, NotNil)
+	_, err = parseArgs("i", []string{"1", "2"})
+	c.Check(err, ErrorMatches, `Too many arguments for signature "i"`)
+	_, err = parseArgs("ii", []string{"1"})
+	c.Check(err, ErrorMatches, "Not enough arguments")
+	_, err = parseArgs("a{s", []string{})
+	c.Check(err, NotNil)
+	_, err = parseArgs("as", []string{"-1"})
+	c.Check(err, ErrorMatches, `Invalid array length "-1"`)
+	_, err = parseArgs("b", []string{"maybe"})
+	c.Check(err, ErrorMatches, `Invalid boolean "maybe"`)
+	_, err = parseArgs("v", []string{"ss", "a", "b"})
+	c.Check(err, ErrorMatches, `Variant signature "ss" is not a single complete type`)
+}
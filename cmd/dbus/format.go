@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"launchpad.net/go-dbus/v1"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// formatValue formats a decoded message argument for display.
+//
+// Strings, object paths and signatures are quoted, arrays and
+// structures are listed in square brackets, dictionaries in braces,
+// and variants in angle brackets.
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return strconv.Quote(v)
+	case dbus.ObjectPath:
+		return strconv.Quote(string(v))
+	case dbus.Signature:
+		return strconv.Quote(string(v))
+	case *dbus.Variant:
+		return "<" + formatValue(v.Value) + ">"
+	case dbus.Variant:
+		return "<" + formatValue(v.Value) + ">"
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = formatValue(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case map[interface{}]interface{}:
+		items := make([]string, 0, len(v))
+		for key, item := range v {
+			items = append(items, formatValue(key)+": "+formatValue(item))
+		}
+		sort.Strings(items)
+		return "{" + strings.Join(items, ", ") + "}"
+	}
+	return fmt.Sprint(value)
+}
+
+// formatArgs formats the arguments of a message, one per line.
+func formatArgs(msg *dbus.Message) string {
+	var lines []string
+	for _, arg := range msg.AllArgs() {
+		lines = append(lines, formatValue(arg))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"launchpad.net/go-dbus/v1"
+	. "launchpad.net/gocheck"
+)
+
+func (s *S) TestFormatValue(c *C) {
+	c.Check(formatValue(int32(42)), Equals, "42")
+	c.Check(formatValue(true), Equals, "true")
+	c.Check(formatValue("a \"quoted\" string"), Equals, `"a \"quoted\" string"`)
+	c.Check(formatValue(dbus.ObjectPath("/go/dbus")), Equals, `"/go/dbus"`)
+	c.Check(formatValue([]interface{}{uint32(1), "two"}), Equals, `[1, "two"]`)
+	c.Check(formatValue(map[interface{}]interface{}{
+		"b": &dbus.Variant{Value: int32(2)},
+		"a": &dbus.Variant{Value: "one"}}), Equals, `{"a": <"one">, "b": <2>}`)
+}
+
+func (s *S) TestFormatArgs(c *C) {
+	msg := dbus.NewSignalMessage("/go/dbus", "com.example.GoDbus", "Test")
+	c.Assert(msg.AppendArgs("foo", []int32{1, 2}, map[string]dbus.Variant{"Name": {Value: "bar"}}), IsNil)
+	c.Check(formatArgs(msg), Equals, "\"foo\"\n[1, 2]\n{\"Name\": <\"bar\">}")
+}
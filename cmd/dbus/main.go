@@ -0,0 +1,395 @@
+// Dbus is a command line tool for inspecting and calling services on
+// a D-Bus message bus.
+//
+// Usage:
+//
+//	dbus [flags] list
+//	dbus [flags] call DEST PATH IFACE METHOD [SIGNATURE [ARG...]]
+//	dbus [flags] get DEST PATH IFACE PROPERTY
+//	dbus [flags] set DEST PATH IFACE PROPERTY SIGNATURE ARG...
+//	dbus [flags] introspect DEST PATH
+//	dbus [flags] tree DEST [PATH]
+//	dbus [flags] emit PATH IFACE MEMBER [SIGNATURE [ARG...]]
+//	dbus [flags] monitor [MATCH...]
+//	dbus [flags] capture [MATCH...] > FILE
+//	dbus dump FILE
+//
+// The session bus is used unless -system or -address is given.  The
+// -peer flag connects to a peer-to-peer server instead of a bus, which
+// only suits commands that do not need the bus daemon.
+//
+// Arguments are converted to the types of the signature: basic values
+// are given as a single argument, arrays as the number of elements
+// followed by the elements, dictionaries as the number of entries
+// followed by each key and value, structures as their fields in
+// order, and variants as the signature of their value followed by the
+// value.  For example:
+//
+//	dbus call org.example.Service /org/example org.example.Iface Configure sa{sv} name 2 Enabled b true Size u 4
+//
+// The monitor command prints the messages on the bus matching any of
+// the match rules, or all messages if none are given.  Buses without
+// the monitoring interface are asked to let the tool eavesdrop
+// instead.  The capture command writes the same messages to standard
+// output in the pcap format, which the dump command or tools such as
+// Wireshark can read back.
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"launchpad.net/go-dbus/v1"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	system      = flag.Bool("system", false, "connect to the system bus")
+	address     = flag.String("address", "", "connect to the message bus at this address")
+	peer        = flag.String("peer", "", "connect to the peer-to-peer D-Bus server at this address")
+	timeout     = flag.Duration("timeout", dbus.DefaultCallTimeout, "time to wait for each method call")
+	destination = flag.String("dest", "", "destination of emitted signals (defaults to a broadcast)")
+	showXML     = flag.Bool("xml", false, "print introspection data as XML")
+)
+
+type command struct {
+	run     func(conn *dbus.Connection, args []string) error
+	minArgs int
+	maxArgs int // -1 for no limit
+	usage   string
+	// Whether the command runs without connecting to a bus, in
+	// which case conn is nil.
+	offline bool
+}
+
+var commands = map[string]*command{
+	"list":       {run: list, usage: "list"},
+	"call":       {run: call, minArgs: 4, maxArgs: -1, usage: "call DEST PATH IFACE METHOD [SIGNATURE [ARG...]]"},
+	"get":        {run: get, minArgs: 4, maxArgs: 4, usage: "get DEST PATH IFACE PROPERTY"},
+	"set":        {run: set, minArgs: 6, maxArgs: -1, usage: "set DEST PATH IFACE PROPERTY SIGNATURE ARG..."},
+	"introspect": {run: introspect, minArgs: 2, maxArgs: 2, usage: "introspect DEST PATH"},
+	"tree":       {run: tree, minArgs: 1, maxArgs: 2, usage: "tree DEST [PATH]"},
+	"emit":       {run: emit, minArgs: 3, maxArgs: -1, usage: "emit PATH IFACE MEMBER [SIGNATURE [ARG...]]"},
+	"monitor":    {run: monitor, maxArgs: -1, usage: "monitor [MATCH...]"},
+	"capture":    {run: capture, maxArgs: -1, usage: "capture [MATCH...] > FILE"},
+	"dump":       {run: dump, minArgs: 1, maxArgs: 1, usage: "dump FILE", offline: true},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s [flags] %s\n", os.Args[0], commands[name].usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nFlags:\n")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("dbus: ")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		usage()
+	}
+	args := flag.Args()[1:]
+	if len(args) < cmd.minArgs || (cmd.maxArgs >= 0 && len(args) > cmd.maxArgs) {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] %s\n", os.Args[0], cmd.usage)
+		os.Exit(2)
+	}
+
+	if cmd.offline {
+		if err := cmd.run(nil, args); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	conn, err := connect()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+	conn.CallTimeout = *timeout
+	if err := cmd.run(conn, args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func connect() (*dbus.Connection, error) {
+	switch {
+	case *peer != "":
+		return dbus.Dial(*peer)
+	case *address != "":
+		return dbus.ConnectAddress(*address, nil)
+	case *system:
+		return dbus.Connect(dbus.SystemBus)
+	}
+	return dbus.Connect(dbus.SessionBus)
+}
+
+// list prints the names on the bus.
+func list(conn *dbus.Connection, args []string) error {
+	busDaemon := &dbus.BusDaemon{ObjectProxy: conn.Object(dbus.BUS_DAEMON_NAME, dbus.BUS_DAEMON_PATH)}
+	names, err := busDaemon.ListNames()
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Println(name)
+	}
+	return nil
+}
+
+// signatureArgs converts the optional signature and arguments at the
+// end of a command line.
+func signatureArgs(args []string) ([]interface{}, error) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	return parseArgs(dbus.Signature(args[0]), args[1:])
+}
+
+// call calls a method and prints its reply.
+func call(conn *dbus.Connection, args []string) error {
+	values, err := signatureArgs(args[4:])
+	if err != nil {
+		return err
+	}
+	reply, err := conn.Object(args[0], dbus.ObjectPath(args[1])).Call(args[2], args[3], values...)
+	if err != nil {
+		return err
+	}
+	if output := formatArgs(reply); output != "" {
+		fmt.Println(output)
+	}
+	return nil
+}
+
+// get prints the value of a property.
+func get(conn *dbus.Connection, args []string) error {
+	props := &dbus.Properties{ObjectProxy: conn.Object(args[0], dbus.ObjectPath(args[1]))}
+	value, err := props.Get(args[2], args[3])
+	if err != nil {
+		return err
+	}
+	fmt.Println(formatValue(value))
+	return nil
+}
+
+// set sets the value of a property.
+func set(conn *dbus.Connection, args []string) error {
+	values, err := parseArgs(dbus.Signature(args[4]), args[5:])
+	if err != nil {
+		return err
+	}
+	if len(values) != 1 {
+		return fmt.Errorf("Signature %q is not a single complete type", args[4])
+	}
+	props := &dbus.Properties{ObjectProxy: conn.Object(args[0], dbus.ObjectPath(args[1]))}
+	return props.Set(args[2], args[3], values[0])
+}
+
+// introspect prints the interfaces of an object.
+func introspect(conn *dbus.Connection, args []string) error {
+	obj := &dbus.Introspectable{ObjectProxy: conn.Object(args[0], dbus.ObjectPath(args[1]))}
+	if *showXML {
+		data, err := obj.Introspect()
+		if err != nil {
+			return err
+		}
+		fmt.Print(data)
+		return nil
+	}
+	node, err := obj.IntrospectNode()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	fmt.Fprintln(w, "NAME\tTYPE\tSIGNATURE\tRESULT/ACCESS")
+	for i := range node.Interfaces {
+		iface := &node.Interfaces[i]
+		fmt.Fprintf(w, "%s\tinterface\t-\t-\n", iface.Name)
+		for j := range iface.Methods {
+			method := &iface.Methods[j]
+			fmt.Fprintf(w, ".%s\tmethod\t%s\t%s\n", method.Name, orDash(string(method.InSignature())), orDash(string(method.OutSignature())))
+		}
+		for j := range iface.Properties {
+			property := &iface.Properties[j]
+			fmt.Fprintf(w, ".%s\tproperty\t%s\t%s\n", property.Name, property.Type, property.Access)
+		}
+		for j := range iface.Signals {
+			signal := &iface.Signals[j]
+			fmt.Fprintf(w, ".%s\tsignal\t%s\t-\n", signal.Name, orDash(string(signal.Signature())))
+		}
+	}
+	return w.Flush()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// tree prints the paths of the objects of a bus name.
+func tree(conn *dbus.Connection, args []string) error {
+	options := &dbus.CrawlOptions{CallTimeout: *timeout}
+	if len(args) > 1 {
+		options.Root = dbus.ObjectPath(args[1])
+	}
+	root, err := conn.Crawl(context.Background(), args[0], options)
+	if err != nil {
+		return err
+	}
+	printTree(root, "")
+	return nil
+}
+
+func printTree(tree *dbus.ObjectTree, indent string) {
+	if tree.Err != nil {
+		fmt.Printf("%s%s (%v)\n", indent, tree.Path, tree.Err)
+	} else {
+		fmt.Printf("%s%s\n", indent, tree.Path)
+	}
+	for _, child := range tree.Children {
+		printTree(child, indent+"  ")
+	}
+}
+
+// emit sends a signal.
+func emit(conn *dbus.Connection, args []string) error {
+	values, err := signatureArgs(args[3:])
+	if err != nil {
+		return err
+	}
+	msg := dbus.NewSignalMessage(dbus.ObjectPath(args[0]), args[1], args[2])
+	msg.Dest = *destination
+	if err := msg.AppendArgs(values...); err != nil {
+		return err
+	}
+	return conn.Send(msg)
+}
+
+// monitor prints the messages on the bus until interrupted.
+func monitor(conn *dbus.Connection, args []string) error {
+	return observe(conn, args, func(msg *dbus.Message) error {
+		printMessage(msg, time.Now())
+		return nil
+	})
+}
+
+// capture writes the messages on the bus to standard output until
+// interrupted.
+func capture(conn *dbus.Connection, args []string) error {
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	pw, err := dbus.NewPcapWriter(w)
+	if err != nil {
+		return err
+	}
+	return observe(conn, args, func(msg *dbus.Message) error {
+		if err := pw.WriteMessage(msg, time.Now()); err != nil {
+			return err
+		}
+		return w.Flush()
+	})
+}
+
+// dump prints the messages of a capture.
+func dump(conn *dbus.Connection, args []string) error {
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := dbus.NewPcapReader(bufio.NewReader(f))
+	if err != nil {
+		return err
+	}
+	for {
+		msg, t, err := r.ReadMessage()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		printMessage(msg, t)
+	}
+}
+
+// observe becomes a monitor of the messages matching the rules in
+// args, and passes each message to fn until interrupted.
+func observe(conn *dbus.Connection, args []string, fn func(*dbus.Message) error) error {
+	var rules []*dbus.MatchRule
+	for _, arg := range args {
+		rule, err := dbus.ParseMatchRule(arg)
+		if err != nil {
+			return fmt.Errorf("Invalid match rule %q: %v", arg, err)
+		}
+		rules = append(rules, rule)
+	}
+	m, err := conn.BecomeMonitor(rules)
+	if err != nil {
+		return err
+	}
+	if m.Eavesdropping() {
+		log.Print("The bus does not support monitoring; eavesdropping instead")
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	for {
+		select {
+		case msg, ok := <-m.C:
+			if !ok {
+				return conn.Err()
+			}
+			if err := fn(msg); err != nil {
+				return err
+			}
+		case <-interrupt:
+			return nil
+		}
+	}
+}
+
+func printMessage(msg *dbus.Message, t time.Time) {
+	fields := []string{msg.Type.String()}
+	for _, field := range []struct{ name, value string }{
+		{"sender", msg.Sender},
+		{"destination", msg.Dest},
+		{"path", string(msg.Path)},
+		{"interface", msg.Interface},
+		{"member", msg.Member},
+		{"error_name", msg.ErrorName},
+	} {
+		if field.value != "" {
+			fields = append(fields, field.name+"="+field.value)
+		}
+	}
+	fmt.Printf("%s %s\n", t.Format("15:04:05.000"), strings.Join(fields, " "))
+	for _, arg := range msg.AllArgs() {
+		fmt.Printf("  %s\n", formatValue(arg))
+	}
+}
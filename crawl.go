@@ -0,0 +1,207 @@
+package dbus
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCrawlConcurrency is the default number of Introspect
+	// calls Crawl makes at once.
+	DefaultCrawlConcurrency = 8
+	// DefaultCrawlMaxDepth is the default number of levels below
+	// the root that Crawl descends.
+	DefaultCrawlMaxDepth = 64
+)
+
+// CrawlOptions controls how Crawl walks an object tree.  The zero
+// value selects the defaults.
+type CrawlOptions struct {
+	// The path to start from.  Defaults to "/".
+	Root ObjectPath
+	// The maximum number of Introspect calls in progress at once.
+	// Defaults to DefaultCrawlConcurrency.
+	Concurrency int
+	// The time to wait for each Introspect call.  Defaults to the
+	// connection's CallTimeout.
+	CallTimeout time.Duration
+	// The number of levels below the root to descend.  Defaults to
+	// DefaultCrawlMaxDepth.
+	MaxDepth int
+	// The maximum number of objects to visit, or zero for no limit.
+	MaxObjects int
+}
+
+// ObjectTree describes an object found by Crawl, and the objects
+// beneath it.
+type ObjectTree struct {
+	Path       ObjectPath
+	Interfaces []InterfaceInfo
+	// The objects beneath this one, sorted by path.
+	Children []*ObjectTree
+	// Err holds the error returned when introspecting the object,
+	// in which case its interfaces and children are unknown.
+	Err error
+}
+
+// Walk calls fn for the object and each of the objects beneath it,
+// parents before their children.
+func (tree *ObjectTree) Walk(fn func(*ObjectTree)) {
+	fn(tree)
+	for _, child := range tree.Children {
+		child.Walk(fn)
+	}
+}
+
+// Crawl introspects the objects of destination, starting at the root
+// path and following the child nodes of each object.
+//
+// Errors introspecting individual objects are recorded in the tree
+// rather than stopping the crawl.  An error is returned if the root
+// object can not be introspected, or if the context is done before
+// the crawl completes.
+func (p *Connection) Crawl(ctx context.Context, destination string, options *CrawlOptions) (*ObjectTree, error) {
+	c := &crawler{
+		conn:        p,
+		destination: destination,
+		concurrency: DefaultCrawlConcurrency,
+		callTimeout: p.CallTimeout,
+		maxDepth:    DefaultCrawlMaxDepth,
+		visited:     make(map[ObjectPath]bool)}
+	root := ObjectPath("/")
+	if options != nil {
+		if options.Root != "" {
+			root = options.Root
+		}
+		if options.Concurrency > 0 {
+			c.concurrency = options.Concurrency
+		}
+		if options.CallTimeout > 0 {
+			c.callTimeout = options.CallTimeout
+		}
+		if options.MaxDepth > 0 {
+			c.maxDepth = options.MaxDepth
+		}
+		c.maxObjects = options.MaxObjects
+	}
+	c.slots = make(chan struct{}, c.concurrency)
+
+	tree := &ObjectTree{Path: root}
+	c.visited[root] = true
+	c.wg.Add(1)
+	c.visit(ctx, tree, 0)
+	c.wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if tree.Err != nil {
+		return nil, tree.Err
+	}
+	return tree, nil
+}
+
+type crawler struct {
+	conn        *Connection
+	destination string
+	concurrency int
+	callTimeout time.Duration
+	maxDepth    int
+	maxObjects  int
+
+	slots chan struct{}
+	wg    sync.WaitGroup
+
+	lock    sync.Mutex
+	visited map[ObjectPath]bool
+}
+
+// visit introspects an object, and starts visiting its children.
+func (c *crawler) visit(ctx context.Context, tree *ObjectTree, depth int) {
+	defer c.wg.Done()
+	select {
+	case c.slots <- struct{}{}:
+	case <-ctx.Done():
+		tree.Err = ctx.Err()
+		return
+	}
+	node, err := c.introspect(ctx, tree.Path)
+	<-c.slots
+	if err != nil {
+		tree.Err = err
+		return
+	}
+	tree.Interfaces = node.Interfaces
+	if depth >= c.maxDepth {
+		return
+	}
+
+	for _, child := range node.Children {
+		path, ok := childPath(tree.Path, child.Name)
+		if !ok || !c.markVisited(path) {
+			continue
+		}
+		childTree := &ObjectTree{Path: path}
+		tree.Children = append(tree.Children, childTree)
+		c.wg.Add(1)
+		go c.visit(ctx, childTree, depth+1)
+	}
+	sort.Sort(objectTrees(tree.Children))
+}
+
+// introspect calls Introspect on an object, giving up after the call
+// timeout.
+func (c *crawler) introspect(ctx context.Context, path ObjectPath) (*NodeInfo, error) {
+	if c.callTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.callTimeout)
+		defer cancel()
+	}
+	reply, err := c.conn.Object(c.destination, path).CallWithContext(ctx, INTROSPECTABLE_IFACE, "Introspect")
+	if err != nil {
+		return nil, err
+	}
+	var data string
+	if err := reply.Args(&data); err != nil {
+		return nil, err
+	}
+	return ParseIntrospection([]byte(data))
+}
+
+// markVisited records that an object will be visited, returning false
+// if it has already been seen or the object limit has been reached.
+func (c *crawler) markVisited(path ObjectPath) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.visited[path] || (c.maxObjects > 0 && len(c.visited) >= c.maxObjects) {
+		return false
+	}
+	c.visited[path] = true
+	return true
+}
+
+// childPath returns the path of a child node, if its name is a valid
+// path element.
+func childPath(parent ObjectPath, name string) (ObjectPath, bool) {
+	if name == "" {
+		return "", false
+	}
+	for _, ch := range name {
+		if !(ch >= 'A' && ch <= 'Z' || ch >= 'a' && ch <= 'z' || ch >= '0' && ch <= '9' || ch == '_') {
+			return "", false
+		}
+	}
+	if strings.HasSuffix(string(parent), "/") {
+		return parent + ObjectPath(name), true
+	}
+	return parent + "/" + ObjectPath(name), true
+}
+
+type objectTrees []*ObjectTree
+
+func (trees objectTrees) Len() int           { return len(trees) }
+func (trees objectTrees) Less(i, j int) bool { return trees[i].Path < trees[j].Path }
+func (trees objectTrees) Swap(i, j int)      { trees[i], trees[j] = trees[j], trees[i] }
@@ -0,0 +1,102 @@
+package dbus
+
+import (
+	"context"
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+type crawlTest struct{}
+
+func (t *crawlTest) Introspect() string {
+	return `<node>
+  <interface name="com.example.GoDbus.Parent"/>
+  <node name="dbus"/>
+  <node name="slow"/>
+  <node name="../invalid"/>
+  <node name="dbus"/>
+</node>`
+}
+
+func crawledPaths(tree *ObjectTree) map[ObjectPath]error {
+	paths := make(map[ObjectPath]error)
+	tree.Walk(func(object *ObjectTree) {
+		paths[object.Path] = object.Err
+	})
+	return paths
+}
+
+func (s *S) TestConnectionCrawl(c *C) {
+	server, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer server.Close()
+	client, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer client.Close()
+
+	c.Assert(server.Export(&exportTest{}, "/go/dbus/a", "com.example.GoDbus"), IsNil)
+	c.Assert(server.Export(&exportTest{}, "/go/dbus/b/c", "com.example.GoDbus"), IsNil)
+	c.Assert(server.Export(&crawlTest{}, "/go", INTROSPECTABLE_IFACE), IsNil)
+	// Calls to this object are never answered.
+	server.RegisterObjectPath("/go/slow", make(chan *Message, 10))
+
+	tree, err := client.Crawl(context.Background(), server.UniqueName, &CrawlOptions{
+		Concurrency: 2,
+		CallTimeout: 200 * time.Millisecond})
+	c.Assert(err, IsNil)
+	paths := crawledPaths(tree)
+	c.Check(paths, HasLen, 7)
+	for _, path := range []ObjectPath{"/", "/go", "/go/dbus", "/go/dbus/a", "/go/dbus/b", "/go/dbus/b/c"} {
+		err, ok := paths[path]
+		c.Check(ok, Equals, true, Commentf("%s", path))
+		c.Check(err, IsNil, Commentf("%s", path))
+	}
+	c.Check(paths["/go/slow"], Equals, context.DeadlineExceeded)
+
+	c.Assert(tree.Children, HasLen, 1)
+	goTree := tree.Children[0]
+	c.Check(goTree.Interfaces[0].Name, Equals, "com.example.GoDbus.Parent")
+	c.Assert(goTree.Children, HasLen, 2)
+	c.Check(goTree.Children[0].Path, Equals, ObjectPath("/go/dbus"))
+	c.Check(goTree.Children[1].Path, Equals, ObjectPath("/go/slow"))
+	dbusTree := goTree.Children[0]
+	c.Assert(dbusTree.Children, HasLen, 2)
+	c.Check(dbusTree.Children[0].Path, Equals, ObjectPath("/go/dbus/a"))
+	c.Check(dbusTree.Children[0].Interfaces[len(dbusTree.Children[0].Interfaces)-1].Name, Equals, "com.example.GoDbus")
+}
+
+func (s *S) TestConnectionCrawlLimits(c *C) {
+	server, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer server.Close()
+	client, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer client.Close()
+
+	c.Assert(server.Export(&exportTest{}, "/go/dbus/a", "com.example.GoDbus"), IsNil)
+	c.Assert(server.Export(&exportTest{}, "/go/dbus/b", "com.example.GoDbus"), IsNil)
+
+	tree, err := client.Crawl(context.Background(), server.UniqueName, &CrawlOptions{
+		Root:     "/go",
+		MaxDepth: 1})
+	c.Assert(err, IsNil)
+	c.Check(crawledPaths(tree), DeepEquals, map[ObjectPath]error{
+		"/go": nil, "/go/dbus": nil})
+
+	tree, err = client.Crawl(context.Background(), server.UniqueName, &CrawlOptions{
+		MaxObjects: 3})
+	c.Assert(err, IsNil)
+	c.Check(crawledPaths(tree), DeepEquals, map[ObjectPath]error{
+		"/": nil, "/go": nil, "/go/dbus": nil})
+
+	// An error introspecting the root object is returned.
+	_, err = client.Crawl(context.Background(), server.UniqueName, &CrawlOptions{
+		Root: "/other"})
+	c.Check(err, FitsTypeOf, &Error{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = client.Crawl(ctx, server.UniqueName, nil)
+	c.Check(err, Equals, context.Canceled)
+}
@@ -0,0 +1,48 @@
+package dbus
+
+import (
+	"errors"
+	"os"
+)
+
+// Credentials identifies the process at the other end of a unix
+// socket, as reported by the kernel.
+type Credentials struct {
+	Pid int
+	Uid uint32
+	Gid uint32
+}
+
+var (
+	errNoCredentials = errors.New("Peer credentials are not available")
+	// Returned for unix sockets on platforms where their peer
+	// credentials can not be read.
+	errCredentialsUnsupported = errors.New("Peer credentials are not supported on this platform")
+	errNoPidfd                = errors.New("Peer pidfd is not available")
+)
+
+// PeerCredentials returns the credentials of the process at the other
+// end of a connection over a unix socket: the bus daemon for a
+// connection to a message bus, or the peer of a peer-to-peer
+// connection.  The credentials are those of the process when the
+// socket was connected.
+func (p *Connection) PeerCredentials() (*Credentials, error) {
+	p.connOpenLock.Lock()
+	conn := p.conn
+	p.connOpenLock.Unlock()
+	return peerCredentials(conn)
+}
+
+// PeerPidfd returns a pidfd referring to the process at the other end
+// of a connection over a unix socket.  Unlike the pid in its
+// credentials, a pidfd can not come to refer to another process once
+// the peer exits.  An error is returned if the kernel does not
+// support SO_PEERPIDFD.
+//
+// Each call returns a new file, which the caller should close.
+func (p *Connection) PeerPidfd() (*os.File, error) {
+	p.connOpenLock.Lock()
+	conn := p.conn
+	p.connOpenLock.Unlock()
+	return peerPidfd(conn)
+}
@@ -0,0 +1,60 @@
+package dbus
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// soPeerPidfd is SO_PEERPIDFD, which the syscall package does not
+// define.
+const soPeerPidfd = 77
+
+// controlSocket calls fn with the file descriptor of a unix socket.
+func controlSocket(conn net.Conn, fn func(fd int) error) error {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return errNoCredentials
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var fnErr error
+	if err := raw.Control(func(fd uintptr) {
+		fnErr = fn(int(fd))
+	}); err != nil {
+		return err
+	}
+	return fnErr
+}
+
+// peerCredentials returns the credentials of the peer of a unix
+// socket, using SO_PEERCRED.
+func peerCredentials(conn net.Conn) (*Credentials, error) {
+	var ucred *syscall.Ucred
+	err := controlSocket(conn, func(fd int) (err error) {
+		ucred, err = syscall.GetsockoptUcred(fd, syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Credentials{Pid: int(ucred.Pid), Uid: ucred.Uid, Gid: ucred.Gid}, nil
+}
+
+// peerPidfd returns a pidfd for the peer of a unix socket, using
+// SO_PEERPIDFD.
+func peerPidfd(conn net.Conn) (*os.File, error) {
+	var pidfd int
+	err := controlSocket(conn, func(fd int) (err error) {
+		pidfd, err = syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, soPeerPidfd)
+		return err
+	})
+	if err == syscall.ENOPROTOOPT {
+		return nil, errNoPidfd
+	} else if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(pidfd), "pidfd"), nil
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+// +build !linux
+
+package dbus
+
+import (
+	"net"
+	"os"
+)
+
+// peerCredentials is only implemented on Linux.
+func peerCredentials(conn net.Conn) (*Credentials, error) {
+	if _, ok := conn.(*net.UnixConn); ok {
+		return nil, errCredentialsUnsupported
+	}
+	return nil, errNoCredentials
+}
+
+func peerPidfd(conn net.Conn) (*os.File, error) {
+	return nil, errNoPidfd
+}
@@ -0,0 +1,67 @@
+package dbus
+
+import (
+	"fmt"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+	"strings"
+)
+
+func (s *S) TestConnectionPeerCredentials(c *C) {
+	bus, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer bus.Close()
+
+	// The broker runs in this process.
+	creds, err := bus.PeerCredentials()
+	c.Assert(err, IsNil)
+	c.Check(creds.Pid, Equals, os.Getpid())
+	c.Check(creds.Uid, Equals, uint32(os.Geteuid()))
+	c.Check(creds.Gid, Equals, uint32(os.Getegid()))
+
+	// A pidfd is returned if the kernel supports SO_PEERPIDFD.
+	pidfd, err := bus.PeerPidfd()
+	if err != errNoPidfd {
+		c.Assert(err, IsNil)
+		defer pidfd.Close()
+		fdinfo, err := ioutil.ReadFile(fmt.Sprintf("/proc/self/fdinfo/%d", pidfd.Fd()))
+		c.Assert(err, IsNil)
+		c.Check(strings.Contains(string(fdinfo), fmt.Sprintf("Pid:\t%d\n", os.Getpid())), Equals, true)
+	}
+
+	// The bus reports the same credentials for the connection.
+	uid, err := bus.busProxy.GetConnectionUnixUser(bus.UniqueName)
+	c.Check(err, IsNil)
+	c.Check(uid, Equals, uint32(os.Geteuid()))
+	pid, err := bus.busProxy.GetConnectionUnixProcessID(bus.UniqueName)
+	c.Check(err, IsNil)
+	c.Check(pid, Equals, uint32(os.Getpid()))
+	uid, err = bus.busProxy.GetConnectionUnixUser(BUS_DAEMON_NAME)
+	c.Check(err, IsNil)
+	c.Check(uid, Equals, uint32(os.Geteuid()))
+
+	_, err = bus.busProxy.GetConnectionUnixUser("com.example.GoDbus")
+	c.Check(err, FitsTypeOf, &Error{})
+	c.Check(err.(*Error).Name, Equals, "org.freedesktop.DBus.Error.NameHasNoOwner")
+}
+
+func (s *S) TestConnectionPeerCredentialsTcp(c *C) {
+	server, err := Listen("tcp:host=127.0.0.1,port=0")
+	c.Assert(err, IsNil)
+	defer server.Close()
+	go func() {
+		if conn, err := server.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+	client, err := Dial(server.Address())
+	c.Assert(err, IsNil)
+	defer client.Close()
+
+	// Credentials are only available for unix sockets.
+	_, err = client.PeerCredentials()
+	c.Check(err, NotNil)
+	_, err = client.PeerPidfd()
+	c.Check(err, NotNil)
+}
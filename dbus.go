@@ -4,6 +4,7 @@
 package dbus
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -13,6 +14,7 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type StandardBus int
@@ -28,29 +30,67 @@ const (
 	BUS_DAEMON_IFACE = "org.freedesktop.DBus"
 )
 
+// DefaultCallTimeout is the default time to wait for the reply to a
+// method call, matching the default used by libdbus.
+const DefaultCallTimeout = 25 * time.Second
+
+// ErrConnectionClosed is returned by Connection.Err after the
+// connection has been closed with Close.
+var ErrConnectionClosed = errors.New("connection closed")
+
 type MessageFilter struct {
 	filter func(*Message) *Message
 }
 
 // Connection represents a connection to a message bus.
 type Connection struct {
-	// The unique name of this connection on the message bus.
-	UniqueName   string
-	conn         net.Conn
-	writeLock    sync.Mutex
-	busProxy     BusDaemon
-	lastSerial   uint32
-	connOpen     bool
-	connOpenLock sync.Mutex
-
-	handlerMutex       sync.Mutex // covers the next three
+	// The unique name of this connection on the message bus.  It
+	// changes if the connection is automatically re-established.
+	UniqueName string
+	// The maximum time to wait for the reply to a method call whose
+	// context has no deadline.  Zero means wait forever.  Defaults
+	// to DefaultCallTimeout.
+	CallTimeout time.Duration
+	address     string
+	// The authentication mechanisms to use when reconnecting, or
+	// nil for the defaults.
+	authenticators []Authenticator
+	// conn, reader and unixFDs are only changed on reconnection,
+	// with both writeLock and connOpenLock held.
+	conn          net.Conn
+	reader        io.Reader
+	writeLock     sync.Mutex
+	busProxy      BusDaemon
+	lastSerial    uint32
+	connOpen      bool
+	connErr       error
+	autoReconnect bool
+	reconnecting  bool
+	connOpenLock  sync.Mutex
+	done          chan struct{}
+	closing       chan struct{}
+	closeOnce     sync.Once
+	// Whether this is a peer-to-peer connection rather than a
+	// connection to a message bus.
+	peerToPeer bool
+	// Whether file descriptors can be passed over the connection.
+	unixFDs bool
+	// Held while dispatching a message, so that replayed messages
+	// are not dispatched alongside received ones.
+	dispatchLock sync.Mutex
+
+	handlerMutex       sync.Mutex // covers the next seven
 	messageFilters     []*MessageFilter
 	methodCallReplies  map[uint32]chan<- *Message
 	objectPathHandlers map[ObjectPath]chan<- *Message
 	signalMatchRules   signalWatchSet
+	exportedObjects    map[ObjectPath]*exportedObject
+	objectManagers     map[ObjectPath]bool
+	monitor            *Monitor
 
-	nameInfoMutex sync.Mutex
+	nameInfoMutex sync.Mutex // covers the next two
 	nameInfo      map[string]*nameInfo
+	busNames      map[*BusName]bool
 }
 
 // ObjectProxy represents a remote object on the bus.  It can be used
@@ -74,11 +114,20 @@ func (o *ObjectProxy) ObjectPath() ObjectPath {
 // On failure (both network failures and D-Bus level errors), an error
 // will be returned.
 func (o *ObjectProxy) Call(iface, method string, args ...interface{}) (*Message, error) {
+	return o.CallWithContext(context.Background(), iface, method, args...)
+}
+
+// CallWithContext calls the given method on the remote object,
+// giving up waiting for the reply when the context is done.
+//
+// See SendWithReplyContext for how the connection's CallTimeout is
+// applied.
+func (o *ObjectProxy) CallWithContext(ctx context.Context, iface, method string, args ...interface{}) (*Message, error) {
 	msg := NewMethodCallMessage(o.destination, o.path, iface, method)
 	if err := msg.AppendArgs(args...); err != nil {
 		return nil, err
 	}
-	reply, err := o.bus.SendWithReply(msg)
+	reply, err := o.bus.SendWithReplyContext(ctx, msg)
 	if err != nil {
 		return nil, err
 	}
@@ -114,35 +163,94 @@ func Connect(busType StandardBus) (*Connection, error) {
 		return nil, errors.New("Unknown bus")
 	}
 
+	return dial(address, true, nil)
+}
+
+// Dial establishes a peer-to-peer connection with the D-Bus server at
+// the given address.
+//
+// Unlike Connect, no Hello call is made on the connection, so the
+// connection will not have a unique name.
+func Dial(address string) (*Connection, error) {
+	return dial(address, false, nil)
+}
+
+// ConnectOptions controls how ConnectAddress connects.  The zero value
+// selects the defaults.
+type ConnectOptions struct {
+	// The authentication mechanisms to try, in order.  Defaults to
+	// EXTERNAL, DBUS_COOKIE_SHA1 and then ANONYMOUS.
+	Authenticators []Authenticator
+	// Whether the address is a peer-to-peer server rather than a
+	// message bus, in which case no Hello call is made, as with
+	// Dial.
+	PeerToPeer bool
+}
+
+// ConnectAddress returns a connection to the message bus at the given
+// address, or to a peer-to-peer server if options.PeerToPeer is set.
+//
+// If the server accepts none of the authentication mechanisms, an
+// *AuthError is returned.
+func ConnectAddress(address string, options *ConnectOptions) (*Connection, error) {
+	if options == nil {
+		options = &ConnectOptions{}
+	}
+	return dial(address, !options.PeerToPeer, options.Authenticators)
+}
+
+func dial(address string, hello bool, authenticators []Authenticator) (*Connection, error) {
 	trans, err := newTransport(address)
 	if err != nil {
 		return nil, err
 	}
-	bus := new(Connection)
-	if bus.conn, err = trans.Dial(); err != nil {
+	conn, err := trans.Dial()
+	if err != nil {
 		return nil, err
 	}
-	bus.setConnOpen(true)
 
-	if err = authenticate(bus.conn, nil); err != nil {
-		bus.Close()
+	unixFDs, err := authenticate(conn, authenticators)
+	if err != nil {
+		conn.Close()
 		return nil, err
 	}
 
+	bus := newConnection(conn, unixFDs)
+	bus.address = address
+	bus.authenticators = authenticators
+	bus.peerToPeer = !hello
+	go bus.receiveLoop()
+	if hello {
+		if bus.UniqueName, err = bus.busProxy.Hello(); err != nil {
+			bus.Close()
+			return nil, err
+		}
+	}
+
+	return bus, nil
+}
+
+// newConnection creates a Connection for an authenticated socket.
+func newConnection(conn net.Conn, unixFDs bool) *Connection {
+	bus := new(Connection)
+	bus.conn = conn
+	bus.reader = newMessageReader(conn)
+	bus.unixFDs = unixFDs
+	bus.CallTimeout = DefaultCallTimeout
+	bus.done = make(chan struct{})
+	bus.closing = make(chan struct{})
+	bus.setConnOpen(true)
+
 	bus.busProxy = BusDaemon{bus.Object(BUS_DAEMON_NAME, BUS_DAEMON_PATH)}
 	bus.messageFilters = []*MessageFilter{}
 	bus.methodCallReplies = make(map[uint32]chan<- *Message)
 	bus.objectPathHandlers = make(map[ObjectPath]chan<- *Message)
 	bus.signalMatchRules = make(signalWatchSet)
+	bus.exportedObjects = make(map[ObjectPath]*exportedObject)
+	bus.objectManagers = make(map[ObjectPath]bool)
 	bus.nameInfo = make(map[string]*nameInfo)
-
-	go bus.receiveLoop()
-	if bus.UniqueName, err = bus.busProxy.Hello(); err != nil {
-		bus.Close()
-		return nil, err
-	}
-
-	return bus, nil
+	bus.busNames = make(map[*BusName]bool)
+	return bus
 }
 
 func (p *Connection) setConnOpen(o bool) {
@@ -151,12 +259,38 @@ func (p *Connection) setConnOpen(o bool) {
 	p.connOpen = o
 }
 
+// setConnClosed marks the connection as closed, recording err as the
+// reason unless one has already been recorded.
+func (p *Connection) setConnClosed(err error) {
+	p.connOpenLock.Lock()
+	defer p.connOpenLock.Unlock()
+	p.connOpen = false
+	if p.connErr == nil {
+		p.connErr = err
+	}
+}
+
 func (p *Connection) isConnOpen() bool {
 	p.connOpenLock.Lock()
 	defer p.connOpenLock.Unlock()
 	return p.connOpen
 }
 
+// Done returns a channel that is closed when the connection has been
+// closed or lost.
+func (p *Connection) Done() <-chan struct{} {
+	return p.done
+}
+
+// Err returns nil while the connection is open.  Once it is closed,
+// Err returns ErrConnectionClosed if Close was called, or otherwise
+// the error that caused the connection to be lost.
+func (p *Connection) Err() error {
+	p.connOpenLock.Lock()
+	defer p.connOpenLock.Unlock()
+	return p.connErr
+}
+
 func (p *Connection) Authenticate() error {
 	log.Println("dbus.Connection.Authenticate() is deprecated.  This call can be removed")
 	return nil
@@ -164,24 +298,77 @@ func (p *Connection) Authenticate() error {
 
 func (p *Connection) receiveLoop() {
 	for {
-		msg, err := readMessage(p.conn)
-		if err != nil {
-			if err != io.EOF && p.isConnOpen() {
-				log.Println("Failed to read message:", err)
+		msg, err := readMessage(p.reader)
+		if err == nil {
+			p.dispatchLock.Lock()
+			err = p.dispatchMessage(msg)
+			p.dispatchLock.Unlock()
+			if err == nil {
+				continue
 			}
-			break
-		}
-		if err = p.dispatchMessage(msg); err != nil {
 			log.Println("Error dispatching message:", err)
-			break
+		} else if err != io.EOF && p.isConnOpen() {
+			log.Println("Failed to read message:", err)
 		}
+		if !p.reconnect() {
+			p.disconnect(err)
+			return
+		}
+	}
+}
+
+// disconnect cleans up after the connection has been closed or lost:
+// pending method calls fail, and all signal and name watches are
+// closed.
+func (p *Connection) disconnect(err error) {
+	p.setConnClosed(err)
+	p.conn.Close()
+	close(p.done)
+
+	var nameWatches []*nameWatch
+	p.nameInfoMutex.Lock()
+	for _, info := range p.nameInfo {
+		info.lock.Lock()
+		nameWatches = append(nameWatches, info.watches...)
+		info.lock.Unlock()
+	}
+	p.nameInfoMutex.Unlock()
+	for _, watch := range nameWatches {
+		if watch.disconnectCb != nil {
+			watch.disconnectCb()
+		}
+	}
+
+	var signalWatches []*signalWatch
+	p.handlerMutex.Lock()
+	for _, byInterface := range p.signalMatchRules {
+		for _, byMember := range byInterface {
+			for _, watches := range byMember {
+				signalWatches = append(signalWatches, watches...)
+			}
+		}
+	}
+	monitor := p.monitor
+	p.handlerMutex.Unlock()
+	for _, watch := range signalWatches {
+		if watch.disconnectCb != nil {
+			watch.disconnectCb()
+		}
+	}
+	if monitor != nil {
+		monitor.stop()
 	}
 }
 
 func (p *Connection) handlerForPath(objpath ObjectPath) (chan<- *Message, bool) {
 	p.handlerMutex.Lock()
 	defer p.handlerMutex.Unlock()
+	return p.lookupHandler(objpath)
+}
 
+// lookupHandler finds the object path handler for a path.  The caller
+// must hold handlerMutex.
+func (p *Connection) lookupHandler(objpath ObjectPath) (chan<- *Message, bool) {
 	path := string(objpath)
 	idx := strings.LastIndex(path, "/") + 1
 
@@ -199,6 +386,12 @@ func (p *Connection) handlerForPath(objpath ObjectPath) (chan<- *Message, bool)
 }
 
 func (p *Connection) dispatchMessage(msg *Message) error {
+	// Messages observed by a monitor are not meant for this
+	// connection.
+	if p.monitorMessage(msg) {
+		return nil
+	}
+
 	// Run the message through the registered filters, stopping
 	// processing if a filter returns nil.
 	for _, filter := range p.messageFilters {
@@ -213,7 +406,7 @@ func (p *Connection) dispatchMessage(msg *Message) error {
 		switch {
 		case msg.Interface == "org.freedesktop.DBus.Peer" && msg.Member == "Ping":
 			reply := NewMethodReturnMessage(msg)
-			if err := p.Send(reply); err != nil {
+			if err := p.sendReply(msg, reply); err != nil {
 				return err
 			}
 		case msg.Interface == "org.freedesktop.DBus.Peer" && msg.Member == "GetMachineId":
@@ -223,16 +416,26 @@ func (p *Connection) dispatchMessage(msg *Message) error {
 			if err := reply.AppendArgs("machine-id"); err != nil {
 				return err
 			}
-			if err := p.Send(reply); err != nil {
+			if err := p.sendReply(msg, reply); err != nil {
 				return err
 			}
 		default:
+			if method, errorReply, ok := p.findExportedMethod(msg); ok {
+				if method != nil {
+					// Run the method in its own goroutine
+					// so it can make calls of its own.
+					go p.handleExportedMethod(msg, method)
+				} else if err := p.sendReply(msg, errorReply); err != nil {
+					return err
+				}
+				break
+			}
 			handler, ok := p.handlerForPath(msg.Path)
 			if ok {
 				handler <- msg
 			} else {
 				reply := NewErrorMessage(msg, "org.freedesktop.DBus.Error.UnknownObject", "Unknown object path "+string(msg.Path))
-				if err := p.Send(reply); err != nil {
+				if err := p.sendReply(msg, reply); err != nil {
 					return err
 				}
 			}
@@ -259,9 +462,22 @@ func (p *Connection) dispatchMessage(msg *Message) error {
 	return nil
 }
 
+// sendReply sends the reply to a method call, unless the caller asked
+// for no reply.
+func (p *Connection) sendReply(call, reply *Message) error {
+	if call.Flags&FlagNoReplyExpected != 0 {
+		return nil
+	}
+	return p.Send(reply)
+}
+
 func (p *Connection) Close() error {
-	p.setConnOpen(false)
-	return p.conn.Close()
+	p.setConnClosed(ErrConnectionClosed)
+	p.closeOnce.Do(func() { close(p.closing) })
+	p.connOpenLock.Lock()
+	conn := p.conn
+	p.connOpenLock.Unlock()
+	return conn.Close()
 }
 
 func (p *Connection) nextSerial() uint32 {
@@ -269,10 +485,21 @@ func (p *Connection) nextSerial() uint32 {
 }
 
 func (p *Connection) atomicWriteMessage(msg *Message) error {
+	if !p.isConnOpen() {
+		return p.Err()
+	}
 	p.writeLock.Lock()
 	defer p.writeLock.Unlock()
-	_, err := msg.WriteTo(p.conn)
-	return err
+	p.connOpenLock.Lock()
+	reconnecting := p.reconnecting
+	p.connOpenLock.Unlock()
+	if reconnecting {
+		return errDisconnected
+	}
+	if len(msg.fds) != 0 && !p.unixFDs {
+		return errors.New("File descriptor passing is not supported on this connection")
+	}
+	return writeMessage(p.conn, msg)
 }
 
 func (p *Connection) Send(msg *Message) error {
@@ -280,12 +507,34 @@ func (p *Connection) Send(msg *Message) error {
 	return p.atomicWriteMessage(msg)
 }
 
+// SendWithReply sends a method call and waits for its reply.
+//
+// If no reply arrives within the connection's CallTimeout, an
+// org.freedesktop.DBus.Error.NoReply error is returned.
 func (p *Connection) SendWithReply(msg *Message) (*Message, error) {
+	return p.SendWithReplyContext(context.Background(), msg)
+}
+
+// SendWithReplyContext sends a method call and waits for its reply,
+// or until the context is done, in which case the context's error is
+// returned.
+//
+// If the context has no deadline, the connection's CallTimeout
+// applies as with SendWithReply.
+//
+// If the connection is closed or lost before the reply arrives, the
+// connection's Err value is returned.
+func (p *Connection) SendWithReplyContext(ctx context.Context, msg *Message) (*Message, error) {
+	return p.sendWithReply(ctx, msg, p.nextSerial())
+}
+
+// sendWithReply sends a method call with the given serial, and waits
+// for its reply.
+func (p *Connection) sendWithReply(ctx context.Context, msg *Message, serial uint32) (*Message, error) {
 	// XXX: also check for "no reply" flag.
 	if msg.Type != TypeMethodCall {
 		panic("Only method calls have replies")
 	}
-	serial := p.nextSerial()
 	msg.setSerial(serial)
 
 	replyChan := make(chan *Message, 1)
@@ -294,14 +543,45 @@ func (p *Connection) SendWithReply(msg *Message) (*Message, error) {
 	p.handlerMutex.Unlock()
 
 	if err := p.atomicWriteMessage(msg); err != nil {
-		p.handlerMutex.Lock()
-		delete(p.methodCallReplies, serial)
-		p.handlerMutex.Unlock()
+		p.cancelReply(serial)
 		return nil, err
 	}
 
-	reply := <-replyChan
-	return reply, nil
+	var timeout <-chan time.Time
+	if _, ok := ctx.Deadline(); !ok && p.CallTimeout > 0 {
+		timer := time.NewTimer(p.CallTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case reply := <-replyChan:
+		return reply, nil
+	case <-ctx.Done():
+		p.cancelReply(serial)
+		return nil, ctx.Err()
+	case <-p.done:
+		// Prefer a reply that arrived before the connection
+		// was lost.
+		select {
+		case reply := <-replyChan:
+			return reply, nil
+		default:
+		}
+		p.cancelReply(serial)
+		return nil, p.Err()
+	case <-timeout:
+		p.cancelReply(serial)
+		return nil, &Error{"org.freedesktop.DBus.Error.NoReply", "Did not receive a reply to " + msg.Member}
+	}
+}
+
+// cancelReply stops waiting for the reply to the method call with
+// the given serial.
+func (p *Connection) cancelReply(serial uint32) {
+	p.handlerMutex.Lock()
+	defer p.handlerMutex.Unlock()
+	delete(p.methodCallReplies, serial)
 }
 
 func (p *Connection) RegisterMessageFilter(filter func(*Message) *Message) *MessageFilter {
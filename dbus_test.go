@@ -1,8 +1,10 @@
 package dbus
 
 import (
+	"context"
 	"fmt"
 	. "launchpad.net/gocheck"
+	"time"
 )
 
 type callTest struct {
@@ -38,7 +40,34 @@ func (test callTest) Call(c *Connection) error {
 	return err
 }
 
+// serveNotifications provides a minimal notification service for
+// the method call tests to talk to.
+func serveNotifications(c *C) *Connection {
+	service, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	name := service.RequestName("org.freedesktop.Notifications", NameFlagDoNotQueue)
+	c.Assert(<-name.C, IsNil)
+
+	calls := make(chan *Message)
+	service.RegisterObjectPath("/org/freedesktop/Notifications", calls)
+	go func() {
+		for msg := range calls {
+			reply := NewMethodReturnMessage(msg)
+			if err := reply.AppendArgs(uint32(1)); err != nil {
+				c.Error(err)
+			}
+			if err := service.Send(reply); err != nil {
+				c.Error(err)
+			}
+		}
+	}()
+	return service
+}
+
 func (s *S) TestDBus(c *C) {
+	service := serveNotifications(c)
+	defer service.Close()
+
 	bus, err := Connect(SessionBus)
 	c.Assert(err, IsNil)
 	defer bus.Close()
@@ -89,6 +118,146 @@ func (s *S) TestConnectionRegisterMessageFilter(c *C) {
 	c.Assert(extra, Equals, "Added by filter")
 }
 
+// serveUnresponsive provides a service that never replies to method
+// calls made on it.
+func serveUnresponsive(c *C) *Connection {
+	service, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	calls := make(chan *Message, 10)
+	service.RegisterObjectPath("/go/dbus/test", calls)
+	return service
+}
+
+func (s *S) TestConnectionCallTimeout(c *C) {
+	service := serveUnresponsive(c)
+	defer service.Close()
+	bus, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer bus.Close()
+	c.Check(bus.CallTimeout, Equals, DefaultCallTimeout)
+
+	bus.CallTimeout = 50 * time.Millisecond
+	_, err = bus.Object(service.UniqueName, "/go/dbus/test").Call("com.example.GoDbus", "Hang")
+	c.Assert(err, FitsTypeOf, &Error{})
+	c.Check(err.(*Error).Name, Equals, "org.freedesktop.DBus.Error.NoReply")
+
+	bus.handlerMutex.Lock()
+	c.Check(bus.methodCallReplies, HasLen, 0)
+	bus.handlerMutex.Unlock()
+}
+
+func (s *S) TestConnectionCallWithContext(c *C) {
+	service := serveUnresponsive(c)
+	defer service.Close()
+	bus, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer bus.Close()
+	obj := bus.Object(service.UniqueName, "/go/dbus/test")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err = obj.CallWithContext(ctx, "com.example.GoDbus", "Hang")
+	c.Check(err, Equals, context.Canceled)
+
+	// A context deadline overrides the connection's timeout.
+	bus.CallTimeout = time.Millisecond
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = obj.CallWithContext(ctx, "com.example.GoDbus", "Hang")
+	c.Check(err, Equals, context.DeadlineExceeded)
+	c.Check(time.Since(start) >= 50*time.Millisecond, Equals, true)
+
+	bus.handlerMutex.Lock()
+	c.Check(bus.methodCallReplies, HasLen, 0)
+	bus.handlerMutex.Unlock()
+
+	// Calls that are answered are unaffected.
+	_, err = bus.Object(BUS_DAEMON_NAME, BUS_DAEMON_PATH).CallWithContext(context.Background(), BUS_DAEMON_IFACE, "GetId")
+	c.Check(err, IsNil)
+}
+
+// dropClient closes the broker's end of a client's connection.
+func (s *S) dropClient(c *C, uniqueName string) {
+	s.broker.lock.Lock()
+	client := s.broker.clients[uniqueName]
+	s.broker.lock.Unlock()
+	c.Assert(client, NotNil)
+	client.conn.Close()
+}
+
+func (s *S) TestConnectionClose(c *C) {
+	bus, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	c.Check(bus.Err(), IsNil)
+	select {
+	case <-bus.Done():
+		c.Fatal("Done channel closed early")
+	default:
+	}
+
+	c.Check(bus.Close(), IsNil)
+	<-bus.Done()
+	c.Check(bus.Err(), Equals, ErrConnectionClosed)
+
+	_, err = bus.busProxy.GetId()
+	c.Check(err, Equals, ErrConnectionClosed)
+	_, err = bus.WatchSignal(&MatchRule{Type: TypeSignal, Member: "Foo"})
+	c.Check(err, Equals, ErrConnectionClosed)
+	_, err = bus.WatchName("com.example.GoDbus")
+	c.Check(err, Equals, ErrConnectionClosed)
+}
+
+func (s *S) TestConnectionLost(c *C) {
+	service := serveUnresponsive(c)
+	defer service.Close()
+	bus, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer bus.Close()
+
+	signalWatch, err := bus.WatchSignal(&MatchRule{
+		Type:      TypeSignal,
+		Sender:    "com.example.GoDbus",
+		Interface: "com.example.GoDbus",
+		Member:    "TestSignal"})
+	c.Assert(err, IsNil)
+	nameWatch, err := bus.WatchName("com.example.GoDbus")
+	c.Assert(err, IsNil)
+	c.Check(<-nameWatch.C, Equals, "")
+
+	calls := make(chan error, 1)
+	go func() {
+		_, err := bus.Object(service.UniqueName, "/go/dbus/test").Call("com.example.GoDbus", "Hang")
+		calls <- err
+	}()
+	// Wait for the call to be pending.
+	for {
+		bus.handlerMutex.Lock()
+		pending := len(bus.methodCallReplies)
+		bus.handlerMutex.Unlock()
+		if pending != 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	s.dropClient(c, bus.UniqueName)
+	<-bus.Done()
+	c.Check(bus.Err(), NotNil)
+	c.Check(bus.Err(), Not(Equals), ErrConnectionClosed)
+
+	// The pending call fails with the connection's error.
+	c.Check(<-calls, Equals, bus.Err())
+
+	// All watches are closed.
+	_, ok := <-signalWatch.C
+	c.Check(ok, Equals, false)
+	_, ok = <-nameWatch.C
+	c.Check(ok, Equals, false)
+	c.Check(signalWatch.Cancel(), IsNil)
+	c.Check(nameWatch.Cancel(), IsNil)
+}
+
 func (s *S) TestGlob(c *C) {
 	ch1 := make(chan *Message)
 	ch2 := make(chan *Message)
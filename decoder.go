@@ -11,6 +11,7 @@ type decoder struct {
 	signature Signature
 	data      []byte
 	order     binary.ByteOrder
+	fds       []int
 
 	dataOffset, sigOffset int
 }
@@ -307,6 +308,23 @@ func (self *decoder) decodeValue(v reflect.Value) error {
 			v.Set(reflect.ValueOf(ObjectPath(value)))
 			return nil
 		}
+	case 'h':
+		index, err := self.readUint32()
+		if err != nil {
+			return err
+		}
+		if int(index) >= len(self.fds) {
+			return errors.New("File descriptor index out of range")
+		}
+		value := UnixFD(self.fds[index])
+		switch {
+		case v.Type() == typeUnixFD:
+			v.Set(reflect.ValueOf(value))
+			return nil
+		case typeBlankInterface.AssignableTo(v.Type()):
+			v.Set(reflect.ValueOf(value))
+			return nil
+		}
 	case 'g':
 		value, err := self.readSignature()
 		if err != nil {
@@ -468,25 +486,29 @@ func (self *decoder) decodeValue(v reflect.Value) error {
 			variant = &Variant{}
 			v.Set(reflect.ValueOf(variant))
 		}
+		signature, err := self.readSignature()
+		if err != nil {
+			return err
+		}
+		// Decode the variant value through a sub-decoder.
+		variantDec := decoder{
+			signature:  signature,
+			data:       self.data,
+			order:      self.order,
+			fds:        self.fds,
+			dataOffset: self.dataOffset,
+			sigOffset:  0}
+		// Other destinations hold the variant's value directly.
+		target := v
 		if variant != nil {
-			signature, err := self.readSignature()
-			if err != nil {
-				return err
-			}
-			// Decode the variant value through a sub-decoder.
-			variantDec := decoder{
-				signature:  signature,
-				data:       self.data,
-				order:      self.order,
-				dataOffset: self.dataOffset,
-				sigOffset:  0}
-			if err := variantDec.decodeValue(reflect.ValueOf(&variant.Value).Elem()); err != nil {
-				return err
-			}
-			// Decoding continues after the variant value.
-			self.dataOffset = variantDec.dataOffset
-			return nil
+			target = reflect.ValueOf(&variant.Value).Elem()
 		}
+		if err := variantDec.decodeValue(target); err != nil {
+			return err
+		}
+		// Decoding continues after the variant value.
+		self.dataOffset = variantDec.dataOffset
+		return nil
 	}
 	return errors.New("Could not decode " + string(sigCode) + " to " + v.Type().String())
 }
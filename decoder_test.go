@@ -364,6 +364,44 @@ func (s *S) TestDecoderDecodeVariant(c *C) {
 	c.Check(value3, DeepEquals, &Variant{int32(42)})
 }
 
+func (s *S) TestDecoderDecodeVariantValue(c *C) {
+	data := []byte{
+		2, 'a', 's', 0, // Signature("as")
+		14, 0, 0, 0, // array length
+		3, 0, 0, 0, 'f', 'o', 'o', 0, // "foo"
+		1, 0, 0, 0, 'x', 0} // "x"
+
+	// The value of a variant can be decoded directly.
+	dec := newDecoder("v", data, binary.LittleEndian)
+	var value []string
+	if err := dec.Decode(&value); err != nil {
+		c.Error(err)
+	}
+	c.Check(value, DeepEquals, []string{"foo", "x"})
+	c.Check(dec.dataOffset, Equals, len(data))
+
+	// A Variant holding a pointer has it replaced by the value,
+	// as for any other Variant.
+	dec = newDecoder("v", data, binary.LittleEndian)
+	value = nil
+	variant := Variant{&value}
+	if err := dec.Decode(&variant); err != nil {
+		c.Error(err)
+	}
+	c.Check(variant, DeepEquals, Variant{[]interface{}{"foo", "x"}})
+	c.Check(value, IsNil)
+
+	// The value must still match the destination type.
+	dec = newDecoder("v", []byte{
+		1,      // len("i")
+		'i', 0, // Signature("i")
+		0,            // padding
+		42, 0, 0, 0}, // int32(42)
+		binary.LittleEndian)
+	var str string
+	c.Check(dec.Decode(&str), ErrorMatches, "Could not decode i to string")
+}
+
 func (s *S) TestDecoderDecodeVariantMap(c *C) {
 	dec := newDecoder("v", []byte{
 		5,                       // len("a{ii}")
@@ -482,6 +520,27 @@ func (s *S) TestDecoderDecodeVariantMapIntStruct(c *C) {
 	c.Assert(ok, Equals, true)
 }
 
+func (s *S) TestDecoderDecodeUnixFD(c *C) {
+	dec := newDecoder("hh", []byte{1, 0, 0, 0, 0, 0, 0, 0}, binary.LittleEndian)
+	dec.fds = []int{7, 9}
+	var value1 UnixFD
+	var value2 interface{}
+	if err := dec.Decode(&value1, &value2); err != nil {
+		c.Error(err)
+	}
+	c.Check(value1, Equals, UnixFD(9))
+	c.Check(value2, Equals, UnixFD(7))
+	c.Check(dec.dataOffset, Equals, 8)
+	c.Check(dec.sigOffset, Equals, 2)
+}
+
+func (s *S) TestDecoderDecodeUnixFDOutOfRange(c *C) {
+	dec := newDecoder("h", []byte{2, 0, 0, 0}, binary.LittleEndian)
+	dec.fds = []int{7, 9}
+	var value UnixFD
+	c.Check(dec.Decode(&value), NotNil)
+}
+
 func (s *S) TestDecoderSurvivesDecodeOfEmpty(c *C) {
 	dec := newDecoder("", []byte{}, binary.LittleEndian)
 
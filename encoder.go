@@ -11,6 +11,7 @@ type encoder struct {
 	signature Signature
 	data      bytes.Buffer
 	order     binary.ByteOrder
+	fds       []int
 }
 
 func newEncoder(signature Signature, data []byte, order binary.ByteOrder) *encoder {
@@ -46,6 +47,10 @@ func (self *encoder) alignForType(t reflect.Type) error {
 	for t.Kind() == reflect.Ptr {
 		t = t.Elem()
 	}
+	if t == typeUnixFD {
+		self.align(4)
+		return nil
+	}
 
 	switch t.Kind() {
 	case reflect.Uint8:
@@ -93,6 +98,13 @@ func (self *encoder) appendValue(v reflect.Value) error {
 	}
 
 	self.alignForType(v.Type())
+	// File descriptors are passed out of band, and referenced by
+	// their index in the message.
+	if v.Type() == typeUnixFD {
+		binary.Write(&self.data, self.order, uint32(len(self.fds)))
+		self.fds = append(self.fds, int(v.Int()))
+		return nil
+	}
 	switch v.Kind() {
 	case reflect.Uint8:
 		self.data.WriteByte(byte(v.Uint()))
@@ -143,11 +155,13 @@ func (self *encoder) appendValue(v reflect.Value) error {
 		// can find its length.
 		var content encoder
 		content.order = self.order
+		content.fds = self.fds
 		for i := 0; i < v.Len(); i++ {
 			if err := content.appendValue(v.Index(i)); err != nil {
 				return err
 			}
 		}
+		self.fds = content.fds
 		binary.Write(&self.data, self.order, uint32(content.data.Len()))
 		self.alignForType(v.Type().Elem())
 		self.data.Write(content.data.Bytes())
@@ -157,6 +171,7 @@ func (self *encoder) appendValue(v reflect.Value) error {
 		// can find its length.
 		var content encoder
 		content.order = self.order
+		content.fds = self.fds
 		for _, key := range v.MapKeys() {
 			content.align(8)
 			if err := content.appendValue(key); err != nil {
@@ -166,6 +181,7 @@ func (self *encoder) appendValue(v reflect.Value) error {
 				return err
 			}
 		}
+		self.fds = content.fds
 		binary.Write(&self.data, self.order, uint32(content.data.Len()))
 		self.align(8) // alignment of DICT_ENTRY
 		self.data.Write(content.data.Bytes())
@@ -255,6 +255,20 @@ func (s *S) TestEncoderAppendVariant(c *C) {
 		42, 0, 0, 0}) // int32(42)
 }
 
+func (s *S) TestEncoderAppendUnixFD(c *C) {
+	enc := newEncoder("", nil, binary.LittleEndian)
+	if err := enc.Append(UnixFD(7), []UnixFD{9, 7}); err != nil {
+		c.Error(err)
+	}
+	c.Check(enc.signature, Equals, Signature("hah"))
+	c.Check(enc.data.Bytes(), DeepEquals, []byte{
+		0, 0, 0, 0, // index 0
+		8, 0, 0, 0, // array length
+		1, 0, 0, 0, // index 1
+		2, 0, 0, 0}) // index 2
+	c.Check(enc.fds, DeepEquals, []int{7, 9, 7})
+}
+
 func (s *S) TestEncoderAppendAlignment(c *C) {
 	enc := newEncoder("", nil, binary.LittleEndian)
 	if err := enc.Append(byte(42), int16(42), true, int32(42), int64(42)); err != nil {
@@ -0,0 +1,243 @@
+package dbus
+
+import (
+	"errors"
+	"log"
+	"reflect"
+	"sort"
+)
+
+var typeError = reflect.TypeOf((*error)(nil)).Elem()
+
+// exportedObject holds the interfaces exported at an object path.
+type exportedObject struct {
+	interfaces map[string]*exportedInterface
+}
+
+type exportedInterface struct {
+	name       string
+	methods    map[string]*exportedMethod
+	properties map[string]*exportedProperty
+	signals    map[string]Signature
+}
+
+type exportedMethod struct {
+	name        string
+	fn          reflect.Value
+	inSig       Signature
+	outSig      Signature
+	returnsErr  bool
+	outArgCount int
+}
+
+// newExportedMethod checks whether a method can be exported over
+// D-Bus, and records its signature.
+func newExportedMethod(name string, fn reflect.Value) (*exportedMethod, bool) {
+	t := fn.Type()
+	if t.IsVariadic() {
+		return nil, false
+	}
+	method := &exportedMethod{name: name, fn: fn, outArgCount: t.NumOut()}
+	for i := 0; i < t.NumIn(); i++ {
+		sig, err := SignatureOf(t.In(i))
+		if err != nil {
+			return nil, false
+		}
+		method.inSig += sig
+	}
+	if t.NumOut() > 0 && t.Out(t.NumOut()-1) == typeError {
+		method.returnsErr = true
+		method.outArgCount -= 1
+	}
+	for i := 0; i < method.outArgCount; i++ {
+		sig, err := SignatureOf(t.Out(i))
+		if err != nil {
+			return nil, false
+		}
+		method.outSig += sig
+	}
+	return method, true
+}
+
+// Export makes the exported methods of obj available as the methods
+// of a D-Bus interface on the given object path.
+//
+// Method arguments are decoded from the method call message using
+// the same rules as Message.Args, and return values are sent back in
+// the method return message.  If the last return value of a method is
+// an error, a non-nil value will result in an error reply: a *Error
+// value will be sent as is, while other errors are sent as
+// org.freedesktop.DBus.Error.Failed.
+//
+// Methods with arguments or return values that can not be
+// represented in D-Bus are not exported.
+func (p *Connection) Export(obj interface{}, path ObjectPath, iface string) error {
+	methods := make(map[string]*exportedMethod)
+	v := reflect.ValueOf(obj)
+	t := v.Type()
+	for i := 0; i < t.NumMethod(); i++ {
+		if t.Method(i).PkgPath != "" {
+			continue
+		}
+		name := t.Method(i).Name
+		if method, ok := newExportedMethod(name, v.Method(i)); ok {
+			methods[name] = method
+		}
+	}
+
+	p.handlerMutex.Lock()
+	exported, added := p.exportedInterface(path, iface)
+	if exported.methods != nil {
+		p.handlerMutex.Unlock()
+		return errors.New("Interface " + iface + " already exported on " + string(path))
+	}
+	exported.methods = methods
+	p.handlerMutex.Unlock()
+
+	if added {
+		p.interfaceAdded(path, iface)
+	}
+	return nil
+}
+
+// exportedInterface returns the record of an interface exported at
+// path, creating it if necessary.  The caller must hold handlerMutex.
+func (p *Connection) exportedInterface(path ObjectPath, iface string) (exported *exportedInterface, created bool) {
+	object, ok := p.exportedObjects[path]
+	if !ok {
+		object = &exportedObject{make(map[string]*exportedInterface)}
+		p.exportedObjects[path] = object
+	}
+	exported, ok = object.interfaces[iface]
+	if !ok {
+		exported = &exportedInterface{name: iface}
+		object.interfaces[iface] = exported
+	}
+	return exported, !ok
+}
+
+// Unexport removes an interface previously exported with Export,
+// along with any properties registered for it with ExportProperties
+// and signals declared with DeclareSignal.
+func (p *Connection) Unexport(path ObjectPath, iface string) error {
+	p.handlerMutex.Lock()
+	object, ok := p.exportedObjects[path]
+	if !ok {
+		p.handlerMutex.Unlock()
+		return errors.New("No objects exported on " + string(path))
+	}
+	if _, ok := object.interfaces[iface]; !ok {
+		p.handlerMutex.Unlock()
+		return errors.New("Interface " + iface + " not exported on " + string(path))
+	}
+	delete(object.interfaces, iface)
+	if len(object.interfaces) == 0 {
+		delete(p.exportedObjects, path)
+	}
+	p.handlerMutex.Unlock()
+
+	p.interfaceRemoved(path, iface)
+	return nil
+}
+
+// findExportedMethod looks up the exported method a method call is
+// addressed to.  If no object is exported at the path, ok is false.
+// Otherwise either the method or an error reply is returned.
+func (p *Connection) findExportedMethod(msg *Message) (method *exportedMethod, errorReply *Message, ok bool) {
+	p.handlerMutex.Lock()
+	defer p.handlerMutex.Unlock()
+	if msg.Interface == OBJECT_MANAGER_IFACE && p.objectManagers[msg.Path] {
+		if method = p.objectManagerMethod(msg); method == nil {
+			return nil, NewErrorMessage(msg, "org.freedesktop.DBus.Error.UnknownMethod", "No such method '"+msg.Member+"' in interface '"+msg.Interface+"' at object path '"+string(msg.Path)+"'"), true
+		}
+		return method, nil, true
+	}
+	if msg.Interface == INTROSPECTABLE_IFACE && msg.Member == "Introspect" {
+		if method = p.introspectMethod(msg); method != nil {
+			return method, nil, true
+		}
+	}
+	object, ok := p.exportedObjects[msg.Path]
+	if !ok {
+		return nil, nil, false
+	}
+
+	if msg.Interface != "" {
+		iface, ok := object.interfaces[msg.Interface]
+		if !ok && msg.Interface == PROPERTIES_IFACE {
+			// Answer property requests from the properties
+			// registered for the object.
+			if method = p.propertiesMethod(msg); method != nil {
+				return method, nil, true
+			}
+		}
+		if !ok {
+			return nil, NewErrorMessage(msg, "org.freedesktop.DBus.Error.UnknownInterface", "No such interface '"+msg.Interface+"' at object path '"+string(msg.Path)+"'"), true
+		}
+		method = iface.methods[msg.Member]
+	} else {
+		// Without an interface, pick the first match in a
+		// predictable order.
+		names := make([]string, 0, len(object.interfaces))
+		for name := range object.interfaces {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if method = object.interfaces[name].methods[msg.Member]; method != nil {
+				break
+			}
+		}
+	}
+	if method == nil {
+		return nil, NewErrorMessage(msg, "org.freedesktop.DBus.Error.UnknownMethod", "No such method '"+msg.Member+"' in interface '"+msg.Interface+"' at object path '"+string(msg.Path)+"'"), true
+	}
+	return method, nil, true
+}
+
+// call invokes the method with the arguments of the method call
+// message, and returns the reply message.
+func (method *exportedMethod) call(msg *Message) *Message {
+	if msg.sig != method.inSig {
+		return NewErrorMessage(msg, "org.freedesktop.DBus.Error.InvalidArgs", "Call to "+method.name+" has wrong args ("+string(msg.sig)+", expected "+string(method.inSig)+")")
+	}
+	t := method.fn.Type()
+	args := make([]reflect.Value, t.NumIn())
+	dec := newDecoder(msg.sig, msg.body, msg.order)
+	dec.fds = msg.fds
+	for i := range args {
+		arg := reflect.New(t.In(i))
+		if err := dec.Decode(arg.Interface()); err != nil {
+			return NewErrorMessage(msg, "org.freedesktop.DBus.Error.InvalidArgs", err.Error())
+		}
+		args[i] = arg.Elem()
+	}
+
+	results := method.fn.Call(args)
+	if method.returnsErr {
+		if errValue := results[len(results)-1]; !errValue.IsNil() {
+			switch err := errValue.Interface().(type) {
+			case *Error:
+				return NewErrorMessage(msg, err.Name, err.Message)
+			case error:
+				return NewErrorMessage(msg, "org.freedesktop.DBus.Error.Failed", err.Error())
+			}
+		}
+	}
+
+	reply := NewMethodReturnMessage(msg)
+	for _, result := range results[:method.outArgCount] {
+		if err := reply.AppendArgs(result.Interface()); err != nil {
+			return NewErrorMessage(msg, "org.freedesktop.DBus.Error.Failed", err.Error())
+		}
+	}
+	return reply
+}
+
+// handleExportedMethod runs an exported method and sends its reply.
+func (p *Connection) handleExportedMethod(msg *Message, method *exportedMethod) {
+	reply := method.call(msg)
+	if err := p.sendReply(msg, reply); err != nil {
+		log.Println("Failed to send reply to", method.name, "call:", err)
+	}
+}
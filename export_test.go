@@ -0,0 +1,127 @@
+package dbus
+
+import (
+	"errors"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+)
+
+type exportTest struct{}
+
+func (t *exportTest) Add(a, b int32) (int32, error) {
+	return a + b, nil
+}
+
+func (t *exportTest) Greet(name string) string {
+	return "Hello " + name
+}
+
+func (t *exportTest) Swap(a string, b uint32) (uint32, string) {
+	return b, a
+}
+
+func (t *exportTest) DBusError() error {
+	return &Error{"com.example.GoDbus.Error", "an error"}
+}
+
+func (t *exportTest) PlainError() error {
+	return errors.New("a plain error")
+}
+
+func (t *exportTest) Unexportable(ch chan int) {
+}
+
+func (t *exportTest) unexported() {
+}
+
+type readTest struct{}
+
+func (t *readTest) Read(fd UnixFD) (string, error) {
+	file := os.NewFile(uintptr(fd), "pipe")
+	defer file.Close()
+	data, err := ioutil.ReadAll(file)
+	return string(data), err
+}
+
+func (s *S) TestConnectionExport(c *C) {
+	server, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer server.Close()
+	client, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer client.Close()
+
+	c.Assert(server.Export(&exportTest{}, "/go/dbus/test", "com.example.GoDbus"), IsNil)
+	// The same interface can't be exported twice.
+	c.Check(server.Export(&exportTest{}, "/go/dbus/test", "com.example.GoDbus"), NotNil)
+
+	obj := client.Object(server.UniqueName, "/go/dbus/test")
+	reply, err := obj.Call("com.example.GoDbus", "Add", int32(40), int32(2))
+	c.Assert(err, IsNil)
+	var sum int32
+	c.Check(reply.Args(&sum), IsNil)
+	c.Check(sum, Equals, int32(42))
+
+	reply, err = obj.Call("com.example.GoDbus", "Greet", "world")
+	c.Assert(err, IsNil)
+	var greeting string
+	c.Check(reply.Args(&greeting), IsNil)
+	c.Check(greeting, Equals, "Hello world")
+
+	// The interface is optional.
+	reply, err = obj.Call("", "Swap", "foo", uint32(42))
+	c.Assert(err, IsNil)
+	c.Check(reply.AllArgs(), DeepEquals, []interface{}{uint32(42), "foo"})
+
+	_, err = obj.Call("com.example.GoDbus", "DBusError")
+	c.Check(err, DeepEquals, &Error{"com.example.GoDbus.Error", "an error"})
+
+	_, err = obj.Call("com.example.GoDbus", "PlainError")
+	c.Check(err, DeepEquals, &Error{"org.freedesktop.DBus.Error.Failed", "a plain error"})
+
+	_, err = obj.Call("com.example.GoDbus", "Add", "wrong", "args")
+	c.Assert(err, FitsTypeOf, &Error{})
+	c.Check(err.(*Error).Name, Equals, "org.freedesktop.DBus.Error.InvalidArgs")
+
+	for _, method := range []string{"Unexportable", "unexported", "Missing"} {
+		_, err = obj.Call("com.example.GoDbus", method)
+		c.Assert(err, FitsTypeOf, &Error{})
+		c.Check(err.(*Error).Name, Equals, "org.freedesktop.DBus.Error.UnknownMethod")
+	}
+
+	_, err = obj.Call("com.example.Missing", "Add", int32(1), int32(2))
+	c.Assert(err, FitsTypeOf, &Error{})
+	c.Check(err.(*Error).Name, Equals, "org.freedesktop.DBus.Error.UnknownInterface")
+
+	// Once unexported, the object can no longer be found.
+	c.Check(server.Unexport("/go/dbus/test", "com.example.GoDbus"), IsNil)
+	c.Check(server.Unexport("/go/dbus/test", "com.example.GoDbus"), NotNil)
+	_, err = obj.Call("com.example.GoDbus", "Add", int32(40), int32(2))
+	c.Assert(err, FitsTypeOf, &Error{})
+	c.Check(err.(*Error).Name, Equals, "org.freedesktop.DBus.Error.UnknownObject")
+}
+
+func (s *S) TestConnectionExportUnixFD(c *C) {
+	server, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer server.Close()
+	client, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer client.Close()
+
+	r, w, err := os.Pipe()
+	c.Assert(err, IsNil)
+	defer r.Close()
+	_, err = w.Write([]byte("hello"))
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+
+	// File descriptors are passed to exported methods.
+	c.Assert(server.Export(&readTest{}, "/go/dbus/test", "com.example.GoDbus"), IsNil)
+	reply, err := client.Object(server.UniqueName, "/go/dbus/test").Call("com.example.GoDbus", "Read", UnixFD(r.Fd()))
+	c.Assert(err, IsNil)
+	var data string
+	c.Check(reply.Args(&data), IsNil)
+	c.Check(data, Equals, "hello")
+}
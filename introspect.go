@@ -1,9 +1,3 @@
-// +build ignore
-//
-// XXX: The method call logic no longer needs introspection data, so
-// this code is currently unused.  I've kept it around since it may be
-// useful stub generation.
-
 package dbus
 
 import (
@@ -12,126 +6,226 @@ import (
 	"strings"
 )
 
-type annotationData struct {
-	Name  string `xml:"name,attr"`
-	Value string `xml:"value,attr"`
-}
+// The header of an introspection document.
+const introspectDoctype = `<!DOCTYPE node PUBLIC "-//freedesktop//DTD D-BUS Object Introspection 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/introspect.dtd">
+`
+
+// Structures matching the D-Bus introspection data format, as
+// returned by org.freedesktop.DBus.Introspectable.Introspect.
 
-type argData struct {
-	Name      string `xml:"name,attr"`
-	Type      string `xml:"type,attr"`
-	Direction string `xml:"direction,attr"`
+// NodeInfo describes an object, along with the names of its children.
+// The children may also be described in full.
+type NodeInfo struct {
+	Name       string          `xml:"name,attr,omitempty"`
+	Interfaces []InterfaceInfo `xml:"interface"`
+	Children   []NodeInfo      `xml:"node"`
 }
 
-type methodData struct {
-	Name       string         `xml:"name,attr"`
-	Arg        []argData      `xml:"arg"`
-	Annotation annotationData `xml:"annotation"`
+// InterfaceInfo describes an interface provided by an object.
+type InterfaceInfo struct {
+	Name        string           `xml:"name,attr"`
+	Methods     []MethodInfo     `xml:"method"`
+	Signals     []SignalInfo     `xml:"signal"`
+	Properties  []PropertyInfo   `xml:"property"`
+	Annotations []AnnotationInfo `xml:"annotation"`
 }
 
-type signalData struct {
-	Name string    `xml:"name,attr"`
-	Arg  []argData `xml:"arg"`
+// MethodInfo describes a method.  Arguments without a direction are
+// input arguments.
+type MethodInfo struct {
+	Name        string           `xml:"name,attr"`
+	Args        []ArgInfo        `xml:"arg"`
+	Annotations []AnnotationInfo `xml:"annotation"`
 }
 
-type interfaceData struct {
-	Name   string       `xml:"name,attr"`
-	Method []methodData `xml:"method"`
-	Signal []signalData `xml:"signal"`
+// SignalInfo describes a signal.
+type SignalInfo struct {
+	Name        string           `xml:"name,attr"`
+	Args        []ArgInfo        `xml:"arg"`
+	Annotations []AnnotationInfo `xml:"annotation"`
 }
 
-type introspect struct {
-	Name      string          `xml:"name,attr"`
-	Interface []interfaceData `xml:"interface"`
-	Node      []*Introspect   `xml:"node"`
+// PropertyInfo describes a property.  Access is one of "read",
+// "write" or "readwrite".
+type PropertyInfo struct {
+	Name        string           `xml:"name,attr"`
+	Type        Signature        `xml:"type,attr"`
+	Access      string           `xml:"access,attr"`
+	Annotations []AnnotationInfo `xml:"annotation"`
 }
 
-type Introspect interface {
-	GetInterfaceData(name string) InterfaceData
+// ArgInfo describes an argument of a method or signal.  Direction is
+// "in" or "out" for method arguments, and empty for signal arguments.
+type ArgInfo struct {
+	Name      string    `xml:"name,attr,omitempty"`
+	Type      Signature `xml:"type,attr"`
+	Direction string    `xml:"direction,attr,omitempty"`
 }
 
-type InterfaceData interface {
-	GetMethodData(name string) MethodData
-	GetSignalData(name string) SignalData
-	GetName() string
+// AnnotationInfo holds an annotation of an interface or one of its
+// members.
+type AnnotationInfo struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
 }
 
-type MethodData interface {
-	GetName() string
-	GetInSignature() Signature
-	GetOutSignature() Signature
+// ParseIntrospection parses an introspection document.
+func ParseIntrospection(data []byte) (*NodeInfo, error) {
+	node := new(NodeInfo)
+	if err := xml.Unmarshal(data, node); err != nil {
+		return nil, err
+	}
+	return node, nil
 }
 
-type SignalData interface {
-	GetName() string
-	GetSignature() Signature
+// XML returns the introspection document describing the node.
+func (node *NodeInfo) XML() (string, error) {
+	var buff bytes.Buffer
+	buff.WriteString(introspectDoctype)
+	enc := xml.NewEncoder(&buff)
+	enc.Indent("", "  ")
+	if err := enc.EncodeElement(node, xml.StartElement{Name: xml.Name{Local: "node"}}); err != nil {
+		return "", err
+	}
+	buff.WriteString("\n")
+	return buff.String(), nil
 }
 
-func NewIntrospect(xmlIntro string) (Introspect, error) {
-	intro := new(introspect)
-	buff := bytes.NewBufferString(xmlIntro)
-	err := xml.Unmarshal(buff.Bytes(), intro)
-	if err != nil {
-		return nil, err
+// Interface returns the description of the named interface, or nil
+// if the node does not provide it.
+func (node *NodeInfo) Interface(name string) *InterfaceInfo {
+	for i := range node.Interfaces {
+		if node.Interfaces[i].Name == name {
+			return &node.Interfaces[i]
+		}
 	}
+	return nil
+}
 
-	return intro, nil
+// Child returns the named child node, or nil if there is no such
+// child.
+func (node *NodeInfo) Child(name string) *NodeInfo {
+	for i := range node.Children {
+		if node.Children[i].Name == name {
+			return &node.Children[i]
+		}
+	}
+	return nil
 }
 
-func (p introspect) GetInterfaceData(name string) InterfaceData {
-	for _, v := range p.Interface {
-		if v.Name == name {
-			return v
+// Method returns the description of the named method, or nil if the
+// interface has no such method.
+func (iface *InterfaceInfo) Method(name string) *MethodInfo {
+	for i := range iface.Methods {
+		if iface.Methods[i].Name == name {
+			return &iface.Methods[i]
 		}
 	}
 	return nil
 }
 
-func (p interfaceData) GetMethodData(name string) MethodData {
-	for _, v := range p.Method {
-		if v.GetName() == name {
-			return v
+// Signal returns the description of the named signal, or nil if the
+// interface has no such signal.
+func (iface *InterfaceInfo) Signal(name string) *SignalInfo {
+	for i := range iface.Signals {
+		if iface.Signals[i].Name == name {
+			return &iface.Signals[i]
 		}
 	}
 	return nil
 }
 
-func (p interfaceData) GetSignalData(name string) SignalData {
-	for _, v := range p.Signal {
-		if v.GetName() == name {
-			return v
+// Property returns the description of the named property, or nil if
+// the interface has no such property.
+func (iface *InterfaceInfo) Property(name string) *PropertyInfo {
+	for i := range iface.Properties {
+		if iface.Properties[i].Name == name {
+			return &iface.Properties[i]
 		}
 	}
 	return nil
 }
 
-func (p interfaceData) GetName() string { return p.Name }
+// Annotation returns the value of an annotation of the interface.
+func (iface *InterfaceInfo) Annotation(name string) (value string, ok bool) {
+	return annotationValue(iface.Annotations, name)
+}
 
-func (p methodData) GetInSignature() (sig Signature) {
-	for _, v := range p.Arg {
-		if strings.ToUpper(v.Direction) == "IN" {
-			sig += Signature(v.Type)
+// InArgs returns the input arguments of the method.
+func (method *MethodInfo) InArgs() (args []ArgInfo) {
+	for _, arg := range method.Args {
+		if arg.Direction == "" || strings.ToLower(arg.Direction) == "in" {
+			args = append(args, arg)
 		}
 	}
 	return
 }
 
-func (p methodData) GetOutSignature() (sig Signature) {
-	for _, v := range p.Arg {
-		if strings.ToUpper(v.Direction) == "OUT" {
-			sig += Signature(v.Type)
+// OutArgs returns the output arguments of the method.
+func (method *MethodInfo) OutArgs() (args []ArgInfo) {
+	for _, arg := range method.Args {
+		if strings.ToLower(arg.Direction) == "out" {
+			args = append(args, arg)
 		}
 	}
 	return
 }
 
-func (p methodData) GetName() string { return p.Name }
+// InSignature returns the signature of the input arguments of the
+// method.
+func (method *MethodInfo) InSignature() Signature {
+	return argsSignature(method.InArgs())
+}
+
+// OutSignature returns the signature of the output arguments of the
+// method.
+func (method *MethodInfo) OutSignature() Signature {
+	return argsSignature(method.OutArgs())
+}
+
+// Annotation returns the value of an annotation of the method.
+func (method *MethodInfo) Annotation(name string) (value string, ok bool) {
+	return annotationValue(method.Annotations, name)
+}
+
+// Signature returns the signature of the arguments of the signal.
+func (signal *SignalInfo) Signature() Signature {
+	return argsSignature(signal.Args)
+}
+
+// Annotation returns the value of an annotation of the signal.
+func (signal *SignalInfo) Annotation(name string) (value string, ok bool) {
+	return annotationValue(signal.Annotations, name)
+}
 
-func (p signalData) GetSignature() (sig Signature) {
-	for _, v := range p.Arg {
-		sig += Signature(v.Type)
+// Readable returns true if the value of the property can be read.
+func (property *PropertyInfo) Readable() bool {
+	return strings.HasPrefix(property.Access, "read")
+}
+
+// Writable returns true if the value of the property can be set.
+func (property *PropertyInfo) Writable() bool {
+	return strings.HasSuffix(property.Access, "write")
+}
+
+// Annotation returns the value of an annotation of the property.
+func (property *PropertyInfo) Annotation(name string) (value string, ok bool) {
+	return annotationValue(property.Annotations, name)
+}
+
+func argsSignature(args []ArgInfo) (sig Signature) {
+	for _, arg := range args {
+		sig += arg.Type
 	}
 	return
 }
 
-func (p signalData) GetName() string { return p.Name }
+func annotationValue(annotations []AnnotationInfo, name string) (value string, ok bool) {
+	for _, annotation := range annotations {
+		if annotation.Name == name {
+			return annotation.Value, true
+		}
+	}
+	return "", false
+}
@@ -1,5 +1,3 @@
-// +build ignore
-
 package dbus
 
 import (
@@ -35,25 +33,92 @@ var introStr = `
 `
 
 func (s *S) TestIntrospect(c *C) {
-	intro, err := NewIntrospect(introStr)
+	intro, err := ParseIntrospection([]byte(introStr))
 	c.Assert(err, Equals, nil)
 	c.Assert(intro, Not(Equals), nil)
+	c.Check(intro.Name, Equals, "/org/freedesktop/sample_object")
 
-	intf := intro.GetInterfaceData("org.freedesktop.SampleInterface")
+	intf := intro.Interface("org.freedesktop.SampleInterface")
 	c.Assert(intf, Not(Equals), nil)
-	c.Check(intf.GetName(), Equals, "org.freedesktop.SampleInterface")
+	c.Check(intf.Name, Equals, "org.freedesktop.SampleInterface")
+	c.Check(intro.Interface("org.freedesktop.Hoo"), IsNil)
 
-	meth := intf.GetMethodData("Frobate")
+	meth := intf.Method("Frobate")
 	c.Assert(meth, Not(Equals), nil)
-	c.Check(meth.GetOutSignature(), Equals, Signature("sa{us}"))
+	c.Check(meth.InSignature(), Equals, Signature("i"))
+	c.Check(meth.OutSignature(), Equals, Signature("sa{us}"))
+	c.Check(meth.OutArgs(), DeepEquals, []ArgInfo{{"bar", "s", "out"}, {"baz", "a{us}", "out"}})
+	value, ok := meth.Annotation("org.freedesktop.DBus.Deprecated")
+	c.Check(ok, Equals, true)
+	c.Check(value, Equals, "true")
+	value, ok = meth.Annotation("org.freedesktop.DBus.Method.NoReply")
+	c.Check(ok, Equals, false)
 
-	nilmeth := intf.GetMethodData("Hoo") // unknown method name
-	c.Check(nilmeth, Equals, nil)
+	nilmeth := intf.Method("Hoo") // unknown method name
+	c.Check(nilmeth, IsNil)
 
-	signal := intf.GetSignalData("Changed")
+	signal := intf.Signal("Changed")
 	c.Assert(signal, Not(Equals), nil)
-	c.Check(signal.GetSignature(), Equals, Signature("b"))
+	c.Check(signal.Signature(), Equals, Signature("b"))
+
+	nilsignal := intf.Signal("Hoo") // unknown signal name
+	c.Check(nilsignal, IsNil)
+
+	prop := intf.Property("Bar")
+	c.Assert(prop, Not(Equals), nil)
+	c.Check(prop.Type, Equals, Signature("y"))
+	c.Check(prop.Readable(), Equals, true)
+	c.Check(prop.Writable(), Equals, true)
+	c.Check(intf.Property("Hoo"), IsNil)
+
+	c.Check(intro.Children, HasLen, 2)
+	c.Check(intro.Child("child_of_sample_object"), Not(IsNil))
+	c.Check(intro.Child("hoo"), IsNil)
+}
+
+func (s *S) TestIntrospectXML(c *C) {
+	node := &NodeInfo{
+		Interfaces: []InterfaceInfo{{
+			Name: "com.example.GoDbus",
+			Methods: []MethodInfo{{
+				Name: "Add",
+				Args: []ArgInfo{{"a", "i", "in"}, {"b", "i", "in"}, {"", "i", "out"}}}},
+			Signals: []SignalInfo{{
+				Name: "Changed",
+				Args: []ArgInfo{{Name: "value", Type: "s"}}}},
+			Properties: []PropertyInfo{{
+				Name:   "Count",
+				Type:   "u",
+				Access: "read",
+				Annotations: []AnnotationInfo{
+					{"org.freedesktop.DBus.Property.EmitsChangedSignal", "const"},
+					{"org.freedesktop.DBus.Deprecated", "true"}}}}}},
+		Children: []NodeInfo{{Name: "child"}}}
+	data, err := node.XML()
+	c.Assert(err, IsNil)
+	c.Check(data, Equals, `<!DOCTYPE node PUBLIC "-//freedesktop//DTD D-BUS Object Introspection 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/introspect.dtd">
+<node>
+  <interface name="com.example.GoDbus">
+    <method name="Add">
+      <arg name="a" type="i" direction="in"></arg>
+      <arg name="b" type="i" direction="in"></arg>
+      <arg type="i" direction="out"></arg>
+    </method>
+    <signal name="Changed">
+      <arg name="value" type="s"></arg>
+    </signal>
+    <property name="Count" type="u" access="read">
+      <annotation name="org.freedesktop.DBus.Property.EmitsChangedSignal" value="const"></annotation>
+      <annotation name="org.freedesktop.DBus.Deprecated" value="true"></annotation>
+    </property>
+  </interface>
+  <node name="child"></node>
+</node>
+`)
 
-	nilsignal := intf.GetSignalData("Hoo") // unknown signal name
-	c.Check(nilsignal, Equals, nil)
+	// The document can be parsed back.
+	parsed, err := ParseIntrospection([]byte(data))
+	c.Assert(err, IsNil)
+	c.Check(parsed, DeepEquals, node)
 }
@@ -0,0 +1,190 @@
+package dbus
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const INTROSPECTABLE_IFACE = "org.freedesktop.DBus.Introspectable"
+
+// The standard interfaces provided for exported objects.
+var (
+	peerInterface = InterfaceInfo{
+		Name: "org.freedesktop.DBus.Peer",
+		Methods: []MethodInfo{
+			{Name: "Ping"},
+			{Name: "GetMachineId", Args: []ArgInfo{{"machine_uuid", "s", "out"}}}}}
+	introspectableInterface = InterfaceInfo{
+		Name: INTROSPECTABLE_IFACE,
+		Methods: []MethodInfo{
+			{Name: "Introspect", Args: []ArgInfo{{"xml_data", "s", "out"}}}}}
+	propertiesInterface = InterfaceInfo{
+		Name: PROPERTIES_IFACE,
+		Methods: []MethodInfo{
+			{Name: "Get", Args: []ArgInfo{{"interface_name", "s", "in"}, {"property_name", "s", "in"}, {"value", "v", "out"}}},
+			{Name: "GetAll", Args: []ArgInfo{{"interface_name", "s", "in"}, {"props", "a{sv}", "out"}}},
+			{Name: "Set", Args: []ArgInfo{{"interface_name", "s", "in"}, {"property_name", "s", "in"}, {"value", "v", "in"}}}},
+		Signals: []SignalInfo{
+			{Name: "PropertiesChanged", Args: []ArgInfo{{"interface_name", "s", ""}, {"changed_properties", "a{sv}", ""}, {"invalidated_properties", "as", ""}}}}}
+	objectManagerInterface = InterfaceInfo{
+		Name: OBJECT_MANAGER_IFACE,
+		Methods: []MethodInfo{
+			{Name: "GetManagedObjects", Args: []ArgInfo{{"object_paths_interfaces_and_properties", "a{oa{sa{sv}}}", "out"}}}},
+		Signals: []SignalInfo{
+			{Name: "InterfacesAdded", Args: []ArgInfo{{"object_path", "o", ""}, {"interfaces_and_properties", "a{sa{sv}}", ""}}},
+			{Name: "InterfacesRemoved", Args: []ArgInfo{{"object_path", "o", ""}, {"interfaces", "as", ""}}}}}
+)
+
+// DeclareSignal records that the object at path emits the named
+// signal of an interface, with arguments of the given signature, so
+// that it is included in the object's introspection data.
+func (p *Connection) DeclareSignal(path ObjectPath, iface, name string, sig Signature) error {
+	if err := sig.Validate(); err != nil {
+		return err
+	}
+	p.handlerMutex.Lock()
+	exported, added := p.exportedInterface(path, iface)
+	if _, ok := exported.signals[name]; ok {
+		p.handlerMutex.Unlock()
+		return errors.New("Signal " + name + " of interface " + iface + " already declared on " + string(path))
+	}
+	if exported.signals == nil {
+		exported.signals = make(map[string]Signature)
+	}
+	exported.signals[name] = sig
+	p.handlerMutex.Unlock()
+
+	if added {
+		p.interfaceAdded(path, iface)
+	}
+	return nil
+}
+
+// introspectMethod returns the method answering an Introspect call,
+// or nil if the call should be handled elsewhere.  The caller must
+// hold handlerMutex.
+func (p *Connection) introspectMethod(msg *Message) *exportedMethod {
+	// Leave objects that handle their own introspection alone.
+	if object, ok := p.exportedObjects[msg.Path]; ok {
+		if object.interfaces[INTROSPECTABLE_IFACE] != nil {
+			return nil
+		}
+	} else if _, ok := p.lookupHandler(msg.Path); ok {
+		return nil
+	}
+	node, ok := p.introspectNode(msg.Path)
+	if !ok {
+		return nil
+	}
+	method, _ := newExportedMethod(msg.Member, reflect.ValueOf(node.XML))
+	return method
+}
+
+// introspectNode describes the object at path and lists its
+// children.  If nothing is exported at or below path, ok is false.
+// The caller must hold handlerMutex.
+func (p *Connection) introspectNode(path ObjectPath) (node NodeInfo, ok bool) {
+	children := make(map[string]bool)
+	addChild := func(other ObjectPath) {
+		if other == path || !inPathNamespace(other, path) {
+			return
+		}
+		rest := strings.TrimPrefix(string(other[len(path):]), "/")
+		children[strings.SplitN(rest, "/", 2)[0]] = true
+	}
+	for other := range p.exportedObjects {
+		addChild(other)
+	}
+	for other := range p.objectManagers {
+		addChild(other)
+	}
+	object, exported := p.exportedObjects[path]
+	manager := p.objectManagers[path]
+	if !exported && !manager && len(children) == 0 {
+		return node, false
+	}
+
+	node.Interfaces = []InterfaceInfo{peerInterface, introspectableInterface}
+	if exported {
+		node.Interfaces = append(node.Interfaces, propertiesInterface)
+	}
+	if manager {
+		node.Interfaces = append(node.Interfaces, objectManagerInterface)
+	}
+	if exported {
+		names := make([]string, 0, len(object.interfaces))
+		for name := range object.interfaces {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			node.Interfaces = append(node.Interfaces, object.interfaces[name].introspect())
+		}
+	}
+
+	names := make([]string, 0, len(children))
+	for name := range children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		node.Children = append(node.Children, NodeInfo{Name: name})
+	}
+	return node, true
+}
+
+// introspect describes an exported interface.
+func (iface *exportedInterface) introspect() InterfaceInfo {
+	data := InterfaceInfo{Name: iface.name}
+	for _, name := range sortedKeys(iface.methods) {
+		method := iface.methods[name]
+		args := append(signatureArgs(method.inSig, "in"), signatureArgs(method.outSig, "out")...)
+		data.Methods = append(data.Methods, MethodInfo{Name: name, Args: args})
+	}
+	for _, name := range sortedKeys(iface.signals) {
+		data.Signals = append(data.Signals, SignalInfo{Name: name, Args: signatureArgs(iface.signals[name], "")})
+	}
+	for _, name := range sortedKeys(iface.properties) {
+		property := iface.properties[name]
+		access := "read"
+		switch {
+		case property.readable() && property.writable():
+			access = "readwrite"
+		case property.writable():
+			access = "write"
+		}
+		propertyData := PropertyInfo{Name: name, Type: property.sig, Access: access}
+		if property.emitsChanged != EmitsChangedTrue {
+			propertyData.Annotations = []AnnotationInfo{{"org.freedesktop.DBus.Property.EmitsChangedSignal", property.emitsChanged.String()}}
+		}
+		data.Properties = append(data.Properties, propertyData)
+	}
+	return data
+}
+
+// signatureArgs describes each complete type in a signature as an
+// argument.
+func signatureArgs(sig Signature, direction string) (args []ArgInfo) {
+	for offset := 0; offset < len(sig); {
+		next, err := sig.NextType(offset)
+		if err != nil {
+			break
+		}
+		args = append(args, ArgInfo{Type: sig[offset:next], Direction: direction})
+		offset = next
+	}
+	return
+}
+
+// sortedKeys returns the keys of a map with string keys in order.
+func sortedKeys(m interface{}) []string {
+	keys := reflect.ValueOf(m).MapKeys()
+	names := make([]string, len(keys))
+	for i, key := range keys {
+		names[i] = key.String()
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,114 @@
+package dbus
+
+import (
+	"strings"
+
+	. "launchpad.net/gocheck"
+)
+
+func introspectObject(c *C, conn *Connection, dest string, path ObjectPath) *NodeInfo {
+	reply, err := conn.Object(dest, path).Call(INTROSPECTABLE_IFACE, "Introspect")
+	c.Assert(err, IsNil)
+	var data string
+	c.Assert(reply.Args(&data), IsNil)
+	c.Check(strings.HasPrefix(data, "<!DOCTYPE node"), Equals, true)
+	node, err := ParseIntrospection([]byte(data))
+	c.Assert(err, IsNil)
+	return node
+}
+
+func interfaceNames(node *NodeInfo) []string {
+	var names []string
+	for _, iface := range node.Interfaces {
+		names = append(names, iface.Name)
+	}
+	return names
+}
+
+func (s *S) TestConnectionIntrospect(c *C) {
+	server, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer server.Close()
+	client, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer client.Close()
+
+	c.Assert(server.Export(&exportTest{}, "/go/dbus/test", "com.example.GoDbus"), IsNil)
+	t := &propertiesTest{name: "foo", count: 42}
+	c.Assert(t.export(server), IsNil)
+	c.Assert(server.DeclareSignal("/go/dbus/test", "com.example.GoDbus", "Changed", "sa{sv}"), IsNil)
+	c.Check(server.DeclareSignal("/go/dbus/test", "com.example.GoDbus", "Changed", "s"), NotNil)
+	c.Check(server.DeclareSignal("/go/dbus/test", "com.example.GoDbus", "Bad", "a"), NotNil)
+	c.Assert(server.Export(&exportTest{}, "/go/dbus/test/child", "com.example.GoDbus"), IsNil)
+
+	node := introspectObject(c, client, server.UniqueName, "/go/dbus/test")
+	c.Check(interfaceNames(node), DeepEquals, []string{
+		"org.freedesktop.DBus.Peer",
+		INTROSPECTABLE_IFACE,
+		PROPERTIES_IFACE,
+		"com.example.GoDbus"})
+	c.Check(node.Interfaces[3], DeepEquals, InterfaceInfo{
+		Name: "com.example.GoDbus",
+		Methods: []MethodInfo{
+			{Name: "Add", Args: []ArgInfo{{"", "i", "in"}, {"", "i", "in"}, {"", "i", "out"}}},
+			{Name: "DBusError"},
+			{Name: "Greet", Args: []ArgInfo{{"", "s", "in"}, {"", "s", "out"}}},
+			{Name: "PlainError"},
+			{Name: "Swap", Args: []ArgInfo{{"", "s", "in"}, {"", "u", "in"}, {"", "u", "out"}, {"", "s", "out"}}}},
+		Signals: []SignalInfo{
+			{Name: "Changed", Args: []ArgInfo{{"", "s", ""}, {"", "a{sv}", ""}}}},
+		Properties: []PropertyInfo{
+			{Name: "Count", Type: "i", Access: "readwrite", Annotations: []AnnotationInfo{
+				{"org.freedesktop.DBus.Property.EmitsChangedSignal", "invalidates"}}},
+			{Name: "Name", Type: "s", Access: "readwrite"},
+			{Name: "Secret", Type: "s", Access: "write"},
+			{Name: "Version", Type: "s", Access: "read", Annotations: []AnnotationInfo{
+				{"org.freedesktop.DBus.Property.EmitsChangedSignal", "const"}}}}})
+	c.Check(node.Children, DeepEquals, []NodeInfo{
+		{Name: "child"}})
+
+	// Intermediate paths list their children.
+	c.Assert(server.ExportObjectManager("/go"), IsNil)
+	c.Assert(server.Export(&exportTest{}, "/go/other", "com.example.GoDbus"), IsNil)
+	node = introspectObject(c, client, server.UniqueName, "/")
+	c.Check(interfaceNames(node), DeepEquals, []string{
+		"org.freedesktop.DBus.Peer",
+		INTROSPECTABLE_IFACE})
+	c.Check(node.Children, DeepEquals, []NodeInfo{
+		{Name: "go"}})
+	node = introspectObject(c, client, server.UniqueName, "/go")
+	c.Check(interfaceNames(node), DeepEquals, []string{
+		"org.freedesktop.DBus.Peer",
+		INTROSPECTABLE_IFACE,
+		OBJECT_MANAGER_IFACE})
+	c.Check(node.Children, DeepEquals, []NodeInfo{
+		{Name: "dbus"},
+		{Name: "other"}})
+
+	// Paths with nothing beneath them are unknown.
+	_, err = client.Object(server.UniqueName, "/go/dbus/other").Call(INTROSPECTABLE_IFACE, "Introspect")
+	c.Check(err, NotNil)
+}
+
+func (s *S) TestConnectionIntrospectExported(c *C) {
+	server, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer server.Close()
+	client, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer client.Close()
+
+	// An object can provide its own introspection data.
+	c.Assert(server.Export(&introspectableTest{}, "/go/dbus/test", INTROSPECTABLE_IFACE), IsNil)
+	reply, err := client.Object(server.UniqueName, "/go/dbus/test").Call(INTROSPECTABLE_IFACE, "Introspect")
+	c.Assert(err, IsNil)
+	var data string
+	c.Assert(reply.Args(&data), IsNil)
+	c.Check(data, Equals, "<node/>")
+}
+
+type introspectableTest struct{}
+
+func (t *introspectableTest) Introspect() string {
+	return "<node/>"
+}
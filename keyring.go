@@ -0,0 +1,250 @@
+package dbus
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// The context of the cookies used for authentication.
+	defaultCookieContext = "org_freedesktop_general"
+	// A new cookie is created if there is none younger than this.
+	cookieNewKeyTimeout = 5 * time.Minute
+	// Cookies older than this are removed from the keyring.
+	cookieExpireTimeout = cookieNewKeyTimeout + 2*time.Minute
+	// Cookies created further than this in the future are removed,
+	// so that a clock set ahead can't make a cookie permanent.
+	cookieMaxTimeTravel = 5 * time.Minute
+	// The number of random bytes in a cookie.
+	cookieSize = 24
+
+	// How many times, and how often, to try to take the lock on a
+	// keyring before deciding that the lock is stale.
+	keyringLockAttempts = 32
+	keyringLockInterval = 250 * time.Millisecond
+)
+
+// keyringCookie is an entry in a keyring.
+type keyringCookie struct {
+	id      uint32
+	created time.Time
+	cookie  string
+}
+
+// cookieKeyring manages the cookies of one context in a keyring
+// directory, as used by the DBUS_COOKIE_SHA1 mechanism.  The keyring
+// is a file named after the context holding a cookie per line.
+type cookieKeyring struct {
+	dir     string
+	context string
+}
+
+// defaultKeyringDir returns the keyring directory of the current user.
+func defaultKeyringDir() (string, error) {
+	home := os.Getenv("HOME")
+	if home == "" {
+		u, err := user.Current()
+		if err != nil {
+			return "", err
+		}
+		home = u.HomeDir
+	}
+	return filepath.Join(home, ".dbus-keyrings"), nil
+}
+
+// validCookieContext returns true if context can name a keyring.
+func validCookieContext(context string) bool {
+	if context == "" {
+		return false
+	}
+	for i := 0; i < len(context); i++ {
+		switch b := context[i]; {
+		case b < 0x20 || b >= 0x7f, b == '/', b == '\\', b == ' ', b == '.':
+			return false
+		}
+	}
+	return true
+}
+
+// ensureDir creates the keyring directory if needed, and checks that
+// other users can not access it.
+func (k *cookieKeyring) ensureDir() error {
+	if err := os.MkdirAll(k.dir, 0700); err != nil {
+		return err
+	}
+	info, err := os.Stat(k.dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errors.New("Keyring " + k.dir + " is not a directory")
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return errors.New("Keyring directory " + k.dir + " is accessible by other users")
+	}
+	return nil
+}
+
+// lock takes the lock on the keyring, which is held by creating a lock
+// file.  A lock that can not be taken for some time is assumed to be
+// left over from a crashed process, and broken.
+func (k *cookieKeyring) lock() (unlock func(), err error) {
+	lockFile := filepath.Join(k.dir, k.context+".lock")
+	for attempt := 0; ; attempt++ {
+		f, err := os.OpenFile(lockFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lockFile) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if attempt == keyringLockAttempts {
+			if err := os.Remove(lockFile); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+		} else if attempt > keyringLockAttempts {
+			return nil, errors.New("Could not lock keyring " + lockFile)
+		} else {
+			time.Sleep(keyringLockInterval)
+		}
+	}
+}
+
+// load reads the cookies in the keyring, skipping malformed lines.
+func (k *cookieKeyring) load() ([]keyringCookie, error) {
+	data, err := ioutil.ReadFile(filepath.Join(k.dir, k.context))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var cookies []keyringCookie
+	seen := make(map[uint32]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), " ")
+		if len(fields) != 3 {
+			continue
+		}
+		id, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil || seen[uint32(id)] {
+			continue
+		}
+		created, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		if _, err := hex.DecodeString(fields[2]); err != nil || fields[2] == "" {
+			continue
+		}
+		seen[uint32(id)] = true
+		cookies = append(cookies, keyringCookie{uint32(id), time.Unix(created, 0), fields[2]})
+	}
+	return cookies, scanner.Err()
+}
+
+// save replaces the keyring with the given cookies.
+func (k *cookieKeyring) save(cookies []keyringCookie) error {
+	f, err := ioutil.TempFile(k.dir, k.context+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	var buff bytes.Buffer
+	for _, cookie := range cookies {
+		fmt.Fprintf(&buff, "%d %d %s\n", cookie.id, cookie.created.Unix(), cookie.cookie)
+	}
+	_, err = f.Write(buff.Bytes())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(k.dir, k.context))
+}
+
+// currentCookie returns a cookie recent enough to issue a challenge
+// with.  Expired cookies are removed from the keyring, and a new
+// cookie is added if none is recent enough.
+func (k *cookieKeyring) currentCookie() (keyringCookie, error) {
+	if err := k.ensureDir(); err != nil {
+		return keyringCookie{}, err
+	}
+	unlock, err := k.lock()
+	if err != nil {
+		return keyringCookie{}, err
+	}
+	defer unlock()
+	cookies, err := k.load()
+	if err != nil {
+		return keyringCookie{}, err
+	}
+
+	now := time.Now()
+	kept := cookies[:0]
+	var current *keyringCookie
+	for _, cookie := range cookies {
+		if cookie.created.Before(now.Add(-cookieExpireTimeout)) || cookie.created.After(now.Add(cookieMaxTimeTravel)) {
+			continue
+		}
+		kept = append(kept, cookie)
+		if cookie.created.After(now.Add(-cookieNewKeyTimeout)) && (current == nil || cookie.created.After(current.created)) {
+			current = &kept[len(kept)-1]
+		}
+	}
+	if current != nil && len(kept) == len(cookies) {
+		return *current, nil
+	}
+	if current == nil {
+		cookie, err := newKeyringCookie(kept, now)
+		if err != nil {
+			return keyringCookie{}, err
+		}
+		kept = append(kept, cookie)
+		current = &kept[len(kept)-1]
+	}
+	if err := k.save(kept); err != nil {
+		return keyringCookie{}, err
+	}
+	return *current, nil
+}
+
+// newKeyringCookie returns a random cookie with an id not used by any
+// of the existing cookies.
+func newKeyringCookie(existing []keyringCookie, now time.Time) (keyringCookie, error) {
+	secret := make([]byte, cookieSize)
+	if _, err := rand.Read(secret); err != nil {
+		return keyringCookie{}, err
+	}
+	var id uint32
+	for {
+		if err := binary.Read(rand.Reader, binary.LittleEndian, &id); err != nil {
+			return keyringCookie{}, err
+		}
+		used := false
+		for _, cookie := range existing {
+			used = used || cookie.id == id
+		}
+		if !used {
+			break
+		}
+	}
+	return keyringCookie{id, time.Unix(now.Unix(), 0), hex.EncodeToString(secret)}, nil
+}
@@ -0,0 +1,75 @@
+package dbus
+
+import (
+	"fmt"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func (s *S) TestCookieKeyring(c *C) {
+	dir := filepath.Join(c.MkDir(), ".dbus-keyrings")
+	keyring := &cookieKeyring{dir, defaultCookieContext}
+
+	// The directory and keyring are created as needed.
+	cookie, err := keyring.currentCookie()
+	c.Assert(err, IsNil)
+	c.Check(cookie.cookie, Matches, "[0-9a-f]{48}")
+	info, err := os.Stat(dir)
+	c.Assert(err, IsNil)
+	c.Check(info.Mode().Perm(), Equals, os.FileMode(0700))
+	info, err = os.Stat(filepath.Join(dir, defaultCookieContext))
+	c.Assert(err, IsNil)
+	c.Check(info.Mode().Perm(), Equals, os.FileMode(0600))
+	_, err = os.Stat(filepath.Join(dir, defaultCookieContext+".lock"))
+	c.Check(os.IsNotExist(err), Equals, true)
+
+	// The cookie is reused while it is recent.
+	again, err := keyring.currentCookie()
+	c.Assert(err, IsNil)
+	c.Check(again, DeepEquals, cookie)
+}
+
+func (s *S) TestCookieKeyringExpiry(c *C) {
+	dir := c.MkDir()
+	c.Assert(os.Chmod(dir, 0700), IsNil)
+	keyring := &cookieKeyring{dir, defaultCookieContext}
+	now := time.Now().Unix()
+	data := strings.Join([]string{
+		fmt.Sprintf("1 %d 0123456789abcdef", now-int64(cookieExpireTimeout/time.Second)-60),
+		fmt.Sprintf("2 %d 0123456789abcdef", now-int64(cookieNewKeyTimeout/time.Second)-60),
+		fmt.Sprintf("3 %d 0123456789abcdef", now+int64(cookieMaxTimeTravel/time.Second)+60),
+		"malformed line",
+		""}, "\n")
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, defaultCookieContext), []byte(data), 0600), IsNil)
+
+	// Expired cookies and those from the future are removed, and
+	// a new cookie is made since none is recent enough.
+	cookie, err := keyring.currentCookie()
+	c.Assert(err, IsNil)
+	cookies, err := keyring.load()
+	c.Assert(err, IsNil)
+	c.Assert(cookies, HasLen, 2)
+	c.Check(cookies[0].id, Equals, uint32(2))
+	c.Check(cookies[1], DeepEquals, cookie)
+	c.Check(cookie.id, Not(Equals), uint32(2))
+}
+
+func (s *S) TestCookieKeyringPermissions(c *C) {
+	dir := c.MkDir()
+	c.Assert(os.Chmod(dir, 0755), IsNil)
+	keyring := &cookieKeyring{dir, defaultCookieContext}
+	_, err := keyring.currentCookie()
+	c.Check(err, ErrorMatches, "Keyring directory .* is accessible by other users")
+}
+
+func (s *S) TestValidCookieContext(c *C) {
+	c.Check(validCookieContext(defaultCookieContext), Equals, true)
+	c.Check(validCookieContext(""), Equals, false)
+	c.Check(validCookieContext("../secret"), Equals, false)
+	c.Check(validCookieContext("a b"), Equals, false)
+	c.Check(validCookieContext("a\tb"), Equals, false)
+}
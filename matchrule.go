@@ -1,41 +1,110 @@
 package dbus
 
+import "errors"
 import "fmt"
+import "sort"
+import "strconv"
 import "strings"
 
+// The highest argument index that can be matched against.
+const maxMatchRuleArg = 63
+
 // Matches all messages with equal type, interface, member, or path.
 // Any missing/invalid fields are not matched against.
+//
+// Args and ArgPaths match string arguments by index, as the argN and
+// argNpath keys of the match rule syntax do.  Arg0 takes precedence
+// over an entry for index 0 in Args; to match an empty first argument,
+// set Args[0] to "".  Eavesdrop is only meaningful to the bus daemon
+// and is ignored when matching locally.
 type MatchRule struct {
-	Type      MessageType
-	Sender    string
-	Path      ObjectPath
-	Interface string
-	Member    string
-	Arg0      string
+	Type          MessageType
+	Sender        string
+	Path          ObjectPath
+	PathNamespace ObjectPath
+	Interface     string
+	Member        string
+	Destination   string
+	Arg0          string
+	Args          map[int]string
+	ArgPaths      map[int]string
+	Arg0Namespace string
+	Eavesdrop     bool
 
 	senderNameOwner string
 }
 
+// quoteMatchRuleValue quotes a value for use in a match rule.  There
+// is no escaping inside quotes, so apostrophes are written as an
+// escaped apostrophe between two quoted sections.
+func quoteMatchRuleValue(value string) string {
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
+
+// sortedArgIndexes returns the keys of an argument map in order.
+func sortedArgIndexes(args map[int]string) []int {
+	indexes := make([]int, 0, len(args))
+	for i := range args {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+// argMatches returns the string argument matches keyed by index,
+// including Arg0.
+func (p *MatchRule) argMatches() map[int]string {
+	if p.Arg0 == "" {
+		return p.Args
+	}
+	args := map[int]string{0: p.Arg0}
+	for i, value := range p.Args {
+		if i != 0 {
+			args[i] = value
+		}
+	}
+	return args
+}
+
 // A string representation af the MatchRule (D-Bus variant map).
 func (p *MatchRule) String() string {
 	params := make([]string, 0, 6)
+	add := func(key, value string) {
+		params = append(params, key+"="+quoteMatchRuleValue(value))
+	}
 	if p.Type != TypeInvalid {
-		params = append(params, fmt.Sprintf("type='%s'", p.Type))
+		add("type", p.Type.String())
 	}
 	if p.Sender != "" {
-		params = append(params, fmt.Sprintf("sender='%s'", p.Sender))
+		add("sender", p.Sender)
 	}
 	if p.Path != "" {
-		params = append(params, fmt.Sprintf("path='%s'", p.Path))
+		add("path", string(p.Path))
+	}
+	if p.PathNamespace != "" {
+		add("path_namespace", string(p.PathNamespace))
 	}
 	if p.Interface != "" {
-		params = append(params, fmt.Sprintf("interface='%s'", p.Interface))
+		add("interface", p.Interface)
 	}
 	if p.Member != "" {
-		params = append(params, fmt.Sprintf("member='%s'", p.Member))
+		add("member", p.Member)
+	}
+	if p.Destination != "" {
+		add("destination", p.Destination)
+	}
+	args := p.argMatches()
+	for _, i := range sortedArgIndexes(args) {
+		add(fmt.Sprintf("arg%d", i), args[i])
 	}
-	if p.Arg0 != "" {
-		params = append(params, fmt.Sprintf("arg0='%s'", p.Arg0))
+	for _, i := range sortedArgIndexes(p.ArgPaths) {
+		add(fmt.Sprintf("arg%dpath", i), p.ArgPaths[i])
+	}
+	if p.Arg0Namespace != "" {
+		add("arg0namespace", p.Arg0Namespace)
+	}
+	if p.Eavesdrop {
+		add("eavesdrop", "true")
 	}
 	return strings.Join(params, ",")
 }
@@ -52,17 +121,265 @@ func (p *MatchRule) Match(msg *Message) bool {
 	if p.Path != "" && p.Path != msg.Path {
 		return false
 	}
+	if p.PathNamespace != "" && !inPathNamespace(msg.Path, p.PathNamespace) {
+		return false
+	}
 	if p.Interface != "" && p.Interface != msg.Interface {
 		return false
 	}
 	if p.Member != "" && p.Member != msg.Member {
 		return false
 	}
-	if p.Arg0 != "" {
-		var arg0 string
-		if err := msg.Args(&arg0); err != nil || arg0 != p.Arg0 {
+	if p.Destination != "" && p.Destination != msg.Dest {
+		return false
+	}
+	return p.matchArgs(msg)
+}
+
+// matchArgs checks the message arguments against the argN, argNpath
+// and arg0namespace parts of the rule.
+func (p *MatchRule) matchArgs(msg *Message) bool {
+	args := p.argMatches()
+	if len(args) == 0 && len(p.ArgPaths) == 0 && p.Arg0Namespace == "" {
+		return true
+	}
+	// Only decode as many arguments as are needed.
+	last := 0
+	for i := range args {
+		if i > last {
+			last = i
+		}
+	}
+	for i := range p.ArgPaths {
+		if i > last {
+			last = i
+		}
+	}
+	values := make([]interface{}, 0, last+1)
+	dec := newDecoder(msg.sig, msg.body, msg.order)
+	dec.fds = msg.fds
+	for len(values) <= last && dec.HasMore() {
+		var value interface{}
+		if err := dec.Decode(&value); err != nil {
+			return false
+		}
+		values = append(values, value)
+	}
+
+	for i, expected := range args {
+		if i >= len(values) {
+			return false
+		}
+		if value, ok := values[i].(string); !ok || value != expected {
+			return false
+		}
+	}
+	for i, expected := range p.ArgPaths {
+		if i >= len(values) {
+			return false
+		}
+		var value string
+		switch v := values[i].(type) {
+		case string:
+			value = v
+		case ObjectPath:
+			value = string(v)
+		default:
+			return false
+		}
+		if !pathArgMatches(value, expected) {
+			return false
+		}
+	}
+	if p.Arg0Namespace != "" {
+		if len(values) == 0 {
+			return false
+		}
+		value, ok := values[0].(string)
+		if !ok || !(value == p.Arg0Namespace || strings.HasPrefix(value, p.Arg0Namespace+".")) {
 			return false
 		}
 	}
 	return true
 }
+
+// inPathNamespace returns true if path is equal to or a descendant of
+// namespace.
+func inPathNamespace(path, namespace ObjectPath) bool {
+	if namespace == "/" || path == namespace {
+		return true
+	}
+	return strings.HasPrefix(string(path), string(namespace)+"/")
+}
+
+// pathArgMatches implements argNpath matching: the values are equal,
+// or one of them ends with '/' and is a prefix of the other.
+func pathArgMatches(value, expected string) bool {
+	if value == expected {
+		return true
+	}
+	if strings.HasSuffix(expected, "/") && strings.HasPrefix(value, expected) {
+		return true
+	}
+	return strings.HasSuffix(value, "/") && strings.HasPrefix(expected, value)
+}
+
+// parseArgKey parses a match rule key of the form argN or argNpath,
+// returning the argument index.
+func parseArgKey(key string) (index int, path bool, ok bool) {
+	if !strings.HasPrefix(key, "arg") {
+		return 0, false, false
+	}
+	digits := key[3:]
+	if strings.HasSuffix(digits, "path") {
+		digits = digits[:len(digits)-4]
+		path = true
+	}
+	if digits == "" || len(digits) > 2 || (len(digits) > 1 && digits[0] == '0') {
+		return 0, false, false
+	}
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return 0, false, false
+		}
+	}
+	index, _ = strconv.Atoi(digits)
+	return index, path, true
+}
+
+// ParseMatchRule parses the string form of a match rule, as passed to
+// the bus daemon's AddMatch method.
+func ParseMatchRule(rule string) (*MatchRule, error) {
+	p := new(MatchRule)
+	for _, pair := range splitMatchRule(rule) {
+		if pair == "" {
+			continue
+		}
+		pos := strings.Index(pair, "=")
+		if pos < 0 {
+			return nil, fmt.Errorf("Match rule element %q has no value", pair)
+		}
+		key := pair[:pos]
+		value, err := unquoteMatchRuleValue(pair[pos+1:])
+		if err != nil {
+			return nil, err
+		}
+		switch key {
+		case "type":
+			p.Type = TypeInvalid
+			for t, name := range messageTypeString {
+				if name == value && t != TypeInvalid {
+					p.Type = t
+				}
+			}
+			if p.Type == TypeInvalid {
+				return nil, errors.New("Unknown message type in match rule: " + value)
+			}
+		case "sender":
+			p.Sender = value
+		case "path":
+			p.Path = ObjectPath(value)
+		case "path_namespace":
+			p.PathNamespace = ObjectPath(value)
+		case "interface":
+			p.Interface = value
+		case "member":
+			p.Member = value
+		case "destination":
+			p.Destination = value
+		case "arg0":
+			// An empty Arg0 is not matched against, so an
+			// explicit empty string is kept in Args instead.
+			if value == "" {
+				if p.Args == nil {
+					p.Args = make(map[int]string)
+				}
+				p.Args[0] = value
+			} else {
+				p.Arg0 = value
+			}
+		case "arg0namespace":
+			p.Arg0Namespace = value
+		case "eavesdrop":
+			switch value {
+			case "true":
+				p.Eavesdrop = true
+			case "false":
+				p.Eavesdrop = false
+			default:
+				return nil, errors.New("Invalid eavesdrop value in match rule: " + value)
+			}
+		default:
+			index, path, ok := parseArgKey(key)
+			if !ok {
+				return nil, errors.New("Unknown key in match rule: " + key)
+			}
+			if index > maxMatchRuleArg {
+				return nil, errors.New("Argument index out of range in match rule: " + key)
+			}
+			if path {
+				if p.ArgPaths == nil {
+					p.ArgPaths = make(map[int]string)
+				}
+				p.ArgPaths[index] = value
+			} else {
+				if p.Args == nil {
+					p.Args = make(map[int]string)
+				}
+				p.Args[index] = value
+			}
+		}
+	}
+	if p.Path != "" && p.PathNamespace != "" {
+		return nil, errors.New("Match rule can not contain both path and path_namespace")
+	}
+	return p, nil
+}
+
+// splitMatchRule splits a match rule at the commas that are not
+// inside quoted values.
+func splitMatchRule(rule string) []string {
+	var pairs []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(rule); i++ {
+		switch rule[i] {
+		case '\'':
+			quoted = !quoted
+		case '\\':
+			// Skip over escaped apostrophes.
+			if !quoted && i+1 < len(rule) && rule[i+1] == '\'' {
+				i++
+			}
+		case ',':
+			if !quoted {
+				pairs = append(pairs, rule[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(pairs, rule[start:])
+}
+
+// unquoteMatchRuleValue removes the quoting from a match rule value.
+// Apostrophes start and end quoted sections, and outside of quotes a
+// backslash may be used to escape an apostrophe.
+func unquoteMatchRuleValue(value string) (string, error) {
+	unquoted := make([]byte, 0, len(value))
+	quoted := false
+	for i := 0; i < len(value); i++ {
+		switch {
+		case value[i] == '\'':
+			quoted = !quoted
+		case !quoted && value[i] == '\\' && i+1 < len(value) && value[i+1] == '\'':
+			unquoted = append(unquoted, '\'')
+			i++
+		default:
+			unquoted = append(unquoted, value[i])
+		}
+	}
+	if quoted {
+		return "", errors.New("Unterminated quote in match rule value")
+	}
+	return string(unquoted), nil
+}
@@ -44,3 +44,181 @@ func (s *S) TestMatchRuleMatch(c *C) {
 	mr.Arg0 = "com.example.Bar"
 	c.Check(mr.Match(msg), Equals, false)
 }
+
+func (s *S) TestParseMatchRule(c *C) {
+	mr, err := ParseMatchRule("type='signal',sender='org.freedesktop.DBus',path='/bar/foo',interface='org.freedesktop.DBus',member='Foo',arg0='x,y'")
+	c.Assert(err, IsNil)
+	c.Check(*mr, DeepEquals, MatchRule{
+		Type:      TypeSignal,
+		Sender:    "org.freedesktop.DBus",
+		Path:      "/bar/foo",
+		Interface: "org.freedesktop.DBus",
+		Member:    "Foo",
+		Arg0:      "x,y"})
+
+	// Quoting is optional, and apostrophes can be escaped.
+	mr, err = ParseMatchRule(`member=Foo,arg0='it'\''s'`)
+	c.Assert(err, IsNil)
+	c.Check(mr.Member, Equals, "Foo")
+	c.Check(mr.Arg0, Equals, "it's")
+
+	_, err = ParseMatchRule("type='bogus'")
+	c.Check(err, NotNil)
+	_, err = ParseMatchRule("unknown='key'")
+	c.Check(err, NotNil)
+	_, err = ParseMatchRule("member='Foo")
+	c.Check(err, NotNil)
+}
+
+func (s *S) TestMatchRuleToStringFull(c *C) {
+	mr := MatchRule{
+		Type:          TypeSignal,
+		PathNamespace: "/org/example",
+		Interface:     "org.freedesktop.DBus.Properties",
+		Destination:   ":1.42",
+		Arg0:          "org.example.Foo",
+		Args:          map[int]string{10: "ten", 2: "two"},
+		ArgPaths:      map[int]string{1: "/org/example/"},
+		Arg0Namespace: "org.example",
+		Eavesdrop:     true}
+	c.Check(mr.String(), Equals, "type='signal',path_namespace='/org/example',interface='org.freedesktop.DBus.Properties',destination=':1.42',arg0='org.example.Foo',arg2='two',arg10='ten',arg1path='/org/example/',arg0namespace='org.example',eavesdrop='true'")
+
+	// Apostrophes in values are escaped.
+	mr = MatchRule{Member: "Foo", Arg0: "it's"}
+	c.Check(mr.String(), Equals, `member='Foo',arg0='it'\''s'`)
+	parsed, err := ParseMatchRule(mr.String())
+	c.Assert(err, IsNil)
+	c.Check(parsed.Arg0, Equals, "it's")
+}
+
+func (s *S) TestMatchRuleMatchPathNamespace(c *C) {
+	mr := MatchRule{PathNamespace: "/org/example"}
+	c.Check(mr.Match(NewSignalMessage("/org/example", "com.example", "Foo")), Equals, true)
+	c.Check(mr.Match(NewSignalMessage("/org/example/child", "com.example", "Foo")), Equals, true)
+	c.Check(mr.Match(NewSignalMessage("/org/examples", "com.example", "Foo")), Equals, false)
+	c.Check(mr.Match(NewSignalMessage("/org", "com.example", "Foo")), Equals, false)
+
+	mr = MatchRule{PathNamespace: "/"}
+	c.Check(mr.Match(NewSignalMessage("/org/example", "com.example", "Foo")), Equals, true)
+}
+
+func (s *S) TestMatchRuleMatchDestination(c *C) {
+	msg := NewMethodCallMessage(":1.42", "/org/example", "com.example", "Foo")
+	mr := MatchRule{Destination: ":1.42"}
+	c.Check(mr.Match(msg), Equals, true)
+	mr.Destination = ":1.43"
+	c.Check(mr.Match(msg), Equals, false)
+}
+
+func (s *S) TestMatchRuleMatchArgs(c *C) {
+	msg := NewSignalMessage("/org/example", "com.example", "Foo")
+	c.Assert(msg.AppendArgs("zero", int32(1), "two", ObjectPath("/org/example/three")), IsNil)
+
+	mr := MatchRule{Args: map[int]string{0: "zero", 2: "two"}}
+	c.Check(mr.Match(msg), Equals, true)
+	mr.Args[2] = "other"
+	c.Check(mr.Match(msg), Equals, false)
+
+	// Non-string arguments never match.
+	mr = MatchRule{Args: map[int]string{1: "1"}}
+	c.Check(mr.Match(msg), Equals, false)
+	// Nor do missing arguments.
+	mr = MatchRule{Args: map[int]string{4: "four"}}
+	c.Check(mr.Match(msg), Equals, false)
+	// Arg0 takes precedence over Args[0].
+	mr = MatchRule{Arg0: "zero", Args: map[int]string{0: "other"}}
+	c.Check(mr.Match(msg), Equals, true)
+}
+
+func (s *S) TestMatchRuleMatchArgPaths(c *C) {
+	msg := NewSignalMessage("/org/example", "com.example", "Foo")
+	c.Assert(msg.AppendArgs("/aa/bb/", ObjectPath("/aa/bb/cc")), IsNil)
+
+	for _, t := range []struct {
+		index    int
+		expected string
+		match    bool
+	}{
+		{0, "/aa/bb/", true},
+		{0, "/", true},
+		{0, "/aa/", true},
+		{0, "/aa/bb/cc/", true},
+		{0, "/aa/bb/cc", true},
+		{0, "/aa/b", false},
+		{0, "/aa", false},
+		{1, "/aa/bb/cc", true},
+		{1, "/aa/bb/", true},
+		{1, "/aa/bb/cc/dd", false},
+		{1, "/aa/bb", false},
+	} {
+		mr := MatchRule{ArgPaths: map[int]string{t.index: t.expected}}
+		c.Check(mr.Match(msg), Equals, t.match, Commentf("arg%dpath='%s'", t.index, t.expected))
+	}
+}
+
+func (s *S) TestMatchRuleMatchArg0Namespace(c *C) {
+	mr := MatchRule{Arg0Namespace: "com.example.backend1"}
+	for _, t := range []struct {
+		arg0  string
+		match bool
+	}{
+		{"com.example.backend1", true},
+		{"com.example.backend1.foo", true},
+		{"com.example.backend1.foo.bar", true},
+		{"com.example.backend2", false},
+		{"com.example.backend10", false},
+		{"com.example", false},
+	} {
+		msg := NewSignalMessage("/org/example", "com.example", "Foo")
+		c.Assert(msg.AppendArgs(t.arg0), IsNil)
+		c.Check(mr.Match(msg), Equals, t.match, Commentf("arg0=%q", t.arg0))
+	}
+}
+
+func (s *S) TestParseMatchRuleFull(c *C) {
+	rule := "type='signal',path_namespace='/org/example',interface='org.freedesktop.DBus.Properties',destination=':1.42',arg0='org.example.Foo',arg2='two',arg63='last',arg1path='/org/example/',arg0namespace='org.example',eavesdrop='true'"
+	mr, err := ParseMatchRule(rule)
+	c.Assert(err, IsNil)
+	c.Check(*mr, DeepEquals, MatchRule{
+		Type:          TypeSignal,
+		PathNamespace: "/org/example",
+		Interface:     "org.freedesktop.DBus.Properties",
+		Destination:   ":1.42",
+		Arg0:          "org.example.Foo",
+		Args:          map[int]string{2: "two", 63: "last"},
+		ArgPaths:      map[int]string{1: "/org/example/"},
+		Arg0Namespace: "org.example",
+		Eavesdrop:     true})
+	c.Check(mr.String(), Equals, rule)
+
+	// An explicit empty arg0 still matches.
+	mr, err = ParseMatchRule("member='Foo',arg0=''")
+	c.Assert(err, IsNil)
+	c.Check(mr.Args, DeepEquals, map[int]string{0: ""})
+	c.Check(mr.String(), Equals, "member='Foo',arg0=''")
+	msg := NewSignalMessage("/org/example", "com.example", "Foo")
+	c.Assert(msg.AppendArgs(""), IsNil)
+	c.Check(mr.Match(msg), Equals, true)
+	msg = NewSignalMessage("/org/example", "com.example", "Foo")
+	c.Assert(msg.AppendArgs("x"), IsNil)
+	c.Check(mr.Match(msg), Equals, false)
+
+	mr, err = ParseMatchRule("eavesdrop='false'")
+	c.Assert(err, IsNil)
+	c.Check(mr.Eavesdrop, Equals, false)
+
+	for _, bad := range []string{
+		"arg64='x'",
+		"arg64path='x'",
+		"arg1namespace='x'",
+		"argx='x'",
+		"arg='x'",
+		"arg00='x'",
+		"arg01path='x'",
+		"eavesdrop='maybe'",
+		"path='/a',path_namespace='/a'",
+	} {
+		_, err = ParseMatchRule(bad)
+		c.Check(err, NotNil, Commentf("%s", bad))
+	}
+}
@@ -65,6 +65,7 @@ type Message struct {
 	Sender      string
 	sig         Signature
 	body        []byte
+	fds         []int
 }
 
 // Create a new message with Flags == 0 and Protocol == 1.
@@ -173,6 +174,7 @@ func (p *Message) setSerial(serial uint32) {
 //  - maps represent equivalent D-Bus dictionaries.
 //  - structures represent a structure comprising the public members.
 //  - the dbus.Variant type represents a variant.
+//  - the dbus.UnixFD type represents a Unix file descriptor.
 //
 // If an argument can not be serialised in the message, an error is
 // returned.  When multiple arguments are being appended, it is
@@ -180,11 +182,13 @@ func (p *Message) setSerial(serial uint32) {
 // error is generated.
 func (p *Message) AppendArgs(args ...interface{}) error {
 	enc := newEncoder(p.sig, p.body, p.order)
+	enc.fds = p.fds
 	if err := enc.Append(args...); err != nil {
 		return err
 	}
 	p.sig = enc.signature
 	p.body = enc.data.Bytes()
+	p.fds = enc.fds
 	return nil
 }
 
@@ -199,8 +203,12 @@ func (p *Message) AppendArgs(args ...interface{}) error {
 // value.  This may result in a less useful decoded version though
 // (e.g. an "ai" message argument would be decoded as []interface{}
 // instead of []int32).
+//
+// Variant arguments may also be decoded directly into a variable of
+// the type of the value they hold, rather than into a Variant.
 func (p *Message) Args(args ...interface{}) error {
 	dec := newDecoder(p.sig, p.body, p.order)
+	dec.fds = p.fds
 	return dec.Decode(args...)
 }
 
@@ -210,6 +218,7 @@ func (p *Message) Args(args ...interface{}) error {
 // to blank interface values for each message argument.
 func (p *Message) AllArgs() []interface{} {
 	dec := newDecoder(p.sig, p.body, p.order)
+	dec.fds = p.fds
 	args := make([]interface{}, 0)
 	for dec.HasMore() {
 		var arg interface{}
@@ -243,10 +252,7 @@ type headerField struct {
 
 func readMessage(r io.Reader) (*Message, error) {
 	header := make([]byte, 16)
-	if n, err := r.Read(header); n < len(header) {
-		if err == nil {
-			err = errors.New("Could not read message header")
-		}
+	if _, err := io.ReadFull(r, header); err != nil {
 		return nil, err
 	}
 
@@ -274,10 +280,7 @@ func readMessage(r io.Reader) (*Message, error) {
 	}
 	headerFields := make([]byte, 16+int(headerFieldsLength)+padding)
 	copy(headerFields[:16], header)
-	if n, err := r.Read(headerFields[16:]); n < len(headerFields)-16 {
-		if err == nil {
-			err = errors.New("Could not read message header fields")
-		}
+	if _, err := io.ReadFull(r, headerFields[16:]); err != nil {
 		return nil, err
 	}
 	dec = newDecoder("a(yv)", headerFields, msg.order)
@@ -286,6 +289,7 @@ func readMessage(r io.Reader) (*Message, error) {
 	if err := dec.Decode(&fields); err != nil {
 		return nil, err
 	}
+	var unixFDs uint32
 	for _, field := range fields {
 		switch field.Code {
 		case 1:
@@ -304,6 +308,8 @@ func readMessage(r io.Reader) (*Message, error) {
 			msg.Sender = field.Value.Value.(string)
 		case 8:
 			msg.sig = field.Value.Value.(Signature)
+		case 9:
+			unixFDs = field.Value.Value.(uint32)
 		}
 	}
 
@@ -314,6 +320,18 @@ func readMessage(r io.Reader) (*Message, error) {
 		}
 		return nil, err
 	}
+
+	// Collect any file descriptors passed with the message.
+	if unixFDs != 0 {
+		fdReader, ok := r.(fdSource)
+		if !ok {
+			return nil, errors.New("Received file descriptors on a connection that does not support them")
+		}
+		var err error
+		if msg.fds, err = fdReader.takeFDs(int(unixFDs)); err != nil {
+			return nil, err
+		}
+	}
 	return msg, nil
 }
 
@@ -344,6 +362,9 @@ func (p *Message) WriteTo(w io.Writer) (int64, error) {
 	if p.sig != "" {
 		fields = append(fields, headerField{8, Variant{p.sig}})
 	}
+	if len(p.fds) != 0 {
+		fields = append(fields, headerField{9, Variant{uint32(len(p.fds))}})
+	}
 
 	var orderTag byte
 	switch p.order {
@@ -0,0 +1,274 @@
+package dbus
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+const MONITORING_IFACE = "org.freedesktop.DBus.Monitoring"
+
+// Monitor delivers the messages observed by a connection that has
+// become a bus monitor.
+//
+// Messages are delivered on the channel C, which is closed when the
+// monitor is cancelled or the connection is closed or lost.  Messages
+// are queued rather than dropped if C is not read promptly, so reading
+// it does not hold up the connection.
+type Monitor struct {
+	conn  *Connection
+	rules []*MatchRule
+	// The serial of the BecomeMonitor call.  Messages are monitored
+	// once the bus has replied to it.
+	becomeSerial uint32
+	// Whether the monitor is active, and whether it uses
+	// eavesdropping match rules.  Covered by the connection's
+	// handlerMutex.
+	active    bool
+	eavesdrop bool
+
+	lock     sync.Mutex
+	pending  []*Message
+	notify   chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+
+	C chan *Message
+}
+
+// BecomeMonitor turns the connection into a monitor of the messages
+// on the bus that match any of the given rules, or of all messages if
+// no rules are given.  Method calls, method returns, errors and
+// signals are all delivered on the monitor's channel.
+//
+// The org.freedesktop.DBus.Monitoring interface is used if the bus
+// supports it, in which case the connection can no longer send
+// messages or receive messages of its own: its unique name is
+// released and it should be closed once monitoring is done.  Older
+// buses are asked to deliver messages through match rules with
+// eavesdrop='true' instead, which the bus may also refuse.
+//
+// A monitoring connection is not re-established if it is lost.
+func (p *Connection) BecomeMonitor(rules []*MatchRule) (*Monitor, error) {
+	if p.peerToPeer {
+		return nil, errors.New("Monitoring requires a message bus")
+	}
+	m := &Monitor{
+		conn:   p,
+		rules:  rules,
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+		C:      make(chan *Message)}
+
+	p.handlerMutex.Lock()
+	if p.monitor != nil {
+		p.handlerMutex.Unlock()
+		return nil, errors.New("Connection is already a monitor")
+	}
+	m.becomeSerial = p.nextSerial()
+	p.monitor = m
+	p.handlerMutex.Unlock()
+	go m.deliver()
+
+	ruleStrings := make([]string, len(rules))
+	for i, rule := range rules {
+		ruleStrings[i] = rule.String()
+	}
+	msg := NewMethodCallMessage(BUS_DAEMON_NAME, BUS_DAEMON_PATH, MONITORING_IFACE, "BecomeMonitor")
+	if err := msg.AppendArgs(ruleStrings, uint32(0)); err != nil {
+		m.abandon()
+		return nil, err
+	}
+	reply, err := p.sendWithReply(context.Background(), msg, m.becomeSerial)
+	if err == nil && reply.Type == TypeError {
+		err = reply.AsError()
+	}
+	if err == nil {
+		// The dispatch loop activated the monitor on seeing
+		// the reply.
+		return m, nil
+	}
+	if _, ok := err.(*Error); !ok {
+		m.abandon()
+		return nil, err
+	}
+
+	// Fall back to eavesdropping.
+	eavesdropRules := m.eavesdropRules()
+	p.handlerMutex.Lock()
+	m.rules = eavesdropRules
+	m.eavesdrop = true
+	m.active = true
+	p.handlerMutex.Unlock()
+	for i, rule := range eavesdropRules {
+		if err := p.busProxy.AddMatch(rule.String()); err != nil {
+			for _, added := range eavesdropRules[:i] {
+				p.busProxy.RemoveMatch(added.String())
+			}
+			m.abandon()
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Eavesdropping returns true if the monitor fell back to eavesdropping
+// match rules.
+func (m *Monitor) Eavesdropping() bool {
+	m.conn.handlerMutex.Lock()
+	defer m.conn.handlerMutex.Unlock()
+	return m.eavesdrop
+}
+
+// Cancel stops delivering messages and closes C.  If the monitor fell
+// back to eavesdropping, its match rules are removed and the
+// connection can be used as before.  Otherwise the bus keeps sending
+// the connection the messages it monitors, which are discarded until
+// the connection is closed.
+func (m *Monitor) Cancel() error {
+	p := m.conn
+	p.handlerMutex.Lock()
+	if p.monitor != m {
+		p.handlerMutex.Unlock()
+		return nil
+	}
+	eavesdrop := m.eavesdrop
+	rules := m.rules
+	if eavesdrop {
+		p.monitor = nil
+	}
+	p.handlerMutex.Unlock()
+	m.stop()
+
+	// There is no need to remove the match rules if the
+	// connection has gone away.
+	if !eavesdrop || !p.isConnOpen() {
+		return nil
+	}
+	var err error
+	for _, rule := range rules {
+		if removeErr := p.busProxy.RemoveMatch(rule.String()); err == nil {
+			err = removeErr
+		}
+	}
+	return err
+}
+
+// eavesdropRules returns copies of the monitor's rules with eavesdrop
+// set, or rules matching every message if there are none.
+func (m *Monitor) eavesdropRules() []*MatchRule {
+	if len(m.rules) == 0 {
+		return []*MatchRule{
+			{Type: TypeMethodCall, Eavesdrop: true},
+			{Type: TypeMethodReturn, Eavesdrop: true},
+			{Type: TypeError, Eavesdrop: true},
+			{Type: TypeSignal, Eavesdrop: true}}
+	}
+	rules := make([]*MatchRule, len(m.rules))
+	for i, rule := range m.rules {
+		copy := *rule
+		copy.Eavesdrop = true
+		rules[i] = &copy
+	}
+	return rules
+}
+
+// abandon stops a monitor that could not be set up.
+func (m *Monitor) abandon() {
+	m.conn.handlerMutex.Lock()
+	if m.conn.monitor == m {
+		m.conn.monitor = nil
+	}
+	m.conn.handlerMutex.Unlock()
+	m.stop()
+}
+
+// stop closes the monitor's channel, discarding any messages not yet
+// delivered.
+func (m *Monitor) stop() {
+	m.stopOnce.Do(func() {
+		close(m.done)
+	})
+}
+
+// monitorMessage passes a received message to the connection's
+// monitor, if any.  It returns true if the message should not be
+// dispatched any further.
+func (p *Connection) monitorMessage(msg *Message) bool {
+	p.handlerMutex.Lock()
+	m := p.monitor
+	if m == nil {
+		p.handlerMutex.Unlock()
+		return false
+	}
+	if !m.active {
+		// Messages are monitored once the bus accepts the
+		// BecomeMonitor call.
+		if msg.Type == TypeMethodReturn && msg.replySerial == m.becomeSerial {
+			m.active = true
+		}
+		p.handlerMutex.Unlock()
+		return false
+	}
+	eavesdrop := m.eavesdrop
+	rules := m.rules
+	p.handlerMutex.Unlock()
+
+	if !eavesdrop {
+		m.queue(msg)
+		return true
+	}
+	for _, rule := range rules {
+		if rule.Match(msg) {
+			m.queue(msg)
+			break
+		}
+	}
+	// Only messages addressed to this connection are dispatched
+	// as usual.
+	return !(msg.Dest == "" || msg.Dest == p.UniqueName)
+}
+
+// queue adds a message to be delivered on C, unless the monitor has
+// been stopped.
+func (m *Monitor) queue(msg *Message) {
+	select {
+	case <-m.done:
+		return
+	default:
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.pending = append(m.pending, msg)
+	select {
+	case m.notify <- struct{}{}:
+	default:
+	}
+}
+
+// deliver sends queued messages on C until the monitor is stopped.
+func (m *Monitor) deliver() {
+	defer close(m.C)
+	for {
+		select {
+		case <-m.notify:
+		case <-m.done:
+			return
+		}
+		for {
+			m.lock.Lock()
+			if len(m.pending) == 0 {
+				m.lock.Unlock()
+				break
+			}
+			msg := m.pending[0]
+			m.pending = m.pending[1:]
+			m.lock.Unlock()
+			select {
+			case m.C <- msg:
+			case <-m.done:
+				return
+			}
+		}
+	}
+}
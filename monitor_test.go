@@ -0,0 +1,157 @@
+package dbus
+
+import (
+	. "launchpad.net/gocheck"
+	"time"
+)
+
+// nextMonitored returns the next message observed by the monitor,
+// skipping messages to and from the bus itself.
+func nextMonitored(c *C, m *Monitor) *Message {
+	for {
+		select {
+		case msg, ok := <-m.C:
+			c.Assert(ok, Equals, true)
+			if msg.Sender != BUS_DAEMON_NAME && msg.Dest != BUS_DAEMON_NAME {
+				return msg
+			}
+		case <-time.After(5 * time.Second):
+			c.Fatal("Timed out waiting for a monitored message")
+		}
+	}
+}
+
+// exchangeMessages has bus1 call a method on bus2, and then emit a
+// signal.
+func exchangeMessages(c *C, bus1, bus2 *Connection) {
+	_, err := bus1.Object(bus2.UniqueName, "/").Call("org.freedesktop.DBus.Peer", "Ping")
+	c.Assert(err, IsNil)
+	signal := NewSignalMessage("/go/dbus/test", "com.example.GoDbus", "Hello")
+	c.Assert(signal.AppendArgs("world"), IsNil)
+	c.Assert(bus1.Send(signal), IsNil)
+}
+
+func (s *S) TestConnectionBecomeMonitor(c *C) {
+	bus1, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer bus1.Close()
+	bus2, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer bus2.Close()
+	conn, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	m, err := conn.BecomeMonitor(nil)
+	c.Assert(err, IsNil)
+	c.Check(m.Eavesdropping(), Equals, false)
+	// The monitor's unique name has been released.
+	_, err = bus1.busProxy.GetNameOwner(conn.UniqueName)
+	c.Check(err, NotNil)
+
+	exchangeMessages(c, bus1, bus2)
+	msg := nextMonitored(c, m)
+	c.Check(msg.Type, Equals, TypeMethodCall)
+	c.Check(msg.Sender, Equals, bus1.UniqueName)
+	c.Check(msg.Dest, Equals, bus2.UniqueName)
+	c.Check(msg.Member, Equals, "Ping")
+	msg = nextMonitored(c, m)
+	c.Check(msg.Type, Equals, TypeMethodReturn)
+	c.Check(msg.Sender, Equals, bus2.UniqueName)
+	c.Check(msg.Dest, Equals, bus1.UniqueName)
+	msg = nextMonitored(c, m)
+	c.Check(msg.Type, Equals, TypeSignal)
+	c.Check(msg.Member, Equals, "Hello")
+	var arg string
+	c.Check(msg.Args(&arg), IsNil)
+	c.Check(arg, Equals, "world")
+
+	// Cancelling the monitor closes the channel, though the
+	// connection remains a monitor.
+	c.Check(m.Cancel(), IsNil)
+	for _ = range m.C {
+	}
+	_, err = conn.BecomeMonitor(nil)
+	c.Check(err, NotNil)
+}
+
+func (s *S) TestConnectionBecomeMonitorRules(c *C) {
+	bus1, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer bus1.Close()
+	bus2, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer bus2.Close()
+	conn, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	m, err := conn.BecomeMonitor([]*MatchRule{
+		{Type: TypeSignal, Interface: "com.example.GoDbus"}})
+	c.Assert(err, IsNil)
+
+	// Only the signal is observed.
+	exchangeMessages(c, bus1, bus2)
+	msg := nextMonitored(c, m)
+	c.Check(msg.Type, Equals, TypeSignal)
+	c.Check(msg.Member, Equals, "Hello")
+
+	// The channel is closed along with the connection.
+	c.Check(conn.Close(), IsNil)
+	for _ = range m.C {
+	}
+}
+
+func (s *S) TestConnectionBecomeMonitorEavesdrop(c *C) {
+	s.broker.lock.Lock()
+	s.broker.noMonitoring = true
+	s.broker.lock.Unlock()
+
+	bus1, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer bus1.Close()
+	bus2, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer bus2.Close()
+	conn, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	m, err := conn.BecomeMonitor(nil)
+	c.Assert(err, IsNil)
+	c.Check(m.Eavesdropping(), Equals, true)
+
+	exchangeMessages(c, bus1, bus2)
+	msg := nextMonitored(c, m)
+	c.Check(msg.Type, Equals, TypeMethodCall)
+	c.Check(msg.Dest, Equals, bus2.UniqueName)
+	c.Check(msg.Member, Equals, "Ping")
+	msg = nextMonitored(c, m)
+	c.Check(msg.Type, Equals, TypeMethodReturn)
+	c.Check(msg.Dest, Equals, bus1.UniqueName)
+	msg = nextMonitored(c, m)
+	c.Check(msg.Type, Equals, TypeSignal)
+	c.Check(msg.Member, Equals, "Hello")
+
+	// The eavesdropping connection can still make calls.
+	_, err = conn.busProxy.GetNameOwner(bus1.UniqueName)
+	c.Check(err, IsNil)
+
+	// Cancelling the monitor removes its match rules, and leaves
+	// the connection usable.
+	c.Check(m.Cancel(), IsNil)
+	for _ = range m.C {
+	}
+	s.broker.lock.Lock()
+	rules := len(s.broker.clients[conn.UniqueName].matchRules)
+	s.broker.lock.Unlock()
+	c.Check(rules, Equals, 0)
+	_, err = conn.busProxy.GetNameOwner(bus1.UniqueName)
+	c.Check(err, IsNil)
+	c.Check(m.Cancel(), IsNil)
+
+	// The connection can become a monitor again.
+	m, err = conn.BecomeMonitor(nil)
+	c.Assert(err, IsNil)
+	c.Check(m.Cancel(), IsNil)
+}
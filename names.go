@@ -18,6 +18,8 @@ type nameInfo struct {
 type nameWatch struct {
 	info *nameInfo
 	cb   func(string)
+	// called if the connection is lost
+	disconnectCb func()
 }
 
 func newNameInfo(bus *Connection, busName string) (*nameInfo, error) {
@@ -39,7 +41,7 @@ func newNameInfo(bus *Connection, busName string) (*nameInfo, error) {
 			return
 		}
 		info.handleOwnerChange(newOwner, false)
-	})
+	}, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -66,6 +68,23 @@ func (self *nameInfo) checkCurrentOwner() {
 	}
 }
 
+// refreshOwner checks whether the name owner has changed, for
+// instance after reconnecting to the bus.
+func (self *nameInfo) refreshOwner() {
+	currentOwner, err := self.bus.busProxy.GetNameOwner(self.busName)
+	if err != nil {
+		if dbusErr, ok := err.(*Error); !ok || dbusErr.Name != "org.freedesktop.DBus.Error.NameHasNoOwner" {
+			log.Println("Unexpected error from GetNameOwner:", err)
+			return
+		}
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.currentOwner != currentOwner {
+		self.handleOwnerChange(currentOwner, true)
+	}
+}
+
 func (self *nameInfo) handleOwnerChange(newOwner string, lockAcquired bool) {
 	if !lockAcquired {
 		self.lock.Lock()
@@ -77,9 +96,12 @@ func (self *nameInfo) handleOwnerChange(newOwner string, lockAcquired bool) {
 	}
 }
 
-func (p *Connection) ensureNameWatch(busName string, cb func(string)) (watch *nameWatch, err error) {
+func (p *Connection) ensureNameWatch(busName string, cb func(string), disconnectCb func()) (watch *nameWatch, err error) {
 	p.nameInfoMutex.Lock()
 	defer p.nameInfoMutex.Unlock()
+	if !p.isConnOpen() {
+		return nil, p.Err()
+	}
 	info, ok := p.nameInfo[busName]
 	if !ok {
 		if info, err = newNameInfo(p, busName); err != nil {
@@ -90,7 +112,7 @@ func (p *Connection) ensureNameWatch(busName string, cb func(string)) (watch *na
 
 	info.lock.Lock()
 	defer info.lock.Unlock()
-	watch = &nameWatch{info, cb}
+	watch = &nameWatch{info, cb, disconnectCb}
 	info.watches = append(info.watches, watch)
 
 	// if the nameInfo already knows the current name owner, tell
@@ -136,10 +158,22 @@ type NameWatch struct {
 	cancelled  bool
 }
 
+// WatchName watches the owner of a bus name.
+//
+// The current owner, and subsequent changes of owner, are sent on the
+// watch's channel, with an empty string meaning the name has no
+// owner.  The channel is closed when the watch is cancelled or the
+// connection is lost.
 func (p *Connection) WatchName(busName string) (watch *NameWatch, err error) {
 	watch = &NameWatch{C: make(chan string, 1)}
+	// Hold the lock until the watch is set up, in case the
+	// connection is lost in the meantime.
+	watch.cancelLock.Lock()
+	defer watch.cancelLock.Unlock()
 	nameWatch, err := p.ensureNameWatch(busName, func(owner string) {
 		watch.C <- owner
+	}, func() {
+		watch.Cancel()
 	})
 	watch.watch = nameWatch
 	return
@@ -172,6 +206,7 @@ type BusName struct {
 	needsRelease bool
 
 	acquiredWatch *SignalWatch
+	acquiredDone  chan struct{}
 	lostWatch     *SignalWatch
 }
 
@@ -202,6 +237,11 @@ func (p *Connection) RequestName(busName string, flags NameFlags) *BusName {
 		Name:  busName,
 		Flags: flags,
 		C:     make(chan error, 1)}
+	// Remember the name so it can be requested again if we
+	// reconnect to the bus.
+	p.nameInfoMutex.Lock()
+	p.busNames[name] = true
+	p.nameInfoMutex.Unlock()
 	go name.request()
 	return name
 }
@@ -223,19 +263,23 @@ func (name *BusName) request() {
 			Arg0:      name.Name})
 		if err != nil {
 			log.Println("Could not set up NameLost signal watch")
-			name.Release()
+			name.release(false)
 			return
 		}
 		name.lostWatch = watch
-		go func() {
-			for _ = range name.lostWatch.C {
-				name.lock.Lock()
-				defer name.lock.Unlock()
-				name.C <- ErrNameLost
-				name.release(false)
-				break
+		go func(watch *SignalWatch) {
+			// The watch's channel is also closed if the
+			// connection is lost, which we report as the
+			// name being lost.
+			<-watch.C
+			name.lock.Lock()
+			defer name.lock.Unlock()
+			if name.cancelled {
+				return
 			}
-		}()
+			name.C <- ErrNameLost
+			name.release(false)
+		}(watch)
 
 		watch, err = name.bus.WatchSignal(&MatchRule{
 			Type:      TypeSignal,
@@ -245,21 +289,36 @@ func (name *BusName) request() {
 			Member:    "NameAcquired",
 			Arg0:      name.Name})
 		if err != nil {
-			log.Println("Could not set up NameLost signal watch")
-			name.Release()
+			log.Println("Could not set up NameAcquired signal watch")
+			name.release(false)
 			return
 		}
 		name.acquiredWatch = watch
-		go func() {
-			for _ = range name.acquiredWatch.C {
+		name.acquiredDone = make(chan struct{})
+		go func(watch *SignalWatch) {
+			defer close(name.acquiredDone)
+			for _ = range watch.C {
 				name.C <- nil
 			}
-		}()
+		}(watch)
+	}
 
-		// XXX: if we disconnect from the bus, we should
-		// report the name being lost.
+	name.requestName()
+}
+
+// rerequest requests the name again after reconnecting to the bus.
+func (name *BusName) rerequest() {
+	name.lock.Lock()
+	defer name.lock.Unlock()
+	if name.cancelled {
+		return
 	}
+	name.requestName()
+}
 
+// requestName asks the bus for the name, and reports the result.  It
+// must be called with the lock held.
+func (name *BusName) requestName() {
 	result, err := name.bus.busProxy.RequestName(name.Name, uint32(name.Flags))
 	if err != nil {
 		log.Println("Error requesting bus name", name.Name, "err =", err)
@@ -310,6 +369,9 @@ func (name *BusName) release(needsRelease bool) error {
 		if err := name.acquiredWatch.Cancel(); err != nil {
 			return err
 		}
+		// Make sure nothing more is sent before the
+		// channel is closed.
+		<-name.acquiredDone
 	}
 	if name.lostWatch != nil {
 		if err := name.lostWatch.Cancel(); err != nil {
@@ -328,5 +390,8 @@ func (name *BusName) release(needsRelease bool) error {
 	}
 	close(name.C)
 	name.cancelled = true
+	name.bus.nameInfoMutex.Lock()
+	delete(name.bus.busNames, name)
+	name.bus.nameInfoMutex.Unlock()
 	return nil
 }
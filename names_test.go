@@ -126,3 +126,18 @@ func (s *S) TestConnectionRequestNameAllowReplacement(c *C) {
 	// The first name owner loses possession.
 	c.Check(<-name1.C, Equals, ErrNameLost)
 }
+
+func (s *S) TestConnectionRequestNameLostOnDisconnect(c *C) {
+	bus, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer bus.Close()
+
+	name := bus.RequestName("com.example.GoDbus", 0)
+	c.Check(<-name.C, IsNil)
+
+	s.dropClient(c, bus.UniqueName)
+	c.Check(<-name.C, Equals, ErrNameLost)
+	_, ok := <-name.C
+	c.Check(ok, Equals, false)
+	c.Check(name.Release(), IsNil)
+}
@@ -0,0 +1,169 @@
+package dbus
+
+import (
+	"errors"
+	"log"
+	"reflect"
+	"sort"
+)
+
+const OBJECT_MANAGER_IFACE = "org.freedesktop.DBus.ObjectManager"
+
+// ExportObjectManager provides the org.freedesktop.DBus.ObjectManager
+// interface at path.
+//
+// The object manager reports the objects exported beneath path, with
+// their interfaces and property values, through GetManagedObjects.
+// When an interface is exported on one of those objects with Export
+// or ExportProperties, the InterfacesAdded signal is emitted, and
+// when it is removed with Unexport, InterfacesRemoved is emitted.
+// An object is managed by the closest object manager above it.
+func (p *Connection) ExportObjectManager(path ObjectPath) error {
+	p.handlerMutex.Lock()
+	defer p.handlerMutex.Unlock()
+	if p.objectManagers[path] {
+		return errors.New("Object manager already exported on " + string(path))
+	}
+	p.objectManagers[path] = true
+	return nil
+}
+
+// UnexportObjectManager removes an object manager previously exported
+// with ExportObjectManager.
+func (p *Connection) UnexportObjectManager(path ObjectPath) error {
+	p.handlerMutex.Lock()
+	defer p.handlerMutex.Unlock()
+	if !p.objectManagers[path] {
+		return errors.New("No object manager exported on " + string(path))
+	}
+	delete(p.objectManagers, path)
+	return nil
+}
+
+// objectManagerFor returns the path of the object manager managing
+// the object at path.  The caller must hold handlerMutex.
+func (p *Connection) objectManagerFor(path ObjectPath) (manager ObjectPath, ok bool) {
+	for other := range p.objectManagers {
+		if other != path && inPathNamespace(path, other) && len(other) >= len(manager) {
+			manager = other
+			ok = true
+		}
+	}
+	return
+}
+
+// objectManagerMethod returns the method handling a call to the
+// object manager interface, or nil if the method is unknown.  The
+// caller must hold handlerMutex.
+func (p *Connection) objectManagerMethod(msg *Message) *exportedMethod {
+	if msg.Member != "GetManagedObjects" {
+		return nil
+	}
+	path := msg.Path
+	fn := func() (map[ObjectPath]map[string]map[string]Variant, error) {
+		return p.managedObjects(path)
+	}
+	method, _ := newExportedMethod(msg.Member, reflect.ValueOf(fn))
+	return method
+}
+
+// managedObjects returns the objects managed by the object manager at
+// path, with the property values of each of their interfaces.
+func (p *Connection) managedObjects(manager ObjectPath) (map[ObjectPath]map[string]map[string]Variant, error) {
+	interfaces := make(map[ObjectPath][]string)
+	p.handlerMutex.Lock()
+	for path, object := range p.exportedObjects {
+		if other, ok := p.objectManagerFor(path); !ok || other != manager {
+			continue
+		}
+		for iface := range object.interfaces {
+			interfaces[path] = append(interfaces[path], iface)
+		}
+	}
+	p.handlerMutex.Unlock()
+
+	objects := make(map[ObjectPath]map[string]map[string]Variant)
+	for path, names := range interfaces {
+		handler := &propertiesHandler{p, path}
+		objects[path] = make(map[string]map[string]Variant)
+		for _, iface := range names {
+			properties, err := handler.GetAll(iface)
+			if err != nil {
+				// The interface may have been removed since.
+				if dbusErr, ok := err.(*Error); ok && dbusErr.Name == "org.freedesktop.DBus.Error.UnknownInterface" {
+					continue
+				}
+				return nil, err
+			}
+			objects[path][iface] = properties
+		}
+	}
+	return objects, nil
+}
+
+// interfaceAdded emits InterfacesAdded for a newly exported interface
+// if its object is managed.
+func (p *Connection) interfaceAdded(path ObjectPath, iface string) {
+	p.handlerMutex.Lock()
+	manager, ok := p.objectManagerFor(path)
+	p.handlerMutex.Unlock()
+	if !ok {
+		return
+	}
+	properties, err := (&propertiesHandler{p, path}).GetAll(iface)
+	if err != nil {
+		log.Println("Failed to get properties of", iface, "at", path, "err =", err)
+		properties = make(map[string]Variant)
+	}
+	signal := NewSignalMessage(manager, OBJECT_MANAGER_IFACE, "InterfacesAdded")
+	if err := signal.AppendArgs(path, map[string]map[string]Variant{iface: properties}); err != nil {
+		log.Println("Failed to encode InterfacesAdded signal:", err)
+		return
+	}
+	if err := p.Send(signal); err != nil {
+		log.Println("Failed to send InterfacesAdded signal:", err)
+	}
+}
+
+// propertiesAdded announces the values of properties registered for
+// an interface that has already been exported, if its object is
+// managed.
+func (p *Connection) propertiesAdded(path ObjectPath, iface string) {
+	p.handlerMutex.Lock()
+	_, ok := p.objectManagerFor(path)
+	var names []string
+	if object, exported := p.exportedObjects[path]; exported && object.interfaces[iface] != nil {
+		for name, property := range object.interfaces[iface].properties {
+			if property.emitsChanged == EmitsChangedTrue || property.emitsChanged == EmitsChangedInvalidates {
+				names = append(names, name)
+			}
+		}
+	}
+	p.handlerMutex.Unlock()
+	if !ok || len(names) == 0 {
+		return
+	}
+	sort.Strings(names)
+	if err := p.EmitPropertiesChanged(path, iface, names...); err != nil {
+		log.Println("Failed to emit PropertiesChanged for", iface, "at", path, "err =", err)
+	}
+}
+
+// interfaceRemoved emits InterfacesRemoved for an unexported interface
+// if its object is managed.
+func (p *Connection) interfaceRemoved(path ObjectPath, iface string) {
+	p.handlerMutex.Lock()
+	manager, ok := p.objectManagerFor(path)
+	p.handlerMutex.Unlock()
+	if !ok {
+		return
+	}
+	signal := NewSignalMessage(manager, OBJECT_MANAGER_IFACE, "InterfacesRemoved")
+	if err := signal.AppendArgs(path, []string{iface}); err != nil {
+		log.Println("Failed to encode InterfacesRemoved signal:", err)
+		return
+	}
+	if err := p.Send(signal); err != nil {
+		log.Println("Failed to send InterfacesRemoved signal:", err)
+	}
+}
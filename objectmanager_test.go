@@ -0,0 +1,152 @@
+package dbus
+
+import (
+	. "launchpad.net/gocheck"
+)
+
+func (s *S) TestConnectionExportObjectManager(c *C) {
+	server, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer server.Close()
+	client, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer client.Close()
+
+	c.Assert(server.ExportObjectManager("/go/dbus"), IsNil)
+	c.Check(server.ExportObjectManager("/go/dbus"), NotNil)
+
+	watch, err := client.WatchSignal(&MatchRule{
+		Type:      TypeSignal,
+		Sender:    server.UniqueName,
+		Path:      "/go/dbus",
+		Interface: OBJECT_MANAGER_IFACE})
+	c.Assert(err, IsNil)
+	defer watch.Cancel()
+	received := make(chan *Message, 10)
+	go func() {
+		for msg := range watch.C {
+			received <- msg
+		}
+	}()
+
+	// Exporting an interface announces it along with its properties.
+	t := &propertiesTest{name: "foo", count: 42}
+	c.Assert(t.export(server), IsNil)
+	msg := <-received
+	c.Check(msg.Member, Equals, "InterfacesAdded")
+	var path ObjectPath
+	var added map[string]map[string]Variant
+	c.Assert(msg.Args(&path, &added), IsNil)
+	c.Check(path, Equals, ObjectPath("/go/dbus/test"))
+	c.Check(added, DeepEquals, map[string]map[string]Variant{
+		"com.example.GoDbus": {
+			"Name":    Variant{"foo"},
+			"Count":   Variant{int32(42)},
+			"Version": Variant{"1.0"}}})
+
+	c.Assert(server.Export(&exportTest{}, "/go/dbus/test", "com.example.GoDbus.Other"), IsNil)
+	msg = <-received
+	c.Check(msg.Member, Equals, "InterfacesAdded")
+	c.Assert(msg.Args(&path, &added), IsNil)
+	c.Check(path, Equals, ObjectPath("/go/dbus/test"))
+	c.Check(added, DeepEquals, map[string]map[string]Variant{
+		"com.example.GoDbus.Other": {}})
+
+	// Objects outside the manager's subtree are not included.
+	c.Assert(server.Export(&exportTest{}, "/go/dbusother", "com.example.GoDbus"), IsNil)
+	c.Assert(server.Export(&exportTest{}, "/go/dbus", "com.example.GoDbus"), IsNil)
+
+	reply, err := client.Object(server.UniqueName, "/go/dbus").Call(OBJECT_MANAGER_IFACE, "GetManagedObjects")
+	c.Assert(err, IsNil)
+	var objects map[ObjectPath]map[string]map[string]Variant
+	c.Assert(reply.Args(&objects), IsNil)
+	c.Check(objects, DeepEquals, map[ObjectPath]map[string]map[string]Variant{
+		"/go/dbus/test": {
+			"com.example.GoDbus": {
+				"Name":    Variant{"foo"},
+				"Count":   Variant{int32(42)},
+				"Version": Variant{"1.0"}},
+			"com.example.GoDbus.Other": {}}})
+
+	// Unexporting an interface announces its removal.
+	c.Assert(server.Unexport("/go/dbus/test", "com.example.GoDbus.Other"), IsNil)
+	msg = <-received
+	c.Check(msg.Member, Equals, "InterfacesRemoved")
+	var removed []string
+	c.Assert(msg.Args(&path, &removed), IsNil)
+	c.Check(path, Equals, ObjectPath("/go/dbus/test"))
+	c.Check(removed, DeepEquals, []string{"com.example.GoDbus.Other"})
+
+	c.Assert(server.UnexportObjectManager("/go/dbus"), IsNil)
+	c.Check(server.UnexportObjectManager("/go/dbus"), NotNil)
+	_, err = client.Object(server.UniqueName, "/go/dbus").Call(OBJECT_MANAGER_IFACE, "GetManagedObjects")
+	c.Assert(err, FitsTypeOf, &Error{})
+	c.Check(err.(*Error).Name, Equals, "org.freedesktop.DBus.Error.UnknownInterface")
+}
+
+func (s *S) TestConnectionNestedObjectManagers(c *C) {
+	server, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer server.Close()
+	client, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer client.Close()
+
+	c.Assert(server.ExportObjectManager("/"), IsNil)
+	c.Assert(server.ExportObjectManager("/go/dbus"), IsNil)
+	c.Assert(server.Export(&exportTest{}, "/go/dbus", "com.example.GoDbus"), IsNil)
+	c.Assert(server.Export(&exportTest{}, "/go/dbus/test", "com.example.GoDbus"), IsNil)
+
+	// Each object is managed by the closest object manager.
+	for path, expected := range map[ObjectPath]ObjectPath{
+		"/":        "/go/dbus",
+		"/go/dbus": "/go/dbus/test",
+	} {
+		reply, err := client.Object(server.UniqueName, path).Call(OBJECT_MANAGER_IFACE, "GetManagedObjects")
+		c.Assert(err, IsNil)
+		var objects map[ObjectPath]map[string]map[string]Variant
+		c.Assert(reply.Args(&objects), IsNil)
+		c.Check(objects, DeepEquals, map[ObjectPath]map[string]map[string]Variant{
+			expected: {"com.example.GoDbus": {}}})
+	}
+}
+
+func (s *S) TestConnectionObjectManagerPropertiesAdded(c *C) {
+	server, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer server.Close()
+	client, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer client.Close()
+
+	c.Assert(server.ExportObjectManager("/go/dbus"), IsNil)
+	c.Assert(server.Export(&exportTest{}, "/go/dbus/test", "com.example.GoDbus"), IsNil)
+
+	watch, err := client.WatchSignal(&MatchRule{
+		Type:      TypeSignal,
+		Sender:    server.UniqueName,
+		Path:      "/go/dbus/test",
+		Interface: PROPERTIES_IFACE,
+		Member:    "PropertiesChanged"})
+	c.Assert(err, IsNil)
+	defer watch.Cancel()
+	received := make(chan *Message, 10)
+	go func() {
+		for msg := range watch.C {
+			received <- msg
+		}
+	}()
+
+	// Properties added to an interface that has already been
+	// announced are sent as changed.
+	t := &propertiesTest{name: "foo", count: 42}
+	c.Assert(t.export(server), IsNil)
+	msg := <-received
+	var iface string
+	var changed map[string]Variant
+	var invalidated []string
+	c.Assert(msg.Args(&iface, &changed, &invalidated), IsNil)
+	c.Check(iface, Equals, "com.example.GoDbus")
+	c.Check(changed, DeepEquals, map[string]Variant{"Name": Variant{"foo"}})
+	c.Check(invalidated, DeepEquals, []string{"Count", "Secret"})
+}
@@ -0,0 +1,504 @@
+package dbus
+
+import (
+	"log"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// ObjectEventType identifies the kind of change reported by an
+// ObjectEvent.
+type ObjectEventType int
+
+const (
+	ObjectInterfaceAdded ObjectEventType = iota
+	ObjectInterfaceRemoved
+	ObjectPropertiesChanged
+)
+
+// ObjectEvent describes a change to an interface of a managed object.
+//
+// For ObjectInterfaceAdded, Properties holds the initial property
+// values of the interface.  For ObjectPropertiesChanged, Properties
+// holds the new values of the changed properties, and Invalidated
+// lists the properties whose new value was not sent.
+type ObjectEvent struct {
+	Type        ObjectEventType
+	Path        ObjectPath
+	Interface   string
+	Properties  map[string]Variant
+	Invalidated []string
+}
+
+// ObjectManagerClient keeps a copy of the objects reported by a
+// remote org.freedesktop.DBus.ObjectManager, along with their
+// interfaces and property values.
+//
+// When the object manager is reached through a bus name, the client
+// follows its owner: the objects are fetched again when the owner
+// changes, and are removed while the name has no owner.
+//
+// Changes to the objects are delivered on the channel C, which is
+// closed when the client is cancelled or the connection is lost.
+// The copy is kept up to date whether or not the channel is read.
+type ObjectManagerClient struct {
+	manager *ObjectProxy
+
+	interfacesWatch *SignalWatch
+	propertiesWatch *SignalWatch
+	trackOwner      bool
+
+	lock      sync.Mutex
+	nameWatch *nameWatch
+	objects   map[ObjectPath]map[string]map[string]Variant
+	// Signals received while the objects are being loaded.
+	loaded bool
+	queued []*Message
+	// Whether the objects have been loaded once.  Events are only
+	// queued for later changes.
+	initialised bool
+	// Incremented when the owner changes, so that objects loaded
+	// from an earlier owner are discarded.
+	generation int
+	// The sender and serial of the GetManagedObjects reply.  Older
+	// signals from the same sender are already reflected in it.
+	owner       string
+	replySerial uint32
+	// Events waiting to be delivered on C.
+	pending []*ObjectEvent
+	notify  chan struct{}
+	done    chan struct{}
+
+	C chan *ObjectEvent
+}
+
+// NewObjectManagerClient calls GetManagedObjects on the object
+// manager, and watches for the signals reporting changes to the
+// objects it manages.
+func NewObjectManagerClient(manager *ObjectProxy) (*ObjectManagerClient, error) {
+	m := &ObjectManagerClient{
+		manager: manager,
+		objects: make(map[ObjectPath]map[string]map[string]Variant),
+		notify:  make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		C:       make(chan *ObjectEvent)}
+
+	// Watch for signals before asking for the current state, so
+	// no changes are missed.
+	var err error
+	m.interfacesWatch, err = manager.bus.WatchSignal(&MatchRule{
+		Type:      TypeSignal,
+		Sender:    manager.destination,
+		Path:      manager.path,
+		Interface: OBJECT_MANAGER_IFACE})
+	if err != nil {
+		return nil, err
+	}
+	m.propertiesWatch, err = manager.bus.WatchSignal(&MatchRule{
+		Type:          TypeSignal,
+		Sender:        manager.destination,
+		PathNamespace: manager.path,
+		Interface:     PROPERTIES_IFACE,
+		Member:        "PropertiesChanged"})
+	if err != nil {
+		m.interfacesWatch.Cancel()
+		return nil, err
+	}
+	go m.receiveSignals()
+	go m.deliverEvents()
+
+	// The result of the first load is returned.
+	loaded := make(chan error, 1)
+	var loadOnce sync.Once
+	load := func(owner string) {
+		m.lock.Lock()
+		m.generation++
+		generation := m.generation
+		m.loaded = false
+		m.lock.Unlock()
+		go func() {
+			err := m.load(owner, generation)
+			first := false
+			loadOnce.Do(func() {
+				first = true
+				loaded <- err
+			})
+			if !first && err != nil {
+				log.Println("Failed to get managed objects, err =", err)
+			}
+		}()
+	}
+
+	bus := manager.bus
+	m.trackOwner = manager.destination != "" && manager.destination != BUS_DAEMON_NAME && !bus.peerToPeer
+	if m.trackOwner {
+		// The callback is called with the current owner before
+		// ensureNameWatch returns.
+		nameWatch, err := bus.ensureNameWatch(manager.destination, load, nil)
+		if err != nil {
+			m.Cancel()
+			return nil, err
+		}
+		m.lock.Lock()
+		m.nameWatch = nameWatch
+		m.lock.Unlock()
+	} else {
+		load(manager.destination)
+	}
+
+	select {
+	case err = <-loaded:
+	case <-m.done:
+		// The connection was lost.
+		err = bus.Err()
+	}
+	if err != nil {
+		m.Cancel()
+		return nil, err
+	}
+	return m, nil
+}
+
+// load replaces the objects with those reported by the owner of the
+// object manager, or removes them if the name has no owner.
+func (m *ObjectManagerClient) load(owner string, generation int) error {
+	var objects map[ObjectPath]map[string]map[string]Variant
+	var sender string
+	var serial uint32
+	var err error
+	if owner != "" || !m.trackOwner {
+		var reply *Message
+		reply, err = m.manager.Call(OBJECT_MANAGER_IFACE, "GetManagedObjects")
+		if err == nil {
+			err = reply.Args(&objects)
+			sender = reply.Sender
+			serial = reply.serial
+		}
+	}
+	if err != nil || objects == nil {
+		objects = make(map[ObjectPath]map[string]map[string]Variant)
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if generation != m.generation {
+		return err
+	}
+	if m.initialised {
+		m.replaceObjects(objects)
+	} else {
+		m.objects = objects
+		m.initialised = true
+	}
+	m.owner = sender
+	m.replySerial = serial
+	m.loaded = true
+	for _, msg := range m.queued {
+		m.apply(msg)
+	}
+	m.queued = nil
+	return err
+}
+
+// replaceObjects replaces the objects with those loaded from a new
+// owner, queueing events for the differences.  The caller must hold
+// the lock.
+func (m *ObjectManagerClient) replaceObjects(objects map[ObjectPath]map[string]map[string]Variant) {
+	old := m.objects
+	m.objects = objects
+	for _, path := range sortedPaths(old) {
+		for _, iface := range sortedInterfaces(old[path]) {
+			if _, ok := objects[path][iface]; !ok {
+				m.queueEvent(&ObjectEvent{
+					Type:      ObjectInterfaceRemoved,
+					Path:      path,
+					Interface: iface})
+			}
+		}
+	}
+	for _, path := range sortedPaths(objects) {
+		for _, iface := range sortedInterfaces(objects[path]) {
+			properties := objects[path][iface]
+			if properties == nil {
+				properties = make(map[string]Variant)
+				objects[path][iface] = properties
+			}
+			oldProperties, ok := old[path][iface]
+			if !ok {
+				m.queueEvent(&ObjectEvent{
+					Type:       ObjectInterfaceAdded,
+					Path:       path,
+					Interface:  iface,
+					Properties: copyProperties(properties)})
+				continue
+			}
+			if reflect.DeepEqual(oldProperties, properties) {
+				continue
+			}
+			var invalidated []string
+			for name := range oldProperties {
+				if _, ok := properties[name]; !ok {
+					invalidated = append(invalidated, name)
+				}
+			}
+			sort.Strings(invalidated)
+			m.queueEvent(&ObjectEvent{
+				Type:        ObjectPropertiesChanged,
+				Path:        path,
+				Interface:   iface,
+				Properties:  copyProperties(properties),
+				Invalidated: invalidated})
+		}
+	}
+}
+
+// Cancel stops watching for changes to the managed objects.
+func (m *ObjectManagerClient) Cancel() error {
+	err := m.interfacesWatch.Cancel()
+	if err2 := m.propertiesWatch.Cancel(); err == nil {
+		err = err2
+	}
+	m.lock.Lock()
+	nameWatch := m.nameWatch
+	m.nameWatch = nil
+	m.lock.Unlock()
+	if nameWatch != nil {
+		if err2 := removeNameWatch(nameWatch); err == nil {
+			err = err2
+		}
+	}
+	return err
+}
+
+// Objects returns the managed objects, with the property values of
+// each of their interfaces.
+func (m *ObjectManagerClient) Objects() map[ObjectPath]map[string]map[string]Variant {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	objects := make(map[ObjectPath]map[string]map[string]Variant, len(m.objects))
+	for path, interfaces := range m.objects {
+		objects[path] = copyInterfaces(interfaces)
+	}
+	return objects
+}
+
+// Object returns the interfaces and property values of a managed
+// object, or nil if no such object is known.
+func (m *ObjectManagerClient) Object(path ObjectPath) map[string]map[string]Variant {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	interfaces, ok := m.objects[path]
+	if !ok {
+		return nil
+	}
+	return copyInterfaces(interfaces)
+}
+
+// Property returns the last known value of a property of a managed
+// object.  The value of an invalidated property is not known.
+func (m *ObjectManagerClient) Property(path ObjectPath, iface, name string) (value Variant, ok bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	value, ok = m.objects[path][iface][name]
+	return
+}
+
+func copyInterfaces(interfaces map[string]map[string]Variant) map[string]map[string]Variant {
+	result := make(map[string]map[string]Variant, len(interfaces))
+	for iface, properties := range interfaces {
+		result[iface] = copyProperties(properties)
+	}
+	return result
+}
+
+func copyProperties(properties map[string]Variant) map[string]Variant {
+	result := make(map[string]Variant, len(properties))
+	for name, value := range properties {
+		result[name] = value
+	}
+	return result
+}
+
+// receiveSignals reads the signal watches until both are closed.
+// Both watches are read by the same goroutine, so signals are
+// handled in the order they were received.
+func (m *ObjectManagerClient) receiveSignals() {
+	interfacesC := m.interfacesWatch.C
+	propertiesC := m.propertiesWatch.C
+	for interfacesC != nil || propertiesC != nil {
+		var msg *Message
+		var ok bool
+		select {
+		case msg, ok = <-interfacesC:
+			if !ok {
+				interfacesC = nil
+				continue
+			}
+		case msg, ok = <-propertiesC:
+			if !ok {
+				propertiesC = nil
+				continue
+			}
+		}
+		m.lock.Lock()
+		if m.loaded {
+			m.apply(msg)
+		} else {
+			m.queued = append(m.queued, msg)
+		}
+		m.lock.Unlock()
+	}
+	// Make sure the other watch is cancelled too, in case only
+	// one was closed.
+	go m.Cancel()
+	close(m.done)
+}
+
+// deliverEvents sends pending events on C.  Events are queued
+// separately so that a slow reader does not hold up the connection.
+func (m *ObjectManagerClient) deliverEvents() {
+	defer close(m.C)
+	for {
+		select {
+		case <-m.notify:
+		case <-m.done:
+			return
+		}
+		for {
+			m.lock.Lock()
+			if len(m.pending) == 0 {
+				m.lock.Unlock()
+				break
+			}
+			event := m.pending[0]
+			m.pending = m.pending[1:]
+			m.lock.Unlock()
+			select {
+			case m.C <- event:
+			case <-m.done:
+				return
+			}
+		}
+	}
+}
+
+// queueEvent adds an event to be delivered on C.  The caller must
+// hold the lock.
+func (m *ObjectManagerClient) queueEvent(event *ObjectEvent) {
+	m.pending = append(m.pending, event)
+	select {
+	case m.notify <- struct{}{}:
+	default:
+	}
+}
+
+// apply updates the objects with a received signal.  Signals sent
+// before the GetManagedObjects reply are ignored, as they are already
+// reflected in it, as are signals from other than the current owner.
+// The caller must hold the lock.
+func (m *ObjectManagerClient) apply(msg *Message) {
+	if m.trackOwner && msg.Sender != m.owner {
+		return
+	}
+	if msg.Sender == m.owner && msg.serial <= m.replySerial {
+		return
+	}
+	switch {
+	case msg.Interface == OBJECT_MANAGER_IFACE && msg.Member == "InterfacesAdded":
+		var path ObjectPath
+		var added map[string]map[string]Variant
+		if err := msg.Args(&path, &added); err != nil {
+			log.Println("Could not decode InterfacesAdded signal:", err)
+			return
+		}
+		interfaces, ok := m.objects[path]
+		if !ok {
+			interfaces = make(map[string]map[string]Variant)
+			m.objects[path] = interfaces
+		}
+		for _, iface := range sortedInterfaces(added) {
+			if added[iface] == nil {
+				added[iface] = make(map[string]Variant)
+			}
+			interfaces[iface] = added[iface]
+			m.queueEvent(&ObjectEvent{
+				Type:       ObjectInterfaceAdded,
+				Path:       path,
+				Interface:  iface,
+				Properties: copyProperties(added[iface])})
+		}
+	case msg.Interface == OBJECT_MANAGER_IFACE && msg.Member == "InterfacesRemoved":
+		var path ObjectPath
+		var removed []string
+		if err := msg.Args(&path, &removed); err != nil {
+			log.Println("Could not decode InterfacesRemoved signal:", err)
+			return
+		}
+		interfaces, ok := m.objects[path]
+		if !ok {
+			return
+		}
+		for _, iface := range removed {
+			if _, ok := interfaces[iface]; !ok {
+				continue
+			}
+			delete(interfaces, iface)
+			m.queueEvent(&ObjectEvent{
+				Type:      ObjectInterfaceRemoved,
+				Path:      path,
+				Interface: iface})
+		}
+		if len(interfaces) == 0 {
+			delete(m.objects, path)
+		}
+	case msg.Interface == PROPERTIES_IFACE && msg.Member == "PropertiesChanged":
+		var iface string
+		var changed map[string]Variant
+		var invalidated []string
+		if err := msg.Args(&iface, &changed, &invalidated); err != nil {
+			log.Println("Could not decode PropertiesChanged signal:", err)
+			return
+		}
+		// Only track the interfaces reported by the object
+		// manager.
+		properties, ok := m.objects[msg.Path][iface]
+		if !ok {
+			return
+		}
+		for name, value := range changed {
+			properties[name] = value
+		}
+		for _, name := range invalidated {
+			delete(properties, name)
+		}
+		m.queueEvent(&ObjectEvent{
+			Type:        ObjectPropertiesChanged,
+			Path:        msg.Path,
+			Interface:   iface,
+			Properties:  copyProperties(changed),
+			Invalidated: invalidated})
+	}
+}
+
+func sortedPaths(objects map[ObjectPath]map[string]map[string]Variant) []ObjectPath {
+	paths := make([]string, 0, len(objects))
+	for path := range objects {
+		paths = append(paths, string(path))
+	}
+	sort.Strings(paths)
+	result := make([]ObjectPath, len(paths))
+	for i, path := range paths {
+		result[i] = ObjectPath(path)
+	}
+	return result
+}
+
+func sortedInterfaces(interfaces map[string]map[string]Variant) []string {
+	names := make([]string, 0, len(interfaces))
+	for iface := range interfaces {
+		names = append(names, iface)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,199 @@
+package dbus
+
+import (
+	. "launchpad.net/gocheck"
+)
+
+func (s *S) TestObjectManagerClient(c *C) {
+	server, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer server.Close()
+	client, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer client.Close()
+
+	c.Assert(server.ExportObjectManager("/go/dbus"), IsNil)
+	t := &propertiesTest{name: "foo", count: 42}
+	c.Assert(t.export(server), IsNil)
+
+	m, err := NewObjectManagerClient(client.Object(server.UniqueName, "/go/dbus"))
+	c.Assert(err, IsNil)
+	c.Check(m.Objects(), DeepEquals, map[ObjectPath]map[string]map[string]Variant{
+		"/go/dbus/test": {
+			"com.example.GoDbus": {
+				"Name":    Variant{"foo"},
+				"Count":   Variant{int32(42)},
+				"Version": Variant{"1.0"}}}})
+
+	// New interfaces are added to the objects.
+	c.Assert(server.Export(&exportTest{}, "/go/dbus/other", "com.example.GoDbus.Other"), IsNil)
+	event := <-m.C
+	c.Check(*event, DeepEquals, ObjectEvent{
+		Type:       ObjectInterfaceAdded,
+		Path:       "/go/dbus/other",
+		Interface:  "com.example.GoDbus.Other",
+		Properties: map[string]Variant{}})
+	c.Check(m.Object("/go/dbus/other"), DeepEquals, map[string]map[string]Variant{
+		"com.example.GoDbus.Other": {}})
+
+	// Property changes are tracked.
+	c.Assert(t.SetName("bar"), IsNil)
+	c.Assert(server.EmitPropertiesChanged("/go/dbus/test", "com.example.GoDbus", "Name", "Count"), IsNil)
+	event = <-m.C
+	c.Check(*event, DeepEquals, ObjectEvent{
+		Type:        ObjectPropertiesChanged,
+		Path:        "/go/dbus/test",
+		Interface:   "com.example.GoDbus",
+		Properties:  map[string]Variant{"Name": Variant{"bar"}},
+		Invalidated: []string{"Count"}})
+	value, ok := m.Property("/go/dbus/test", "com.example.GoDbus", "Name")
+	c.Check(ok, Equals, true)
+	c.Check(value, DeepEquals, Variant{"bar"})
+	_, ok = m.Property("/go/dbus/test", "com.example.GoDbus", "Count")
+	c.Check(ok, Equals, false)
+
+	// Objects are removed along with their last interface.
+	c.Assert(server.Unexport("/go/dbus/other", "com.example.GoDbus.Other"), IsNil)
+	event = <-m.C
+	c.Check(*event, DeepEquals, ObjectEvent{
+		Type:      ObjectInterfaceRemoved,
+		Path:      "/go/dbus/other",
+		Interface: "com.example.GoDbus.Other"})
+	c.Check(m.Object("/go/dbus/other"), IsNil)
+
+	c.Check(m.Cancel(), IsNil)
+	_, ok = <-m.C
+	c.Check(ok, Equals, false)
+}
+
+func (s *S) TestObjectManagerClientSignalBeforeReply(c *C) {
+	server, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer server.Close()
+	client, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer client.Close()
+
+	// The object manager emits a signal after the client starts
+	// watching, but before replying to GetManagedObjects.
+	calls := make(chan *Message, 1)
+	server.RegisterObjectPath("/go/dbus", calls)
+	defer server.UnregisterObjectPath("/go/dbus")
+	go func() {
+		call := <-calls
+		signal := NewSignalMessage("/go/dbus", OBJECT_MANAGER_IFACE, "InterfacesRemoved")
+		if err := signal.AppendArgs(ObjectPath("/go/dbus/test"), []string{"com.example.GoDbus"}); err != nil {
+			c.Error(err)
+		}
+		if err := server.Send(signal); err != nil {
+			c.Error(err)
+		}
+		reply := NewMethodReturnMessage(call)
+		if err := reply.AppendArgs(map[ObjectPath]map[string]map[string]Variant{
+			"/go/dbus/test": {"com.example.GoDbus": {"Name": Variant{"foo"}}}}); err != nil {
+			c.Error(err)
+		}
+		if err := server.Send(reply); err != nil {
+			c.Error(err)
+		}
+	}()
+
+	m, err := NewObjectManagerClient(client.Object(server.UniqueName, "/go/dbus"))
+	c.Assert(err, IsNil)
+	defer m.Cancel()
+
+	// The signal is already reflected in the reply, so is not
+	// applied to it.
+	signal := NewSignalMessage("/go/dbus", OBJECT_MANAGER_IFACE, "InterfacesAdded")
+	c.Assert(signal.AppendArgs(ObjectPath("/go/dbus/other"), map[string]map[string]Variant{
+		"com.example.GoDbus.Other": {}}), IsNil)
+	c.Assert(server.Send(signal), IsNil)
+	event := <-m.C
+	c.Check(event.Type, Equals, ObjectInterfaceAdded)
+	c.Check(event.Path, Equals, ObjectPath("/go/dbus/other"))
+	c.Check(m.Objects(), DeepEquals, map[ObjectPath]map[string]map[string]Variant{
+		"/go/dbus/test":  {"com.example.GoDbus": {"Name": Variant{"foo"}}},
+		"/go/dbus/other": {"com.example.GoDbus.Other": {}}})
+}
+
+func (s *S) TestObjectManagerClientOwnerRestart(c *C) {
+	server1, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer server1.Close()
+	client, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer client.Close()
+
+	c.Assert(server1.ExportObjectManager("/go/dbus"), IsNil)
+	c.Assert((&propertiesTest{name: "one"}).export(server1), IsNil)
+	name1 := server1.RequestName("com.example.GoDbus", 0)
+	c.Assert(<-name1.C, IsNil)
+
+	m, err := NewObjectManagerClient(client.Object("com.example.GoDbus", "/go/dbus"))
+	c.Assert(err, IsNil)
+	defer m.Cancel()
+	// Make the reply serial from the first owner higher than
+	// those the restarted owner will use.
+	for i := 0; i < 10; i++ {
+		c.Assert(server1.EmitPropertiesChanged("/go/dbus/test", "com.example.GoDbus", "Count"), IsNil)
+		<-m.C
+	}
+
+	// The objects are removed when the owner exits.
+	c.Assert(server1.Close(), IsNil)
+	event := <-m.C
+	c.Check(*event, DeepEquals, ObjectEvent{
+		Type:      ObjectInterfaceRemoved,
+		Path:      "/go/dbus/test",
+		Interface: "com.example.GoDbus"})
+	c.Check(m.Objects(), DeepEquals, map[ObjectPath]map[string]map[string]Variant{})
+
+	// The objects of the new owner are loaded.
+	server2, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer server2.Close()
+	c.Assert(server2.ExportObjectManager("/go/dbus"), IsNil)
+	c.Assert((&propertiesTest{name: "two"}).export(server2), IsNil)
+	name2 := server2.RequestName("com.example.GoDbus", 0)
+	c.Assert(<-name2.C, IsNil)
+	event = <-m.C
+	c.Check(event.Type, Equals, ObjectInterfaceAdded)
+	c.Check(event.Path, Equals, ObjectPath("/go/dbus/test"))
+	c.Check(event.Properties["Name"], DeepEquals, Variant{"two"})
+
+	// Signals from the new owner are applied, though their
+	// serials are lower than those of the first owner.
+	c.Assert(server2.Export(&exportTest{}, "/go/dbus/other", "com.example.GoDbus.Other"), IsNil)
+	event = <-m.C
+	c.Check(event.Type, Equals, ObjectInterfaceAdded)
+	c.Check(event.Path, Equals, ObjectPath("/go/dbus/other"))
+	c.Check(m.Object("/go/dbus/other"), DeepEquals, map[string]map[string]Variant{
+		"com.example.GoDbus.Other": {}})
+}
+
+func (s *S) TestObjectManagerClientConnectionLost(c *C) {
+	server, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer server.Close()
+	client, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+
+	c.Assert(server.ExportObjectManager("/go/dbus"), IsNil)
+	m, err := NewObjectManagerClient(client.Object(server.UniqueName, "/go/dbus"))
+	c.Assert(err, IsNil)
+	c.Check(m.Objects(), DeepEquals, map[ObjectPath]map[string]map[string]Variant{})
+
+	// The event channel is closed when the connection is lost.
+	c.Assert(client.Close(), IsNil)
+	_, ok := <-m.C
+	c.Check(ok, Equals, false)
+}
+
+func (s *S) TestObjectManagerClientMissing(c *C) {
+	client, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer client.Close()
+
+	_, err = NewObjectManagerClient(client.Object(client.UniqueName, "/go/dbus"))
+	c.Check(err, NotNil)
+}
@@ -0,0 +1,276 @@
+package dbus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"time"
+)
+
+const (
+	// The pcap link type of D-Bus messages, as written by busctl
+	// capture.
+	pcapLinkTypeDBus = 231
+	// The largest message allowed by the D-Bus specification.
+	maxMessageSize = 128 * 1024 * 1024
+
+	pcapMagicMicroseconds = 0xa1b2c3d4
+	pcapMagicNanoseconds  = 0xa1b23c4d
+)
+
+type pcapFileHeader struct {
+	Magic        uint32
+	VersionMajor uint16
+	VersionMinor uint16
+	ThisZone     int32
+	SigFigs      uint32
+	SnapLen      uint32
+	LinkType     uint32
+}
+
+type pcapRecordHeader struct {
+	Seconds     uint32
+	Fraction    uint32
+	CapturedLen uint32
+	OriginalLen uint32
+}
+
+// PcapWriter writes messages to a capture in the pcap format, which
+// can be read by PcapReader and by tools such as Wireshark.
+type PcapWriter struct {
+	w io.Writer
+}
+
+// NewPcapWriter writes the header of a capture, and returns a
+// PcapWriter for writing its messages.
+func NewPcapWriter(w io.Writer) (*PcapWriter, error) {
+	header := pcapFileHeader{
+		Magic:        pcapMagicMicroseconds,
+		VersionMajor: 2,
+		VersionMinor: 4,
+		SnapLen:      maxMessageSize,
+		LinkType:     pcapLinkTypeDBus}
+	if err := binary.Write(w, binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+	return &PcapWriter{w}, nil
+}
+
+// WriteMessage adds a message to the capture, recording that it was
+// seen at the given time.  File descriptors passed with the message
+// are not captured.
+func (pw *PcapWriter) WriteMessage(msg *Message, t time.Time) error {
+	var buff bytes.Buffer
+	if _, err := msg.WriteTo(&buff); err != nil {
+		return err
+	}
+	header := pcapRecordHeader{
+		Seconds:     uint32(t.Unix()),
+		Fraction:    uint32(t.Nanosecond() / 1000),
+		CapturedLen: uint32(buff.Len()),
+		OriginalLen: uint32(buff.Len())}
+	if err := binary.Write(pw.w, binary.LittleEndian, &header); err != nil {
+		return err
+	}
+	_, err := buff.WriteTo(pw.w)
+	return err
+}
+
+// PcapReader reads messages from a capture in the pcap format, such
+// as those written by PcapWriter or busctl capture.
+type PcapReader struct {
+	r           io.Reader
+	order       binary.ByteOrder
+	nanoseconds bool
+}
+
+// NewPcapReader reads the header of a capture, and returns a
+// PcapReader for reading its messages.
+func NewPcapReader(r io.Reader) (*PcapReader, error) {
+	raw := make([]byte, binary.Size(pcapFileHeader{}))
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return nil, err
+	}
+	pr := &PcapReader{r: r}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch order.Uint32(raw) {
+		case pcapMagicNanoseconds:
+			pr.nanoseconds = true
+			fallthrough
+		case pcapMagicMicroseconds:
+			pr.order = order
+		}
+		if pr.order != nil {
+			break
+		}
+	}
+	if pr.order == nil {
+		return nil, errors.New("Not a pcap capture")
+	}
+	var header pcapFileHeader
+	if err := binary.Read(bytes.NewReader(raw), pr.order, &header); err != nil {
+		return nil, err
+	}
+	if header.LinkType != pcapLinkTypeDBus {
+		return nil, fmt.Errorf("Capture has link type %d rather than D-Bus", header.LinkType)
+	}
+	return pr, nil
+}
+
+// ReadMessage returns the next message in the capture and the time it
+// was seen.  It returns io.EOF at the end of the capture.
+//
+// File descriptors are not captured, so messages that were sent with
+// them are given invalid descriptors (-1) in their place.
+func (pr *PcapReader) ReadMessage() (*Message, time.Time, error) {
+	var header pcapRecordHeader
+	if err := binary.Read(pr.r, pr.order, &header); err != nil {
+		return nil, time.Time{}, err
+	}
+	var t time.Time
+	if pr.nanoseconds {
+		t = time.Unix(int64(header.Seconds), int64(header.Fraction))
+	} else {
+		t = time.Unix(int64(header.Seconds), int64(header.Fraction)*1000)
+	}
+	if header.CapturedLen > maxMessageSize {
+		return nil, t, errors.New("Captured message is too large")
+	}
+	if header.CapturedLen < header.OriginalLen {
+		return nil, t, errors.New("Captured message was truncated")
+	}
+	data := make([]byte, header.CapturedLen)
+	if _, err := io.ReadFull(pr.r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, t, err
+	}
+	record := &pcapRecordReader{bytes.NewReader(data)}
+	msg, err := readMessage(record)
+	if err != nil {
+		return nil, t, err
+	}
+	if record.Len() != 0 {
+		return nil, t, errors.New("Captured message has trailing data")
+	}
+	return msg, t, nil
+}
+
+// pcapRecordReader reads a message from a captured record, standing
+// in invalid descriptors for any file descriptors it was sent with.
+type pcapRecordReader struct {
+	*bytes.Reader
+}
+
+func (r *pcapRecordReader) takeFDs(count int) ([]int, error) {
+	fds := make([]int, count)
+	for i := range fds {
+		fds[i] = -1
+	}
+	return fds, nil
+}
+
+// readCapture reads the messages of a capture that match rule, or all
+// messages if rule is nil.
+func readCapture(r *PcapReader, rule *MatchRule) ([]*Message, error) {
+	var messages []*Message
+	for {
+		msg, _, err := r.ReadMessage()
+		if err == io.EOF {
+			return messages, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if rule == nil || rule.Match(msg) {
+			messages = append(messages, msg)
+		}
+	}
+}
+
+// Replay dispatches the messages of a capture that match rule, or all
+// messages if rule is nil, as if they had been received on the
+// connection.  Signals are delivered to matching watches, and method
+// calls to exported objects and registered handlers.  Method returns
+// and errors are skipped, as they could be mistaken for replies to the
+// connection's own calls.
+//
+// Replayed method calls are marked as expecting no reply, so that
+// replies are not sent to the recorded callers, whose names may now
+// belong to other clients.  Messages are dispatched in turn with those
+// received on the connection.
+//
+// The capture is read in full before any message is dispatched.
+func (p *Connection) Replay(r *PcapReader, rule *MatchRule) error {
+	messages, err := readCapture(r, rule)
+	if err != nil {
+		return err
+	}
+	for _, msg := range messages {
+		if msg.Type == TypeMethodReturn || msg.Type == TypeError {
+			continue
+		}
+		if msg.Type == TypeMethodCall {
+			msg.Flags |= FlagNoReplyExpected
+		}
+		p.dispatchLock.Lock()
+		err := p.dispatchMessage(msg)
+		p.dispatchLock.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReplayPeer is the fake peer of a peer-to-peer connection, which
+// sends the connection messages read from a capture.  It can stand in
+// for a real bus or service to reproduce recorded behaviour offline.
+//
+// Messages sent on the connection are discarded, so method calls made
+// on it only get a reply if one is replayed.
+type ReplayPeer struct {
+	conn net.Conn
+}
+
+// NewReplayPeer returns a ReplayPeer and the connection to it.
+func NewReplayPeer() (*ReplayPeer, *Connection) {
+	conn, peer := net.Pipe()
+	go io.Copy(ioutil.Discard, peer)
+	bus := newConnection(conn, false)
+	bus.peerToPeer = true
+	go bus.receiveLoop()
+	return &ReplayPeer{peer}, bus
+}
+
+// Replay sends the messages of a capture that match rule, or all
+// messages if rule is nil, to the connection.  Messages that were sent
+// with file descriptors are skipped.
+//
+// The capture is read in full before any message is sent.
+func (peer *ReplayPeer) Replay(r *PcapReader, rule *MatchRule) error {
+	messages, err := readCapture(r, rule)
+	if err != nil {
+		return err
+	}
+	for _, msg := range messages {
+		if len(msg.fds) != 0 {
+			continue
+		}
+		if _, err := msg.WriteTo(peer.conn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the peer's end of the connection, as if the peer had
+// gone away.
+func (peer *ReplayPeer) Close() error {
+	return peer.conn.Close()
+}
@@ -0,0 +1,197 @@
+package dbus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	. "launchpad.net/gocheck"
+	"time"
+)
+
+// testCapture returns a capture holding a method call, its reply and
+// a signal.
+func testCapture(c *C) []byte {
+	call := NewMethodCallMessage("com.example.GoDbus", "/go/dbus/test", "com.example.GoDbus", "Method")
+	call.Sender = ":1.1"
+	call.setSerial(1)
+	c.Assert(call.AppendArgs("hello", int32(42)), IsNil)
+	reply := NewMethodReturnMessage(call)
+	reply.Sender = ":1.2"
+	reply.setSerial(7)
+	c.Assert(reply.AppendArgs([]string{"a", "b"}), IsNil)
+	signal := NewSignalMessage("/go/dbus/test", "com.example.GoDbus", "TestSignal")
+	signal.Sender = ":1.2"
+	signal.setSerial(8)
+	c.Assert(signal.AppendArgs(uint32(3)), IsNil)
+
+	var buff bytes.Buffer
+	w, err := NewPcapWriter(&buff)
+	c.Assert(err, IsNil)
+	t := time.Unix(1500000000, 123456000)
+	for i, msg := range []*Message{call, reply, signal} {
+		c.Assert(w.WriteMessage(msg, t.Add(time.Duration(i)*time.Second)), IsNil)
+	}
+	return buff.Bytes()
+}
+
+func (s *S) TestPcap(c *C) {
+	data := testCapture(c)
+	c.Check(data[:4], DeepEquals, []byte{0xd4, 0xc3, 0xb2, 0xa1})
+	c.Check(binary.LittleEndian.Uint32(data[20:24]), Equals, uint32(pcapLinkTypeDBus))
+
+	r, err := NewPcapReader(bytes.NewReader(data))
+	c.Assert(err, IsNil)
+	msg, t, err := r.ReadMessage()
+	c.Assert(err, IsNil)
+	c.Check(t.Equal(time.Unix(1500000000, 123456000)), Equals, true)
+	c.Check(msg.Type, Equals, TypeMethodCall)
+	c.Check(msg.serial, Equals, uint32(1))
+	c.Check(msg.Sender, Equals, ":1.1")
+	c.Check(msg.Dest, Equals, "com.example.GoDbus")
+	c.Check(msg.Member, Equals, "Method")
+	var str string
+	var n int32
+	c.Check(msg.Args(&str, &n), IsNil)
+	c.Check(str, Equals, "hello")
+	c.Check(n, Equals, int32(42))
+
+	msg, t, err = r.ReadMessage()
+	c.Assert(err, IsNil)
+	c.Check(t.Equal(time.Unix(1500000001, 123456000)), Equals, true)
+	c.Check(msg.Type, Equals, TypeMethodReturn)
+	c.Check(msg.replySerial, Equals, uint32(1))
+	c.Check(msg.AllArgs(), DeepEquals, []interface{}{[]interface{}{"a", "b"}})
+
+	msg, _, err = r.ReadMessage()
+	c.Assert(err, IsNil)
+	c.Check(msg.Type, Equals, TypeSignal)
+	c.Check(msg.Member, Equals, "TestSignal")
+
+	_, _, err = r.ReadMessage()
+	c.Check(err, Equals, io.EOF)
+}
+
+func (s *S) TestPcapReaderBigEndian(c *C) {
+	signal := NewSignalMessage("/go/dbus/test", "com.example.GoDbus", "TestSignal")
+	signal.setSerial(1)
+	var msgData bytes.Buffer
+	_, err := signal.WriteTo(&msgData)
+	c.Assert(err, IsNil)
+
+	// A big endian capture with nanosecond timestamps.
+	var buff bytes.Buffer
+	binary.Write(&buff, binary.BigEndian, &pcapFileHeader{
+		Magic:        pcapMagicNanoseconds,
+		VersionMajor: 2,
+		VersionMinor: 4,
+		SnapLen:      maxMessageSize,
+		LinkType:     pcapLinkTypeDBus})
+	binary.Write(&buff, binary.BigEndian, &pcapRecordHeader{
+		Seconds:     1500000000,
+		Fraction:    42,
+		CapturedLen: uint32(msgData.Len()),
+		OriginalLen: uint32(msgData.Len())})
+	buff.Write(msgData.Bytes())
+
+	r, err := NewPcapReader(&buff)
+	c.Assert(err, IsNil)
+	msg, t, err := r.ReadMessage()
+	c.Assert(err, IsNil)
+	c.Check(t.Equal(time.Unix(1500000000, 42)), Equals, true)
+	c.Check(msg.Member, Equals, "TestSignal")
+}
+
+func (s *S) TestPcapReaderErrors(c *C) {
+	data := testCapture(c)
+
+	_, err := NewPcapReader(bytes.NewReader(data[:10]))
+	c.Check(err, NotNil)
+	_, err = NewPcapReader(bytes.NewReader(make([]byte, 24)))
+	c.Check(err, ErrorMatches, "Not a pcap capture")
+
+	other := append([]byte{}, data...)
+	binary.LittleEndian.PutUint32(other[20:24], 1)
+	_, err = NewPcapReader(bytes.NewReader(other))
+	c.Check(err, ErrorMatches, "Capture has link type 1 rather than D-Bus")
+
+	// A record cut short.
+	r, err := NewPcapReader(bytes.NewReader(data[:50]))
+	c.Assert(err, IsNil)
+	_, _, err = r.ReadMessage()
+	c.Check(err, Equals, io.ErrUnexpectedEOF)
+
+	// A record captured in part.
+	truncated := append([]byte{}, data...)
+	binary.LittleEndian.PutUint32(truncated[36:40], 1000)
+	r, err = NewPcapReader(bytes.NewReader(truncated))
+	c.Assert(err, IsNil)
+	_, _, err = r.ReadMessage()
+	c.Check(err, ErrorMatches, "Captured message was truncated")
+}
+
+func (s *S) TestConnectionReplay(c *C) {
+	bus, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer bus.Close()
+
+	watch, err := bus.WatchSignal(&MatchRule{
+		Type:      TypeSignal,
+		Interface: "com.example.GoDbus",
+		Member:    "TestSignal"})
+	c.Assert(err, IsNil)
+	defer watch.Cancel()
+
+	r, err := NewPcapReader(bytes.NewReader(testCapture(c)))
+	c.Assert(err, IsNil)
+	replayed := make(chan error, 1)
+	go func() {
+		replayed <- bus.Replay(r, &MatchRule{Type: TypeSignal})
+	}()
+	msg := <-watch.C
+	c.Check(msg.Member, Equals, "TestSignal")
+	c.Check(msg.Sender, Equals, ":1.2")
+	c.Check(<-replayed, IsNil)
+
+	// Replayed method calls expect no reply, so none is sent to
+	// the recorded caller.
+	calls := make(chan *Message, 1)
+	bus.RegisterObjectPath("/go/dbus/test", calls)
+	defer bus.UnregisterObjectPath("/go/dbus/test")
+	r, err = NewPcapReader(bytes.NewReader(testCapture(c)))
+	c.Assert(err, IsNil)
+	c.Check(bus.Replay(r, &MatchRule{Type: TypeMethodCall}), IsNil)
+	msg = <-calls
+	c.Check(msg.Member, Equals, "Method")
+	c.Check(msg.Flags&FlagNoReplyExpected, Equals, FlagNoReplyExpected)
+}
+
+func (s *S) TestReplayPeer(c *C) {
+	peer, bus := NewReplayPeer()
+	defer bus.Close()
+
+	calls := make(chan *Message, 1)
+	bus.RegisterObjectPath("/go/dbus/test", calls)
+	watch, err := bus.WatchSignal(&MatchRule{
+		Type:      TypeSignal,
+		Interface: "com.example.GoDbus",
+		Member:    "TestSignal"})
+	c.Assert(err, IsNil)
+	defer watch.Cancel()
+
+	r, err := NewPcapReader(bytes.NewReader(testCapture(c)))
+	c.Assert(err, IsNil)
+	replayed := make(chan error, 1)
+	go func() {
+		replayed <- peer.Replay(r, nil)
+	}()
+	msg := <-calls
+	c.Check(msg.Member, Equals, "Method")
+	msg = <-watch.C
+	c.Check(msg.Member, Equals, "TestSignal")
+	c.Check(<-replayed, IsNil)
+
+	// Closing the peer loses the connection.
+	c.Check(peer.Close(), IsNil)
+	<-bus.Done()
+	c.Check(bus.Err(), Equals, io.EOF)
+}
@@ -0,0 +1,339 @@
+package dbus
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+)
+
+const PROPERTIES_IFACE = "org.freedesktop.DBus.Properties"
+
+// EmitsChanged describes whether the PropertiesChanged signal is
+// emitted when a property changes, as given by the
+// org.freedesktop.DBus.Property.EmitsChangedSignal annotation.
+type EmitsChanged int
+
+const (
+	// The new value is included in the signal.
+	EmitsChangedTrue EmitsChanged = iota
+	// The property is listed as invalidated, without its value.
+	EmitsChangedInvalidates
+	// The property never changes.
+	EmitsChangedConst
+	// No signal is emitted.
+	EmitsChangedFalse
+)
+
+var emitsChangedString = map[EmitsChanged]string{
+	EmitsChangedTrue:        "true",
+	EmitsChangedInvalidates: "invalidates",
+	EmitsChangedConst:       "const",
+	EmitsChangedFalse:       "false",
+}
+
+// String returns the value of the annotation for this mode.
+func (e EmitsChanged) String() string { return emitsChangedString[e] }
+
+// Property describes a property of an exported interface.
+//
+// Get is a function of the form func() T or func() (T, error)
+// returning the current value, and Set is a function of the form
+// func(T) or func(T) error updating it.  The D-Bus type of the
+// property is the signature of T.  A property with only Get is
+// read-only and one with only Set is write-only.  As with exported
+// methods, a *Error returned by either function is sent as is, while
+// other errors are sent as org.freedesktop.DBus.Error.Failed.
+type Property struct {
+	Name         string
+	Get          interface{}
+	Set          interface{}
+	EmitsChanged EmitsChanged
+}
+
+type exportedProperty struct {
+	name         string
+	sig          Signature
+	valueType    reflect.Type
+	get          reflect.Value
+	set          reflect.Value
+	emitsChanged EmitsChanged
+}
+
+// newExportedProperty checks the getter and setter of a property.
+func newExportedProperty(property *Property) (*exportedProperty, error) {
+	exported := &exportedProperty{
+		name:         property.Name,
+		emitsChanged: property.EmitsChanged}
+	if property.Get != nil {
+		exported.get = reflect.ValueOf(property.Get)
+		t := exported.get.Type()
+		if t.Kind() != reflect.Func || t.NumIn() != 0 || t.NumOut() < 1 || t.NumOut() > 2 || (t.NumOut() == 2 && t.Out(1) != typeError) {
+			return nil, errors.New("Getter for property " + property.Name + " must be of the form func() T or func() (T, error)")
+		}
+		exported.valueType = t.Out(0)
+	}
+	if property.Set != nil {
+		exported.set = reflect.ValueOf(property.Set)
+		t := exported.set.Type()
+		if t.Kind() != reflect.Func || t.NumIn() != 1 || t.NumOut() > 1 || (t.NumOut() == 1 && t.Out(0) != typeError) {
+			return nil, errors.New("Setter for property " + property.Name + " must be of the form func(T) or func(T) error")
+		}
+		if exported.valueType != nil && exported.valueType != t.In(0) {
+			return nil, errors.New("Getter and setter for property " + property.Name + " have different types")
+		}
+		exported.valueType = t.In(0)
+	}
+	if exported.valueType == nil {
+		return nil, errors.New("Property " + property.Name + " has neither a getter nor a setter")
+	}
+	sig, err := SignatureOf(exported.valueType)
+	if err != nil {
+		return nil, fmt.Errorf("Property %s: %v", property.Name, err)
+	}
+	exported.sig = sig
+	return exported, nil
+}
+
+func (property *exportedProperty) readable() bool {
+	return property.get.IsValid()
+}
+
+func (property *exportedProperty) writable() bool {
+	return property.set.IsValid()
+}
+
+// callError converts an error returned by a getter or setter.
+func callError(results []reflect.Value) error {
+	if len(results) == 0 || results[len(results)-1].Type() != typeError {
+		return nil
+	}
+	errValue := results[len(results)-1]
+	if errValue.IsNil() {
+		return nil
+	}
+	switch err := errValue.Interface().(type) {
+	case *Error:
+		return err
+	case error:
+		return &Error{"org.freedesktop.DBus.Error.Failed", err.Error()}
+	}
+	return nil
+}
+
+// value calls the property's getter.
+func (property *exportedProperty) value() (interface{}, error) {
+	results := property.get.Call(nil)
+	if err := callError(results); err != nil {
+		return nil, err
+	}
+	return results[0].Interface(), nil
+}
+
+// ExportProperties registers properties of a D-Bus interface on the
+// given object path.  Calls to the org.freedesktop.DBus.Properties
+// interface of the object are answered from the registered
+// properties, and a successful Set emits PropertiesChanged according
+// to the property's EmitsChanged mode.
+//
+// The interface may also have methods exported with Export.
+func (p *Connection) ExportProperties(path ObjectPath, iface string, properties ...Property) error {
+	exportedProperties := make(map[string]*exportedProperty)
+	for i := range properties {
+		property, err := newExportedProperty(&properties[i])
+		if err != nil {
+			return err
+		}
+		if _, ok := exportedProperties[property.name]; ok {
+			return errors.New("Property " + property.name + " registered twice")
+		}
+		exportedProperties[property.name] = property
+	}
+
+	p.handlerMutex.Lock()
+	exported, added := p.exportedInterface(path, iface)
+	if exported.properties != nil {
+		p.handlerMutex.Unlock()
+		return errors.New("Properties of interface " + iface + " already exported on " + string(path))
+	}
+	exported.properties = exportedProperties
+	p.handlerMutex.Unlock()
+
+	if added {
+		p.interfaceAdded(path, iface)
+	} else {
+		p.propertiesAdded(path, iface)
+	}
+	return nil
+}
+
+// EmitPropertiesChanged emits the PropertiesChanged signal for the
+// named properties of an interface exported at path.  Properties
+// using EmitsChangedTrue are sent with their current value, those
+// using EmitsChangedInvalidates are listed as invalidated, and the
+// rest are skipped.  No signal is sent if no property needs to be
+// included.
+func (p *Connection) EmitPropertiesChanged(path ObjectPath, iface string, names ...string) error {
+	p.handlerMutex.Lock()
+	var properties []*exportedProperty
+	var err error
+	if object, ok := p.exportedObjects[path]; !ok {
+		err = errors.New("No objects exported on " + string(path))
+	} else if exported, ok := object.interfaces[iface]; !ok || exported.properties == nil {
+		err = errors.New("No properties of interface " + iface + " exported on " + string(path))
+	} else {
+		for _, name := range names {
+			property, ok := exported.properties[name]
+			if !ok {
+				err = errors.New("No property " + name + " in interface " + iface + " exported on " + string(path))
+				break
+			}
+			properties = append(properties, property)
+		}
+	}
+	p.handlerMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	changed := make(map[string]Variant)
+	invalidated := make([]string, 0)
+	for _, property := range properties {
+		switch property.emitsChanged {
+		case EmitsChangedTrue:
+			if !property.readable() {
+				invalidated = append(invalidated, property.name)
+				break
+			}
+			value, err := property.value()
+			if err != nil {
+				return err
+			}
+			changed[property.name] = Variant{value}
+		case EmitsChangedInvalidates:
+			invalidated = append(invalidated, property.name)
+		}
+	}
+	if len(changed) == 0 && len(invalidated) == 0 {
+		return nil
+	}
+	signal := NewSignalMessage(path, PROPERTIES_IFACE, "PropertiesChanged")
+	if err := signal.AppendArgs(iface, changed, invalidated); err != nil {
+		return err
+	}
+	return p.Send(signal)
+}
+
+// propertiesHandler answers calls to the org.freedesktop.DBus.Properties
+// interface of an exported object.
+type propertiesHandler struct {
+	conn *Connection
+	path ObjectPath
+}
+
+// propertiesMethod returns the method handling a call to the
+// properties interface, or nil if the method is unknown.  The
+// caller must hold handlerMutex.
+func (p *Connection) propertiesMethod(msg *Message) *exportedMethod {
+	handler := &propertiesHandler{p, msg.Path}
+	var fn interface{}
+	switch msg.Member {
+	case "Get":
+		fn = handler.Get
+	case "GetAll":
+		fn = handler.GetAll
+	case "Set":
+		// The value is decoded again with the property's type.
+		fn = func(iface, name string, value Variant) error {
+			return handler.Set(msg, iface, name)
+		}
+	default:
+		return nil
+	}
+	method, _ := newExportedMethod(msg.Member, reflect.ValueOf(fn))
+	return method
+}
+
+// lookup finds an exported property.
+func (handler *propertiesHandler) lookup(iface, name string) (*exportedProperty, error) {
+	handler.conn.handlerMutex.Lock()
+	defer handler.conn.handlerMutex.Unlock()
+	var exported *exportedInterface
+	if object, ok := handler.conn.exportedObjects[handler.path]; ok {
+		exported = object.interfaces[iface]
+	}
+	if exported == nil {
+		return nil, &Error{"org.freedesktop.DBus.Error.UnknownInterface", "No such interface '" + iface + "' at object path '" + string(handler.path) + "'"}
+	}
+	property, ok := exported.properties[name]
+	if !ok {
+		return nil, &Error{"org.freedesktop.DBus.Error.UnknownProperty", "No such property '" + name + "' in interface '" + iface + "' at object path '" + string(handler.path) + "'"}
+	}
+	return property, nil
+}
+
+func (handler *propertiesHandler) Get(iface, name string) (Variant, error) {
+	property, err := handler.lookup(iface, name)
+	if err != nil {
+		return Variant{}, err
+	}
+	if !property.readable() {
+		return Variant{}, &Error{"org.freedesktop.DBus.Error.AccessDenied", "Property '" + name + "' is not readable"}
+	}
+	value, err := property.value()
+	if err != nil {
+		return Variant{}, err
+	}
+	return Variant{value}, nil
+}
+
+func (handler *propertiesHandler) GetAll(iface string) (map[string]Variant, error) {
+	handler.conn.handlerMutex.Lock()
+	var exported *exportedInterface
+	if object, ok := handler.conn.exportedObjects[handler.path]; ok {
+		exported = object.interfaces[iface]
+	}
+	var properties []*exportedProperty
+	if exported != nil {
+		for _, property := range exported.properties {
+			if property.readable() {
+				properties = append(properties, property)
+			}
+		}
+	}
+	handler.conn.handlerMutex.Unlock()
+	if exported == nil {
+		return nil, &Error{"org.freedesktop.DBus.Error.UnknownInterface", "No such interface '" + iface + "' at object path '" + string(handler.path) + "'"}
+	}
+
+	values := make(map[string]Variant)
+	for _, property := range properties {
+		value, err := property.value()
+		if err != nil {
+			return nil, err
+		}
+		values[property.name] = Variant{value}
+	}
+	return values, nil
+}
+
+func (handler *propertiesHandler) Set(msg *Message, iface, name string) error {
+	property, err := handler.lookup(iface, name)
+	if err != nil {
+		return err
+	}
+	if !property.writable() {
+		return &Error{"org.freedesktop.DBus.Error.PropertyReadOnly", "Property '" + name + "' is read-only"}
+	}
+	value := reflect.New(property.valueType)
+	if err := msg.Args(&iface, &name, value.Interface()); err != nil {
+		return &Error{"org.freedesktop.DBus.Error.InvalidArgs", "Property '" + name + "' has type " + string(property.sig) + ": " + err.Error()}
+	}
+	if err := callError(property.set.Call([]reflect.Value{value.Elem()})); err != nil {
+		return err
+	}
+	if err := handler.conn.EmitPropertiesChanged(handler.path, iface, name); err != nil {
+		log.Println("Failed to emit PropertiesChanged for", name, "err =", err)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package dbus
+
+import (
+	"errors"
+	"sync"
+
+	. "launchpad.net/gocheck"
+)
+
+type propertiesTest struct {
+	lock  sync.Mutex
+	name  string
+	count int32
+}
+
+func (t *propertiesTest) Name() string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.name
+}
+
+func (t *propertiesTest) SetName(name string) error {
+	if name == "" {
+		return errors.New("empty name")
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.name = name
+	return nil
+}
+
+func (t *propertiesTest) Count() (int32, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.count, nil
+}
+
+func (t *propertiesTest) SetCount(count int32) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.count = count
+}
+
+func (t *propertiesTest) export(conn *Connection) error {
+	return conn.ExportProperties("/go/dbus/test", "com.example.GoDbus",
+		Property{Name: "Name", Get: t.Name, Set: t.SetName},
+		Property{Name: "Count", Get: t.Count, Set: t.SetCount, EmitsChanged: EmitsChangedInvalidates},
+		Property{Name: "Version", Get: func() string { return "1.0" }, EmitsChanged: EmitsChangedConst},
+		Property{Name: "Secret", Set: func(string) {}})
+}
+
+func (s *S) TestConnectionExportProperties(c *C) {
+	server, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer server.Close()
+	client, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer client.Close()
+
+	t := &propertiesTest{name: "foo", count: 42}
+	c.Assert(t.export(server), IsNil)
+	c.Check(t.export(server), NotNil)
+	// Methods can be exported on the same interface.
+	c.Assert(server.Export(&exportTest{}, "/go/dbus/test", "com.example.GoDbus"), IsNil)
+
+	props := &Properties{client.Object(server.UniqueName, "/go/dbus/test")}
+	value, err := props.Get("com.example.GoDbus", "Name")
+	c.Assert(err, IsNil)
+	c.Check(value, Equals, "foo")
+	value, err = props.Get("com.example.GoDbus", "Count")
+	c.Assert(err, IsNil)
+	c.Check(value, Equals, int32(42))
+
+	all, err := props.GetAll("com.example.GoDbus")
+	c.Assert(err, IsNil)
+	c.Check(all, DeepEquals, map[string]Variant{
+		"Name":    Variant{"foo"},
+		"Count":   Variant{int32(42)},
+		"Version": Variant{"1.0"}})
+
+	c.Check(props.Set("com.example.GoDbus", "Count", int32(7)), IsNil)
+	count, _ := t.Count()
+	c.Check(count, Equals, int32(7))
+
+	// Errors
+	for _, test := range []struct {
+		err       error
+		errorName string
+	}{
+		{props.Set("com.example.GoDbus", "Count", "wrong type"), "org.freedesktop.DBus.Error.InvalidArgs"},
+		{props.Set("com.example.GoDbus", "Version", "2.0"), "org.freedesktop.DBus.Error.PropertyReadOnly"},
+		{props.Set("com.example.GoDbus", "Name", ""), "org.freedesktop.DBus.Error.Failed"},
+		{props.Set("com.example.GoDbus", "Missing", ""), "org.freedesktop.DBus.Error.UnknownProperty"},
+		{props.Set("com.example.Missing", "Name", ""), "org.freedesktop.DBus.Error.UnknownInterface"},
+	} {
+		c.Assert(test.err, FitsTypeOf, &Error{})
+		c.Check(test.err.(*Error).Name, Equals, test.errorName)
+	}
+	_, err = props.Get("com.example.GoDbus", "Secret")
+	c.Assert(err, FitsTypeOf, &Error{})
+	c.Check(err.(*Error).Name, Equals, "org.freedesktop.DBus.Error.AccessDenied")
+
+	// Unexporting the interface removes the properties too.
+	c.Check(server.Unexport("/go/dbus/test", "com.example.GoDbus"), IsNil)
+	_, err = props.Get("com.example.GoDbus", "Name")
+	c.Assert(err, FitsTypeOf, &Error{})
+	c.Check(err.(*Error).Name, Equals, "org.freedesktop.DBus.Error.UnknownObject")
+}
+
+func (s *S) TestConnectionExportPropertiesInvalid(c *C) {
+	bus, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer bus.Close()
+
+	for _, property := range []Property{
+		{Name: "None"},
+		{Name: "BadGetter", Get: func(int32) string { return "" }},
+		{Name: "BadSetter", Set: func(string) string { return "" }},
+		{Name: "Mismatch", Get: func() string { return "" }, Set: func(int32) {}},
+		{Name: "Unrepresentable", Get: func() chan int { return nil }},
+	} {
+		c.Check(bus.ExportProperties("/go/dbus/test", "com.example.GoDbus", property), NotNil, Commentf("%s", property.Name))
+	}
+}
+
+func (s *S) TestConnectionPropertiesChanged(c *C) {
+	server, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer server.Close()
+	client, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer client.Close()
+
+	t := &propertiesTest{name: "foo", count: 42}
+	c.Assert(t.export(server), IsNil)
+
+	watch, err := client.WatchSignal(&MatchRule{
+		Type:      TypeSignal,
+		Sender:    server.UniqueName,
+		Path:      "/go/dbus/test",
+		Interface: PROPERTIES_IFACE,
+		Member:    "PropertiesChanged"})
+	c.Assert(err, IsNil)
+	defer watch.Cancel()
+	received := make(chan *Message, 10)
+	go func() {
+		for msg := range watch.C {
+			received <- msg
+		}
+	}()
+
+	checkSignal := func(changed map[string]Variant, invalidated []string) {
+		msg := <-received
+		var iface string
+		var gotChanged map[string]Variant
+		var gotInvalidated []string
+		c.Assert(msg.Args(&iface, &gotChanged, &gotInvalidated), IsNil)
+		c.Check(iface, Equals, "com.example.GoDbus")
+		c.Check(gotChanged, DeepEquals, changed)
+		c.Check(gotInvalidated, DeepEquals, invalidated)
+	}
+
+	// Setting a property remotely emits the signal.
+	props := &Properties{client.Object(server.UniqueName, "/go/dbus/test")}
+	c.Assert(props.Set("com.example.GoDbus", "Name", "bar"), IsNil)
+	checkSignal(map[string]Variant{"Name": Variant{"bar"}}, []string{})
+	c.Assert(props.Set("com.example.GoDbus", "Count", int32(1)), IsNil)
+	checkSignal(map[string]Variant{}, []string{"Count"})
+
+	// Constant properties are skipped.
+	t.SetName("baz")
+	c.Assert(server.EmitPropertiesChanged("/go/dbus/test", "com.example.GoDbus", "Name", "Count", "Version"), IsNil)
+	checkSignal(map[string]Variant{"Name": Variant{"baz"}}, []string{"Count"})
+
+	c.Check(server.EmitPropertiesChanged("/go/dbus/test", "com.example.GoDbus", "Missing"), NotNil)
+	c.Check(server.EmitPropertiesChanged("/go/dbus/test", "com.example.Missing", "Name"), NotNil)
+	c.Check(server.EmitPropertiesChanged("/go/dbus/other", "com.example.GoDbus", "Name"), NotNil)
+}
@@ -0,0 +1,390 @@
+package dbus
+
+import (
+	"errors"
+	"log"
+	"reflect"
+	"sync"
+)
+
+// PropertyCache holds the values of the properties of an interface of
+// a remote object, kept up to date by watching the PropertiesChanged
+// signal.
+//
+// When the destination is a well-known bus name, the cache follows
+// its owner: the values are fetched again when the owner changes, and
+// are cleared while the name has no owner.
+type PropertyCache struct {
+	props *Properties
+	iface string
+
+	rule        *MatchRule
+	signalWatch *signalWatch
+	nameWatch   *nameWatch
+	trackOwner  bool
+
+	lock    sync.Mutex
+	owner   string
+	values  map[string]Variant
+	watches map[string][]*PropertyWatch
+	// The serial of the reply holding the values, so older
+	// signals from the same owner can be ignored.
+	loadSerial uint32
+	// Work for the update goroutine, which may need to call the
+	// remote object.
+	work      []func()
+	closing   []*PropertyWatch
+	notify    chan struct{}
+	done      chan struct{}
+	cancelled bool
+}
+
+// PropertyWatch delivers the new values of a cached property on its
+// channel.  When the value is no longer known, for instance because
+// the bus name has no owner, a Variant with a nil Value is sent.
+//
+// The cache is updated by a single goroutine, so watches that are
+// not read hold up updates to the cache.
+type PropertyWatch struct {
+	cache     *PropertyCache
+	name      string
+	cancelled chan struct{}
+	C         chan Variant
+}
+
+// Cache returns a cache of the properties of the given interface.
+//
+// The initial values are fetched with GetAll before Cache returns.
+func (o *Properties) Cache(iface string) (*PropertyCache, error) {
+	cache := &PropertyCache{
+		props:   o,
+		iface:   iface,
+		values:  make(map[string]Variant),
+		watches: make(map[string][]*PropertyWatch),
+		notify:  make(chan struct{}, 1),
+		done:    make(chan struct{})}
+	cache.rule = &MatchRule{
+		Type:      TypeSignal,
+		Sender:    o.destination,
+		Path:      o.path,
+		Interface: PROPERTIES_IFACE,
+		Member:    "PropertiesChanged",
+		Arg0:      iface}
+	go cache.update()
+
+	// The result of the first reset is returned from Cache.
+	loaded := make(chan error, 1)
+	var loadOnce sync.Once
+	reset := func(owner string) {
+		err := cache.reset(owner)
+		first := false
+		loadOnce.Do(func() {
+			first = true
+			loaded <- err
+		})
+		if !first && err != nil {
+			log.Println("Failed to get properties of", iface, "err =", err)
+		}
+	}
+
+	bus := o.bus
+	cache.trackOwner = o.destination != "" && o.destination != BUS_DAEMON_NAME && !bus.peerToPeer
+	if cache.trackOwner {
+		// The callback is called with the current owner before
+		// ensureNameWatch returns.
+		nameWatch, err := bus.ensureNameWatch(o.destination, func(owner string) {
+			cache.rule.senderNameOwner = owner
+			cache.queue(func() {
+				reset(owner)
+			})
+		}, nil)
+		if err != nil {
+			close(cache.done)
+			return nil, err
+		}
+		cache.nameWatch = nameWatch
+	} else {
+		cache.queue(func() {
+			reset(o.destination)
+		})
+	}
+
+	signalWatch, err := bus.watchSignal(cache.rule, func(msg *Message) {
+		cache.queue(func() {
+			cache.handleChanged(msg)
+		})
+	}, func() {
+		go cache.Cancel()
+	})
+	if err != nil {
+		cache.Cancel()
+		return nil, err
+	}
+	cache.lock.Lock()
+	cache.signalWatch = signalWatch
+	cache.lock.Unlock()
+
+	select {
+	case err = <-loaded:
+	case <-cache.done:
+		// The connection was lost.
+		err = bus.Err()
+	}
+	if err != nil {
+		cache.Cancel()
+		return nil, err
+	}
+	return cache, nil
+}
+
+// Get returns the cached value of a property.
+func (cache *PropertyCache) Get(name string) (value interface{}, ok bool) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	variant, ok := cache.values[name]
+	return variant.Value, ok
+}
+
+// GetAll returns the cached values of all properties.
+func (cache *PropertyCache) GetAll() map[string]Variant {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	return copyProperties(cache.values)
+}
+
+// Set sets the value of a property on the remote object.  The cache
+// is updated when the object reports the change.
+func (cache *PropertyCache) Set(name string, value interface{}) error {
+	return cache.props.Set(cache.iface, name, value)
+}
+
+// WatchProperty watches for changes to the value of a property.
+func (cache *PropertyCache) WatchProperty(name string) (*PropertyWatch, error) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if cache.cancelled {
+		return nil, errors.New("Property cache cancelled")
+	}
+	watch := &PropertyWatch{
+		cache:     cache,
+		name:      name,
+		cancelled: make(chan struct{}),
+		C:         make(chan Variant)}
+	cache.watches[name] = append(cache.watches[name], watch)
+	return watch, nil
+}
+
+// Cancel stops watching the property.
+func (watch *PropertyWatch) Cancel() error {
+	cache := watch.cache
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	watches := cache.watches[watch.name]
+	for i, other := range watches {
+		if other == watch {
+			cache.watches[watch.name] = append(watches[:i], watches[i+1:]...)
+			close(watch.cancelled)
+			cache.closeWatch(watch)
+			return nil
+		}
+	}
+	return nil
+}
+
+// Cancel stops updating the cache, and closes the channels of its
+// property watches.
+func (cache *PropertyCache) Cancel() error {
+	cache.lock.Lock()
+	if cache.cancelled {
+		cache.lock.Unlock()
+		return nil
+	}
+	cache.cancelled = true
+	signalWatch := cache.signalWatch
+	for _, watches := range cache.watches {
+		for _, watch := range watches {
+			close(watch.cancelled)
+			cache.closeWatch(watch)
+		}
+	}
+	cache.watches = make(map[string][]*PropertyWatch)
+	cache.lock.Unlock()
+
+	var err error
+	if cache.nameWatch != nil {
+		err = removeNameWatch(cache.nameWatch)
+	}
+	if signalWatch != nil {
+		if err2 := signalWatch.cancel(); err == nil {
+			err = err2
+		}
+	}
+	close(cache.done)
+	return err
+}
+
+// closeWatch arranges for the channel of a watch to be closed by the
+// update goroutine, which may be sending on it.  The caller must hold
+// the lock.
+func (cache *PropertyCache) closeWatch(watch *PropertyWatch) {
+	cache.closing = append(cache.closing, watch)
+	cache.wake()
+}
+
+// queue adds work for the update goroutine.
+func (cache *PropertyCache) queue(fn func()) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	cache.work = append(cache.work, fn)
+	cache.wake()
+}
+
+// wake tells the update goroutine there is work to do.  The caller
+// must hold the lock.
+func (cache *PropertyCache) wake() {
+	select {
+	case cache.notify <- struct{}{}:
+	default:
+	}
+}
+
+// closeWatches closes the channels of cancelled watches.
+func (cache *PropertyCache) closeWatches() {
+	cache.lock.Lock()
+	closing := cache.closing
+	cache.closing = nil
+	cache.lock.Unlock()
+	for _, watch := range closing {
+		close(watch.C)
+	}
+}
+
+// update runs queued work until the cache is cancelled.
+func (cache *PropertyCache) update() {
+	defer cache.closeWatches()
+	for {
+		select {
+		case <-cache.notify:
+		case <-cache.done:
+			return
+		}
+		for {
+			cache.closeWatches()
+			cache.lock.Lock()
+			if len(cache.work) == 0 || cache.cancelled {
+				cache.lock.Unlock()
+				break
+			}
+			fn := cache.work[0]
+			cache.work = cache.work[1:]
+			cache.lock.Unlock()
+			fn()
+		}
+	}
+}
+
+// reset fetches all properties from a new owner.
+func (cache *PropertyCache) reset(owner string) error {
+	values := make(map[string]Variant)
+	var serial uint32
+	var err error
+	// Without an owner to follow (a peer-to-peer connection or the
+	// bus daemon) the properties are always fetched.
+	if owner != "" || !cache.trackOwner {
+		var reply *Message
+		reply, err = cache.props.Call(PROPERTIES_IFACE, "GetAll", cache.iface)
+		if err == nil {
+			err = reply.Args(&values)
+			serial = reply.serial
+		}
+	}
+
+	cache.lock.Lock()
+	old := cache.values
+	cache.owner = owner
+	cache.values = values
+	cache.loadSerial = serial
+	cache.lock.Unlock()
+
+	for name := range cache.watchedNames() {
+		if value, ok := values[name]; !reflect.DeepEqual(value, old[name]) || ok != hasKey(old, name) {
+			cache.send(name, value)
+		}
+	}
+	return err
+}
+
+func hasKey(values map[string]Variant, name string) bool {
+	_, ok := values[name]
+	return ok
+}
+
+// handleChanged updates the cache with a PropertiesChanged signal.
+func (cache *PropertyCache) handleChanged(msg *Message) {
+	var iface string
+	var changed map[string]Variant
+	var invalidated []string
+	if err := msg.Args(&iface, &changed, &invalidated); err != nil {
+		log.Println("Could not decode PropertiesChanged signal:", err)
+		return
+	}
+	cache.lock.Lock()
+	if (cache.trackOwner && msg.Sender != cache.owner) || msg.serial < cache.loadSerial {
+		// The signal predates the current values.
+		cache.lock.Unlock()
+		return
+	}
+	for name, value := range changed {
+		cache.values[name] = value
+	}
+	for _, name := range invalidated {
+		delete(cache.values, name)
+	}
+	cache.lock.Unlock()
+
+	// Invalidated properties are fetched again.
+	for _, name := range invalidated {
+		value, err := cache.props.Get(cache.iface, name)
+		if err != nil {
+			log.Println("Failed to get property", name, "err =", err)
+			continue
+		}
+		changed[name] = Variant{value}
+		cache.lock.Lock()
+		cache.values[name] = Variant{value}
+		cache.lock.Unlock()
+	}
+
+	watched := cache.watchedNames()
+	for name, value := range changed {
+		if watched[name] {
+			cache.send(name, value)
+		}
+	}
+}
+
+// watchedNames returns the names of the watched properties.
+func (cache *PropertyCache) watchedNames() map[string]bool {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	names := make(map[string]bool)
+	for name, watches := range cache.watches {
+		if len(watches) > 0 {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// send delivers a new value to the watches of a property.
+func (cache *PropertyCache) send(name string, value Variant) {
+	cache.lock.Lock()
+	watches := append([]*PropertyWatch(nil), cache.watches[name]...)
+	cache.lock.Unlock()
+	for _, watch := range watches {
+		select {
+		case watch.C <- value:
+		case <-watch.cancelled:
+		}
+	}
+}
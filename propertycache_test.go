@@ -0,0 +1,155 @@
+package dbus
+
+import (
+	. "launchpad.net/gocheck"
+	"path"
+)
+
+func (s *S) TestPropertyCache(c *C) {
+	server, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer server.Close()
+	client, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer client.Close()
+
+	t := &propertiesTest{name: "foo", count: 42}
+	c.Assert(t.export(server), IsNil)
+
+	props := &Properties{client.Object(server.UniqueName, "/go/dbus/test")}
+	cache, err := props.Cache("com.example.GoDbus")
+	c.Assert(err, IsNil)
+	defer cache.Cancel()
+	value, ok := cache.Get("Name")
+	c.Check(ok, Equals, true)
+	c.Check(value, Equals, "foo")
+	c.Check(cache.GetAll(), DeepEquals, map[string]Variant{
+		"Name":    Variant{"foo"},
+		"Count":   Variant{int32(42)},
+		"Version": Variant{"1.0"}})
+
+	nameWatch, err := cache.WatchProperty("Name")
+	c.Assert(err, IsNil)
+	countWatch, err := cache.WatchProperty("Count")
+	c.Assert(err, IsNil)
+
+	// Changes are delivered to the watches.
+	c.Assert(cache.Set("Name", "bar"), IsNil)
+	c.Check(<-nameWatch.C, DeepEquals, Variant{"bar"})
+	value, _ = cache.Get("Name")
+	c.Check(value, Equals, "bar")
+
+	// Invalidated properties are fetched again.
+	c.Assert(cache.Set("Count", int32(7)), IsNil)
+	c.Check(<-countWatch.C, DeepEquals, Variant{int32(7)})
+	value, _ = cache.Get("Count")
+	c.Check(value, Equals, int32(7))
+
+	c.Check(nameWatch.Cancel(), IsNil)
+	_, ok = <-nameWatch.C
+	c.Check(ok, Equals, false)
+
+	// Cancelling the cache closes the remaining watches.
+	c.Check(cache.Cancel(), IsNil)
+	_, ok = <-countWatch.C
+	c.Check(ok, Equals, false)
+	_, err = cache.WatchProperty("Name")
+	c.Check(err, NotNil)
+}
+
+func (s *S) TestPropertyCachePeerToPeer(c *C) {
+	server, err := Listen("unix:path=" + path.Join(c.MkDir(), "peer.sock"))
+	c.Assert(err, IsNil)
+	defer server.Close()
+
+	accepted := make(chan *Connection, 1)
+	go func() {
+		conn, err := server.Accept()
+		if err != nil {
+			c.Error(err)
+		}
+		accepted <- conn
+	}()
+	client, err := Dial(server.Address())
+	c.Assert(err, IsNil)
+	defer client.Close()
+	peer := <-accepted
+	c.Assert(peer, NotNil)
+	defer peer.Close()
+
+	t := &propertiesTest{name: "foo", count: 42}
+	c.Assert(t.export(peer), IsNil)
+
+	// There is no owner to follow, so the properties are fetched
+	// from the peer straight away.
+	props := &Properties{client.Object("", "/go/dbus/test")}
+	cache, err := props.Cache("com.example.GoDbus")
+	c.Assert(err, IsNil)
+	defer cache.Cancel()
+	value, ok := cache.Get("Name")
+	c.Check(ok, Equals, true)
+	c.Check(value, Equals, "foo")
+
+	nameWatch, err := cache.WatchProperty("Name")
+	c.Assert(err, IsNil)
+	c.Assert(cache.Set("Name", "bar"), IsNil)
+	c.Check(<-nameWatch.C, DeepEquals, Variant{"bar"})
+}
+
+func (s *S) TestPropertyCacheOwnerChange(c *C) {
+	server1, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer server1.Close()
+	server2, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer server2.Close()
+	client, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer client.Close()
+
+	c.Assert((&propertiesTest{name: "one"}).export(server1), IsNil)
+	c.Assert((&propertiesTest{name: "two"}).export(server2), IsNil)
+	name1 := server1.RequestName("com.example.GoDbus", NameFlagAllowReplacement|NameFlagDoNotQueue)
+	c.Assert(<-name1.C, IsNil)
+
+	props := &Properties{client.Object("com.example.GoDbus", "/go/dbus/test")}
+	cache, err := props.Cache("com.example.GoDbus")
+	c.Assert(err, IsNil)
+	defer cache.Cancel()
+	value, _ := cache.Get("Name")
+	c.Check(value, Equals, "one")
+	watch, err := cache.WatchProperty("Name")
+	c.Assert(err, IsNil)
+
+	// Signals are followed through the well-known name.
+	c.Assert(cache.Set("Name", "uno"), IsNil)
+	c.Check(<-watch.C, DeepEquals, Variant{"uno"})
+
+	// The values are fetched from the new owner.
+	name2 := server2.RequestName("com.example.GoDbus", NameFlagReplaceExisting)
+	c.Assert(<-name2.C, IsNil)
+	c.Check(<-watch.C, DeepEquals, Variant{"two"})
+	value, _ = cache.Get("Name")
+	c.Check(value, Equals, "two")
+
+	// The values are cleared when the name has no owner.
+	c.Check(<-name1.C, Equals, ErrNameLost)
+	c.Assert(name2.Release(), IsNil)
+	c.Check(<-watch.C, DeepEquals, Variant{})
+	c.Check(cache.GetAll(), DeepEquals, map[string]Variant{})
+}
+
+func (s *S) TestPropertyCacheUnknownInterface(c *C) {
+	server, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer server.Close()
+	client, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer client.Close()
+
+	c.Assert((&propertiesTest{}).export(server), IsNil)
+	props := &Properties{client.Object(server.UniqueName, "/go/dbus/test")}
+	_, err = props.Cache("com.example.Missing")
+	c.Assert(err, FitsTypeOf, &Error{})
+	c.Check(err.(*Error).Name, Equals, "org.freedesktop.DBus.Error.UnknownInterface")
+}
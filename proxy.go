@@ -15,6 +15,15 @@ func (o *Introspectable) Introspect() (data string, err error) {
 	return
 }
 
+// IntrospectNode returns the parsed introspection data of the object.
+func (o *Introspectable) IntrospectNode() (*NodeInfo, error) {
+	data, err := o.Introspect()
+	if err != nil {
+		return nil, err
+	}
+	return ParseIntrospection([]byte(data))
+}
+
 type Properties struct {
 	*ObjectProxy
 }
@@ -0,0 +1,205 @@
+package dbus
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// Delays between attempts to re-establish a lost connection.
+const (
+	minReconnectDelay = 100 * time.Millisecond
+	maxReconnectDelay = 30 * time.Second
+)
+
+// errDisconnected is returned for method calls made while the
+// connection is being re-established.
+var errDisconnected = &Error{"org.freedesktop.DBus.Error.Disconnected", "Connection to the message bus was lost"}
+
+// SetAutoReconnect enables or disables automatic reconnection to the
+// message bus.
+//
+// When enabled and the connection is lost, it is re-established using
+// the original address, retrying with increasing delays until it
+// succeeds or Close is called.  Signal and name watches, and the bus
+// names requested through RequestName, are restored on the new
+// connection.  Method calls waiting for a reply when the connection
+// is lost fail with org.freedesktop.DBus.Error.Disconnected.
+//
+// Automatic reconnection is not supported for peer-to-peer
+// connections.
+func (p *Connection) SetAutoReconnect(enabled bool) {
+	p.connOpenLock.Lock()
+	defer p.connOpenLock.Unlock()
+	p.autoReconnect = enabled
+}
+
+// reconnect re-establishes a lost connection if automatic
+// reconnection is enabled.  It returns false if the connection should
+// be shut down instead.
+func (p *Connection) reconnect() bool {
+	// A monitor can not be restored on a new connection.
+	p.handlerMutex.Lock()
+	monitoring := p.monitor != nil
+	p.handlerMutex.Unlock()
+
+	p.connOpenLock.Lock()
+	enabled := p.autoReconnect && p.connOpen && !p.peerToPeer && !monitoring
+	p.reconnecting = enabled
+	p.connOpenLock.Unlock()
+	if !enabled {
+		return false
+	}
+	p.failPendingReplies()
+
+	delay := minReconnectDelay
+	for {
+		select {
+		case <-p.closing:
+			return false
+		case <-time.After(delay):
+		}
+		err := p.redial()
+		if err == nil {
+			break
+		}
+		if err == ErrConnectionClosed {
+			return false
+		}
+		log.Println("Failed to reconnect to bus:", err)
+		if delay *= 2; delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+
+	go p.restore()
+	return true
+}
+
+// failPendingReplies answers all method calls waiting for a reply with
+// an error, since the replies will never arrive.
+func (p *Connection) failPendingReplies() {
+	p.handlerMutex.Lock()
+	replies := p.methodCallReplies
+	p.methodCallReplies = make(map[uint32]chan<- *Message)
+	p.handlerMutex.Unlock()
+
+	for serial, replyChan := range replies {
+		reply := newMessage()
+		reply.Type = TypeError
+		reply.replySerial = serial
+		reply.ErrorName = errDisconnected.Name
+		if err := reply.AppendArgs(errDisconnected.Message); err != nil {
+			panic(err)
+		}
+		replyChan <- reply
+	}
+}
+
+// redial connects to the message bus again and calls Hello, before
+// replacing the lost socket with the new one.
+func (p *Connection) redial() error {
+	trans, err := newTransport(p.address)
+	if err != nil {
+		return err
+	}
+	conn, err := trans.Dial()
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(authTimeout))
+	unixFDs, err := authenticate(conn, p.authenticators)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	// Call Hello before the new socket is made available, so
+	// nothing else is sent first.  The reply is the first message
+	// the bus sends us.
+	reader := newMessageReader(conn)
+	hello := NewMethodCallMessage(BUS_DAEMON_NAME, BUS_DAEMON_PATH, BUS_DAEMON_IFACE, "Hello")
+	hello.setSerial(p.nextSerial())
+	if err := writeMessage(conn, hello); err != nil {
+		conn.Close()
+		return err
+	}
+	reply, err := readMessage(reader)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if reply.replySerial != hello.serial {
+		conn.Close()
+		return errors.New("Unexpected reply to Hello")
+	}
+	if reply.Type == TypeError {
+		conn.Close()
+		return reply.AsError()
+	}
+	var uniqueName string
+	if err := reply.Args(&uniqueName); err != nil {
+		conn.Close()
+		return err
+	}
+	conn.SetDeadline(time.Time{})
+
+	p.writeLock.Lock()
+	defer p.writeLock.Unlock()
+	p.connOpenLock.Lock()
+	defer p.connOpenLock.Unlock()
+	if !p.connOpen {
+		conn.Close()
+		return ErrConnectionClosed
+	}
+	p.conn.Close()
+	p.conn = conn
+	p.reader = reader
+	p.unixFDs = unixFDs
+	p.UniqueName = uniqueName
+	p.reconnecting = false
+	return nil
+}
+
+// restore re-establishes the state held by the message bus for this
+// connection after reconnecting.
+func (p *Connection) restore() {
+	// Add the match rules for our signal watches.
+	var rules []string
+	p.handlerMutex.Lock()
+	for _, byInterface := range p.signalMatchRules {
+		for _, byMember := range byInterface {
+			for _, watches := range byMember {
+				for _, watch := range watches {
+					rules = append(rules, watch.rule.String())
+				}
+			}
+		}
+	}
+	p.handlerMutex.Unlock()
+	for _, rule := range rules {
+		if err := p.busProxy.AddMatch(rule); err != nil {
+			log.Println("Failed to restore match rule", rule, "err =", err)
+		}
+	}
+
+	// Name owners may have changed while we were disconnected.
+	var infos []*nameInfo
+	var names []*BusName
+	p.nameInfoMutex.Lock()
+	for _, info := range p.nameInfo {
+		infos = append(infos, info)
+	}
+	for name := range p.busNames {
+		names = append(names, name)
+	}
+	p.nameInfoMutex.Unlock()
+	for _, info := range infos {
+		info.refreshOwner()
+	}
+
+	// Request the names we owned or were queued for again.
+	for _, name := range names {
+		name.rerequest()
+	}
+}
@@ -0,0 +1,103 @@
+package dbus
+
+import (
+	. "launchpad.net/gocheck"
+	"path"
+	"time"
+)
+
+func (s *S) TestConnectionReconnect(c *C) {
+	address := "unix:path=" + path.Join(c.MkDir(), "bus.sock")
+	broker, err := NewBroker(address)
+	c.Assert(err, IsNil)
+	defer func() { broker.Close() }()
+
+	bus, err := dial(address, true, nil)
+	c.Assert(err, IsNil)
+	defer bus.Close()
+	bus.SetAutoReconnect(true)
+
+	name := bus.RequestName("com.example.GoDbus", 0)
+	c.Assert(<-name.C, IsNil)
+	nameWatch, err := bus.WatchName("com.example.GoDbus")
+	c.Assert(err, IsNil)
+	defer nameWatch.Cancel()
+	c.Check(<-nameWatch.C, Equals, bus.UniqueName)
+	signalWatch, err := bus.WatchSignal(&MatchRule{
+		Type:      TypeSignal,
+		Sender:    "com.example.GoDbus",
+		Interface: "com.example.GoDbus",
+		Member:    "TestSignal"})
+	c.Assert(err, IsNil)
+	defer signalWatch.Cancel()
+
+	// A method call pending when the bus goes away fails.
+	bus.RegisterObjectPath("/go/dbus/test", make(chan *Message, 1))
+	calls := make(chan error, 1)
+	go func() {
+		_, err := bus.Object(bus.UniqueName, "/go/dbus/test").Call("com.example.GoDbus", "Hang")
+		calls <- err
+	}()
+	for {
+		bus.handlerMutex.Lock()
+		pending := len(bus.methodCallReplies)
+		bus.handlerMutex.Unlock()
+		if pending != 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Restart the bus.
+	c.Assert(broker.Close(), IsNil)
+	broker, err = NewBroker(address)
+	c.Assert(err, IsNil)
+
+	err = <-calls
+	c.Assert(err, FitsTypeOf, &Error{})
+	c.Check(err.(*Error).Name, Equals, "org.freedesktop.DBus.Error.Disconnected")
+
+	// The name is acquired again, and the name watch sees the new
+	// owner.
+	newOwner := <-nameWatch.C
+	for newOwner == "" {
+		newOwner = <-nameWatch.C
+	}
+	c.Check(<-name.C, IsNil)
+	c.Check(newOwner, Equals, bus.UniqueName)
+
+	// Signals are still delivered.
+	signal := NewSignalMessage("/go/dbus/test", "com.example.GoDbus", "TestSignal")
+	c.Assert(bus.Send(signal), IsNil)
+	select {
+	case msg := <-signalWatch.C:
+		c.Check(msg.Sender, Equals, bus.UniqueName)
+	case <-time.After(5 * time.Second):
+		c.Fatal("Timed out waiting for signal")
+	}
+
+	c.Check(bus.Err(), IsNil)
+	c.Check(name.Release(), IsNil)
+}
+
+func (s *S) TestConnectionReconnectClose(c *C) {
+	address := "unix:path=" + path.Join(c.MkDir(), "bus.sock")
+	broker, err := NewBroker(address)
+	c.Assert(err, IsNil)
+	bus, err := dial(address, true, nil)
+	c.Assert(err, IsNil)
+	bus.SetAutoReconnect(true)
+
+	c.Assert(broker.Close(), IsNil)
+	time.Sleep(3 * minReconnectDelay)
+
+	// Method calls fail while trying to reconnect.
+	_, err = bus.busProxy.GetId()
+	c.Check(err, DeepEquals, errDisconnected)
+	c.Check(bus.Err(), IsNil)
+
+	// Closing the connection stops reconnection attempts.
+	c.Check(bus.Close(), IsNil)
+	<-bus.Done()
+	c.Check(bus.Err(), Equals, ErrConnectionClosed)
+}
@@ -0,0 +1,218 @@
+package dbus
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// How long a client is given to complete authentication.
+const authTimeout = 30 * time.Second
+
+// Server accepts peer-to-peer D-Bus connections on an address.
+type Server struct {
+	listener net.Listener
+	guid     string
+
+	// Clients are authenticated concurrently as they connect, and
+	// passed to Accept once authenticated.
+	authenticated chan acceptedConn
+	// stopped is closed once the listener fails, with the error
+	// in listenErr.  closed is closed by Close.
+	stopped   chan struct{}
+	listenErr error
+	closed    chan struct{}
+
+	lock             sync.Mutex
+	isClosed         bool
+	allowAnonymous   bool
+	allowCredentials func(*Credentials) bool
+}
+
+// acceptedConn is an authenticated client connection.
+type acceptedConn struct {
+	conn    net.Conn
+	unixFDs bool
+	creds   *Credentials
+}
+
+// Listen returns a server listening for connections on the given
+// D-Bus address.  The unix (both path and abstract) and tcp
+// transports are supported.
+func Listen(address string) (*Server, error) {
+	trans, err := newTransport(address)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := trans.Listen()
+	if err != nil {
+		return nil, err
+	}
+	guid := make([]byte, 16)
+	if _, err := rand.Read(guid); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	s := &Server{
+		listener:      listener,
+		guid:          hex.EncodeToString(guid),
+		authenticated: make(chan acceptedConn),
+		stopped:       make(chan struct{}),
+		closed:        make(chan struct{}),
+	}
+	go s.acceptLoop()
+	return s, nil
+}
+
+// AllowAnonymous sets whether clients may connect without
+// identifying themselves, using the ANONYMOUS mechanism.  Anonymous
+// clients are refused by default.
+func (s *Server) AllowAnonymous(allow bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.allowAnonymous = allow
+}
+
+// AllowCredentials sets a function deciding whether a client that
+// authenticates with the EXTERNAL mechanism may connect, given its
+// credentials.  By default, and if allow is nil, only clients running
+// as the same user as the server are allowed.
+//
+// Peer credentials are only read on Linux.  Elsewhere, clients of a
+// server with the default policy are trusted to give their user ID,
+// while a server with an allow function refuses EXTERNAL.
+func (s *Server) AllowCredentials(allow func(creds *Credentials) bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.allowCredentials = allow
+}
+
+// mechanisms returns the authentication mechanisms clients may use.
+func (s *Server) mechanisms() []func() serverAuthenticator {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	allow := s.allowCredentials
+	mechanisms := []func() serverAuthenticator{
+		func() serverAuthenticator { return &serverAuthExternal{allow: allow} }}
+	if dir, err := defaultKeyringDir(); err == nil {
+		keyring := &cookieKeyring{dir, defaultCookieContext}
+		mechanisms = append(mechanisms, func() serverAuthenticator { return &serverAuthDbusCookieSha1{keyring: keyring} })
+	}
+	if s.allowAnonymous {
+		mechanisms = append(mechanisms, func() serverAuthenticator { return new(serverAuthAnonymous) })
+	}
+	return mechanisms
+}
+
+// Address returns a D-Bus address that clients can use to connect to
+// the server.
+func (s *Server) Address() string {
+	var address string
+	switch addr := s.listener.Addr().(type) {
+	case *net.UnixAddr:
+		if len(addr.Name) > 0 && addr.Name[0] == '@' {
+			address = "unix:abstract=" + escapeAddressValue(addr.Name[1:])
+		} else {
+			address = "unix:path=" + escapeAddressValue(addr.Name)
+		}
+	case *net.TCPAddr:
+		family := "ipv4"
+		if addr.IP.To4() == nil {
+			family = "ipv6"
+		}
+		address = fmt.Sprintf("tcp:host=%s,port=%d,family=%s", escapeAddressValue(addr.IP.String()), addr.Port, family)
+	}
+	return address + ",guid=" + s.guid
+}
+
+// acceptLoop accepts clients until the listener fails, authenticating
+// each in its own goroutine so that a slow client does not hold up
+// the others.
+func (s *Server) acceptLoop() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			s.listenErr = err
+			close(s.stopped)
+			return
+		}
+		go s.authenticate(conn)
+	}
+}
+
+// authenticate authenticates a client, and passes it on to acceptConn
+// if successful.
+func (s *Server) authenticate(conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(authTimeout))
+	unixFDs, err := serverAuthenticate(conn, s.guid, s.mechanisms())
+	if err != nil {
+		log.Println("Failed to authenticate client:", err)
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	creds, _ := peerCredentials(conn)
+	select {
+	case s.authenticated <- acceptedConn{conn, unixFDs, creds}:
+	case <-s.closed:
+		conn.Close()
+	}
+}
+
+// acceptConn waits for a client to connect and authenticate, and
+// returns the underlying socket, whether file descriptor passing was
+// negotiated, and the client's credentials if they are known.
+func (s *Server) acceptConn() (net.Conn, bool, *Credentials, error) {
+	select {
+	case accepted := <-s.authenticated:
+		return accepted.conn, accepted.unixFDs, accepted.creds, nil
+	case <-s.stopped:
+		return nil, false, nil, s.listenErr
+	}
+}
+
+// Accept waits for the next client to connect and authenticate, and
+// returns a peer-to-peer connection to it.
+func (s *Server) Accept() (*Connection, error) {
+	conn, unixFDs, _, err := s.acceptConn()
+	if err != nil {
+		return nil, err
+	}
+	bus := newConnection(conn, unixFDs)
+	bus.peerToPeer = true
+	go bus.receiveLoop()
+	return bus, nil
+}
+
+// Close stops the server listening for new connections.  Connections
+// that have already been accepted are unaffected, while clients that
+// have not yet been accepted are disconnected.
+func (s *Server) Close() error {
+	s.lock.Lock()
+	if !s.isClosed {
+		s.isClosed = true
+		close(s.closed)
+	}
+	s.lock.Unlock()
+	return s.listener.Close()
+}
+
+// escapeAddressValue escapes a value for use in a D-Bus address.
+func escapeAddressValue(value string) string {
+	escaped := make([]byte, 0, len(value))
+	for i := 0; i < len(value); i++ {
+		b := value[i]
+		switch {
+		case b >= 'a' && b <= 'z', b >= 'A' && b <= 'Z', b >= '0' && b <= '9',
+			b == '-', b == '_', b == '/', b == '.', b == '\\', b == '*':
+			escaped = append(escaped, b)
+		default:
+			escaped = append(escaped, fmt.Sprintf("%%%02x", b)...)
+		}
+	}
+	return string(escaped)
+}
@@ -0,0 +1,222 @@
+package dbus
+
+import (
+	"fmt"
+	. "launchpad.net/gocheck"
+	"net"
+	"os"
+	"path"
+	"time"
+)
+
+// pingServer checks that a peer-to-peer connection can be made to
+// the server, with method calls working in both directions.
+func pingServer(c *C, server *Server) {
+	accepted := make(chan *Connection, 1)
+	go func() {
+		conn, err := server.Accept()
+		if err != nil {
+			c.Error(err)
+		}
+		accepted <- conn
+	}()
+
+	client, err := Dial(server.Address())
+	c.Assert(err, IsNil)
+	defer client.Close()
+	peer := <-accepted
+	c.Assert(peer, NotNil)
+	defer peer.Close()
+
+	c.Check(client.UniqueName, Equals, "")
+	c.Check(peer.UniqueName, Equals, "")
+
+	_, err = client.Object("", "/").Call("org.freedesktop.DBus.Peer", "Ping")
+	c.Check(err, IsNil)
+	_, err = peer.Object("", "/").Call("org.freedesktop.DBus.Peer", "Ping")
+	c.Check(err, IsNil)
+}
+
+func (s *S) TestServerUnix(c *C) {
+	socketFile := path.Join(c.MkDir(), "peer.sock")
+	server, err := Listen(fmt.Sprintf("unix:path=%s", socketFile))
+	c.Assert(err, IsNil)
+	defer server.Close()
+	c.Check(server.Address(), Matches, "unix:path="+socketFile+",guid=[0-9a-f]{32}")
+
+	pingServer(c, server)
+}
+
+func (s *S) TestServerAbstract(c *C) {
+	server, err := Listen("unix:abstract=/go/dbus/test")
+	c.Assert(err, IsNil)
+	defer server.Close()
+	c.Check(server.Address(), Matches, "unix:abstract=/go/dbus/test,guid=[0-9a-f]{32}")
+
+	pingServer(c, server)
+}
+
+func (s *S) TestServerTcp(c *C) {
+	server, err := Listen("tcp:host=127.0.0.1,port=0")
+	c.Assert(err, IsNil)
+	defer server.Close()
+	c.Check(server.Address(), Matches, "tcp:host=127.0.0.1,port=[0-9]+,family=ipv4,guid=[0-9a-f]{32}")
+
+	pingServer(c, server)
+}
+
+func (s *S) TestServerIdleClient(c *C) {
+	socketFile := path.Join(c.MkDir(), "peer.sock")
+	server, err := Listen(fmt.Sprintf("unix:path=%s", socketFile))
+	c.Assert(err, IsNil)
+	defer server.Close()
+
+	// A client that never authenticates does not hold up others.
+	idle, err := net.Dial("unix", socketFile)
+	c.Assert(err, IsNil)
+	defer idle.Close()
+	start := time.Now()
+	pingServer(c, server)
+	c.Check(time.Since(start) < 5*time.Second, Equals, true)
+}
+
+func (s *S) TestServerTcpUnixFD(c *C) {
+	server, err := Listen("tcp:host=127.0.0.1,port=0")
+	c.Assert(err, IsNil)
+	defer server.Close()
+
+	accepted := make(chan *Connection, 1)
+	go func() {
+		conn, err := server.Accept()
+		if err != nil {
+			c.Error(err)
+		}
+		accepted <- conn
+	}()
+	client, err := Dial(server.Address())
+	c.Assert(err, IsNil)
+	defer client.Close()
+	peer := <-accepted
+	c.Assert(peer, NotNil)
+	defer peer.Close()
+
+	// File descriptors can't be passed over TCP.
+	signal := NewSignalMessage("/go/dbus/test", "com.example.GoDbus", "TestSignal")
+	c.Assert(signal.AppendArgs(UnixFD(0)), IsNil)
+	c.Check(client.Send(signal), NotNil)
+}
+
+func (s *S) TestServerAnonymous(c *C) {
+	server, err := Listen("tcp:host=127.0.0.1,port=0")
+	c.Assert(err, IsNil)
+	defer server.Close()
+
+	accepted := make(chan *Connection, 1)
+	go func() {
+		conn, err := server.Accept()
+		if err != nil {
+			c.Error(err)
+		}
+		accepted <- conn
+	}()
+	options := &ConnectOptions{
+		Authenticators: []Authenticator{&AuthAnonymous{Trace: "go-dbus test"}},
+		PeerToPeer:     true}
+
+	// Anonymous clients are refused by default.
+	_, err = ConnectAddress(server.Address(), options)
+	c.Assert(err, FitsTypeOf, &AuthError{})
+	c.Check(err.(*AuthError).Offered, DeepEquals, []string{"EXTERNAL", "DBUS_COOKIE_SHA1"})
+
+	server.AllowAnonymous(true)
+	client, err := ConnectAddress(server.Address(), options)
+	c.Assert(err, IsNil)
+	defer client.Close()
+	peer := <-accepted
+	c.Assert(peer, NotNil)
+	defer peer.Close()
+	_, err = client.Object("", "/").Call("org.freedesktop.DBus.Peer", "Ping")
+	c.Check(err, IsNil)
+}
+
+func (s *S) TestServerPeerCredentials(c *C) {
+	server, err := Listen("unix:path=" + path.Join(c.MkDir(), "peer.sock"))
+	c.Assert(err, IsNil)
+	defer server.Close()
+
+	accepted := make(chan *Connection, 1)
+	go func() {
+		conn, err := server.Accept()
+		if err != nil {
+			c.Error(err)
+		}
+		accepted <- conn
+	}()
+	options := &ConnectOptions{
+		Authenticators: []Authenticator{new(AuthExternal)},
+		PeerToPeer:     true}
+
+	// Clients can be refused based on their credentials.
+	server.AllowCredentials(func(creds *Credentials) bool {
+		return creds.Uid != uint32(os.Geteuid())
+	})
+	_, err = ConnectAddress(server.Address(), options)
+	c.Check(err, FitsTypeOf, &AuthError{})
+
+	server.AllowCredentials(nil)
+	client, err := ConnectAddress(server.Address(), options)
+	c.Assert(err, IsNil)
+	defer client.Close()
+	peer := <-accepted
+	c.Assert(peer, NotNil)
+	defer peer.Close()
+
+	// Both ends can see the credentials of the other.
+	for _, conn := range []*Connection{peer, client} {
+		creds, err := conn.PeerCredentials()
+		c.Assert(err, IsNil)
+		c.Check(creds.Pid, Equals, os.Getpid())
+		c.Check(creds.Uid, Equals, uint32(os.Geteuid()))
+		c.Check(creds.Gid, Equals, uint32(os.Getegid()))
+	}
+}
+
+func (s *S) TestServerSignal(c *C) {
+	server, err := Listen("unix:path=" + path.Join(c.MkDir(), "peer.sock"))
+	c.Assert(err, IsNil)
+	defer server.Close()
+
+	accepted := make(chan *Connection, 1)
+	go func() {
+		conn, err := server.Accept()
+		if err != nil {
+			c.Error(err)
+		}
+		accepted <- conn
+	}()
+	client, err := Dial(server.Address())
+	c.Assert(err, IsNil)
+	defer client.Close()
+	peer := <-accepted
+	c.Assert(peer, NotNil)
+	defer peer.Close()
+
+	// Signal watches on peer-to-peer connections do not need a
+	// bus daemon.
+	watch, err := client.WatchSignal(&MatchRule{
+		Type:      TypeSignal,
+		Interface: "com.example.GoDbus",
+		Member:    "TestSignal"})
+	c.Assert(err, IsNil)
+	defer watch.Cancel()
+
+	signal := NewSignalMessage("/go/dbus/test", "com.example.GoDbus", "TestSignal")
+	c.Assert(peer.Send(signal), IsNil)
+	signal2 := <-watch.C
+	c.Check(signal2.Path, Equals, ObjectPath("/go/dbus/test"))
+}
+
+func (s *S) TestEscapeAddressValue(c *C) {
+	c.Check(escapeAddressValue("/tmp/dbus-sock"), Equals, "/tmp/dbus-sock")
+	c.Check(escapeAddressValue("/tmp/dbus=sock,x"), Equals, "/tmp/dbus%3dsock%2cx")
+}
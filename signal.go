@@ -96,24 +96,38 @@ type signalWatch struct {
 	bus  *Connection
 	rule *MatchRule
 	cb   func(*Message)
+	// called if the connection is lost
+	disconnectCb func()
 
 	cancelLock sync.Mutex
 	cancelled  bool
 }
 
-func (p *Connection) watchSignal(rule *MatchRule, cb func(*Message)) (*signalWatch, error) {
+func (p *Connection) watchSignal(rule *MatchRule, cb func(*Message), disconnectCb func()) (*signalWatch, error) {
 	if rule.Type != TypeSignal {
 		return nil, errors.New("Match rule is not for signals")
 	}
 	watch := &signalWatch{
-		bus:  p,
-		rule: rule,
-		cb:   cb}
+		bus:          p,
+		rule:         rule,
+		cb:           cb,
+		disconnectCb: disconnectCb}
 
 	p.handlerMutex.Lock()
+	// Checked with the lock held so that the watch is either
+	// refused or seen by disconnect.
+	if !p.isConnOpen() {
+		p.handlerMutex.Unlock()
+		return nil, p.Err()
+	}
 	p.signalMatchRules.Add(watch)
 	p.handlerMutex.Unlock()
 
+	// Peer-to-peer connections have no bus daemon to route
+	// signals, so the rule only needs to be matched locally.
+	if p.peerToPeer {
+		return watch, nil
+	}
 	if err := p.busProxy.AddMatch(rule.String()); err != nil {
 		p.handlerMutex.Lock()
 		p.signalMatchRules.Remove(watch)
@@ -134,7 +148,9 @@ func (watch *signalWatch) cancel() error {
 	foundMatch := watch.bus.signalMatchRules.Remove(watch)
 	watch.bus.handlerMutex.Unlock()
 
-	if foundMatch {
+	// There is no need to remove the match rule if the
+	// connection has gone away.
+	if foundMatch && !watch.bus.peerToPeer && watch.bus.isConnOpen() {
 		if err := watch.bus.busProxy.RemoveMatch(watch.rule.String()); err != nil {
 			return err
 		}
@@ -155,6 +171,9 @@ type SignalWatch struct {
 }
 
 // Handle received signals.
+//
+// Matching signals are sent on the watch's channel, which is closed
+// when the watch is cancelled or the connection is lost.
 func (p *Connection) WatchSignal(rule *MatchRule) (*SignalWatch, error) {
 	if rule.Type != TypeSignal {
 		return nil, errors.New("Match rule is not for signals")
@@ -166,19 +185,21 @@ func (p *Connection) WatchSignal(rule *MatchRule) (*SignalWatch, error) {
 	watch.lock.Lock()
 	defer watch.lock.Unlock()
 	// Does the rule match a bus name other than the daemon?
-	if rule.Sender != "" && rule.Sender != BUS_DAEMON_NAME {
+	if rule.Sender != "" && rule.Sender != BUS_DAEMON_NAME && !p.peerToPeer {
 		nameWatch, err := p.ensureNameWatch(rule.Sender, func(newOwner string) {
 			if rule.Sender[0] == ':' {
 				// For unique names, cancel the signal watch
-				// when the name is lost.
+				// when the name is lost.  This is done in
+				// the background, since the name watch
+				// can't be removed from its own callback.
 				if newOwner == "" {
-					watch.Cancel()
+					go watch.Cancel()
 				}
 			} else {
 				// Otherwise, update the sender owner.
 				rule.senderNameOwner = newOwner
 			}
-		})
+		}, nil)
 		if err != nil {
 			return nil, err
 		}
@@ -187,6 +208,8 @@ func (p *Connection) WatchSignal(rule *MatchRule) (*SignalWatch, error) {
 
 	internal, err := p.watchSignal(rule, func(msg *Message) {
 		watch.C <- msg
+	}, func() {
+		watch.Cancel()
 	})
 	if err != nil {
 		if watch.nameWatch != nil {
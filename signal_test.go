@@ -168,3 +168,49 @@ func (s *S) TestSignalWatchSetFindMatches(c *C) {
 	c.Check(set.FindMatches(msg), DeepEquals, []*signalWatch{&watch})
 	set.Remove(&watch)
 }
+
+func (s *S) TestConnectionWatchSignalPathNamespace(c *C) {
+	bus, err := Connect(SessionBus)
+	c.Assert(err, IsNil)
+	defer bus.Close()
+
+	watch, err := bus.WatchSignal(&MatchRule{
+		Type:          TypeSignal,
+		PathNamespace: "/go/dbus/test",
+		Interface:     "org.freedesktop.DBus.Properties",
+		Member:        "PropertiesChanged",
+		Arg0:          "com.example.GoDbus"})
+	c.Assert(err, IsNil)
+	defer watch.Cancel()
+	received := make(chan *Message, 4)
+	go func() {
+		for msg := range watch.C {
+			received <- msg
+		}
+	}()
+
+	for _, t := range []struct {
+		path  ObjectPath
+		iface string
+	}{
+		{"/go/dbus/other", "com.example.GoDbus"},
+		{"/go/dbus/test/child", "com.example.Other"},
+		{"/go/dbus/test/child", "com.example.GoDbus"},
+	} {
+		signal := NewSignalMessage(t.path, "org.freedesktop.DBus.Properties", "PropertiesChanged")
+		c.Assert(signal.AppendArgs(t.iface, map[string]Variant{}, []string{}), IsNil)
+		c.Assert(bus.Send(signal), IsNil)
+	}
+
+	// Only the last signal matches the rule.
+	msg := <-received
+	c.Check(msg.Path, Equals, ObjectPath("/go/dbus/test/child"))
+	var iface string
+	c.Assert(msg.Args(&iface), IsNil)
+	c.Check(iface, Equals, "com.example.GoDbus")
+	select {
+	case msg := <-received:
+		c.Errorf("Unexpected signal from %s", msg.Path)
+	default:
+	}
+}
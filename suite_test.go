@@ -2,6 +2,8 @@ package dbus
 
 import (
 	. "launchpad.net/gocheck"
+	"os"
+	"path"
 	"testing"
 )
 
@@ -9,6 +11,33 @@ func TestAll(t *testing.T) {
 	TestingT(t)
 }
 
-type S struct{}
+type S struct {
+	broker                      *Broker
+	oldSessionBus, oldSystemBus string
+	oldHome                     string
+}
 
 var _ = Suite(&S{})
+
+// Run each test against a private message bus, so the tests do not
+// depend on a session or system bus being available.
+func (s *S) SetUpTest(c *C) {
+	broker, err := NewBroker("unix:path=" + path.Join(c.MkDir(), "bus.sock"))
+	c.Assert(err, IsNil)
+	s.broker = broker
+	s.oldSessionBus = os.Getenv("DBUS_SESSION_BUS_ADDRESS")
+	s.oldSystemBus = os.Getenv("DBUS_SYSTEM_BUS_ADDRESS")
+	os.Setenv("DBUS_SESSION_BUS_ADDRESS", broker.Address())
+	os.Setenv("DBUS_SYSTEM_BUS_ADDRESS", broker.Address())
+	// Keep any DBUS_COOKIE_SHA1 keyrings out of the real home
+	// directory.
+	s.oldHome = os.Getenv("HOME")
+	os.Setenv("HOME", c.MkDir())
+}
+
+func (s *S) TearDownTest(c *C) {
+	os.Setenv("DBUS_SESSION_BUS_ADDRESS", s.oldSessionBus)
+	os.Setenv("DBUS_SYSTEM_BUS_ADDRESS", s.oldSystemBus)
+	os.Setenv("HOME", s.oldHome)
+	c.Check(s.broker.Close(), IsNil)
+}
@@ -1,7 +1,9 @@
 package dbus
 
 import (
+	"bytes"
 	"errors"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/url"
@@ -10,6 +12,7 @@ import (
 
 type transport interface {
 	Dial() (net.Conn, error)
+	Listen() (net.Listener, error)
 }
 
 func newTransport(address string) (transport, error) {
@@ -80,6 +83,10 @@ func (trans *unixTransport) Dial() (net.Conn, error) {
 	return net.Dial("unix", trans.Address)
 }
 
+func (trans *unixTransport) Listen() (net.Listener, error) {
+	return net.Listen("unix", trans.Address)
+}
+
 type tcpTransport struct {
 	Address, Family string
 }
@@ -88,6 +95,10 @@ func (trans *tcpTransport) Dial() (net.Conn, error) {
 	return net.Dial(trans.Family, trans.Address)
 }
 
+func (trans *tcpTransport) Listen() (net.Listener, error) {
+	return net.Listen(trans.Family, trans.Address)
+}
+
 type nonceTcpTransport struct {
 	Address, Family, NonceFile string
 }
@@ -110,3 +121,55 @@ func (trans *nonceTcpTransport) Dial() (net.Conn, error) {
 	}
 	return conn, nil
 }
+
+func (trans *nonceTcpTransport) Listen() (net.Listener, error) {
+	return nil, errors.New("Listening on nonce-tcp transport is not supported")
+}
+
+// unixFDReader reads from a unix socket, collecting any file
+// descriptors passed alongside the data.
+type unixFDReader struct {
+	conn *net.UnixConn
+	fds  []int
+}
+
+func newMessageReader(conn net.Conn) io.Reader {
+	if unixConn, ok := conn.(*net.UnixConn); ok {
+		return &unixFDReader{conn: unixConn}
+	}
+	return conn
+}
+
+// fdSource is implemented by readers that receive file descriptors
+// alongside message data.
+type fdSource interface {
+	takeFDs(count int) ([]int, error)
+}
+
+// takeFDs removes the next count file descriptors from those received.
+func (r *unixFDReader) takeFDs(count int) ([]int, error) {
+	if count > len(r.fds) {
+		return nil, errors.New("Message file descriptors were not received")
+	}
+	fds := r.fds[:count:count]
+	r.fds = r.fds[count:]
+	return fds, nil
+}
+
+// writeMessage writes a message to a connection, passing any file
+// descriptors attached to the message out of band.
+func writeMessage(conn net.Conn, msg *Message) error {
+	if len(msg.fds) == 0 {
+		_, err := msg.WriteTo(conn)
+		return err
+	}
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return errors.New("File descriptors can only be passed over unix sockets")
+	}
+	var buff bytes.Buffer
+	if _, err := msg.WriteTo(&buff); err != nil {
+		return err
+	}
+	return writeWithFDs(unixConn, buff.Bytes(), msg.fds)
+}
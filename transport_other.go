@@ -0,0 +1,32 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package dbus
+
+import (
+	"errors"
+	"net"
+)
+
+var errNoFDPassing = errors.New("File descriptor passing is not supported on this platform")
+
+// canPassFDs returns false, as file descriptor passing is only
+// implemented on Unix systems.
+func canPassFDs(conn net.Conn) bool {
+	return false
+}
+
+func (r *unixFDReader) Read(b []byte) (int, error) {
+	return r.conn.Read(b)
+}
+
+func writeWithFDs(conn *net.UnixConn, data []byte, fds []int) error {
+	return errNoFDPassing
+}
+
+func dupFD(fd int) (int, error) {
+	return -1, errNoFDPassing
+}
+
+func closeFDs(fds []int) {
+}
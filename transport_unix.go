@@ -0,0 +1,60 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package dbus
+
+import (
+	"net"
+	"syscall"
+)
+
+// The maximum number of file descriptors that can be received with a
+// single read from a unix socket.
+const maxUnixFDs = 253
+
+// canPassFDs returns true if file descriptors can be passed over
+// conn, which is only possible over unix sockets.
+func canPassFDs(conn net.Conn) bool {
+	_, ok := conn.(*net.UnixConn)
+	return ok
+}
+
+func (r *unixFDReader) Read(b []byte) (int, error) {
+	oob := make([]byte, syscall.CmsgSpace(maxUnixFDs*4))
+	n, oobn, _, _, err := r.conn.ReadMsgUnix(b, oob)
+	if oobn > 0 {
+		msgs, parseErr := syscall.ParseSocketControlMessage(oob[:oobn])
+		if parseErr != nil {
+			return n, parseErr
+		}
+		for _, msg := range msgs {
+			fds, parseErr := syscall.ParseUnixRights(&msg)
+			if parseErr != nil {
+				return n, parseErr
+			}
+			r.fds = append(r.fds, fds...)
+		}
+	}
+	return n, err
+}
+
+// writeWithFDs writes data to a unix socket, passing fds along with it.
+func writeWithFDs(conn *net.UnixConn, data []byte, fds []int) error {
+	n, _, err := conn.WriteMsgUnix(data, syscall.UnixRights(fds...), nil)
+	if err == nil && n < len(data) {
+		// The file descriptors went with the first part of
+		// the message, so write out the remainder.
+		_, err = conn.Write(data[n:])
+	}
+	return err
+}
+
+func dupFD(fd int) (int, error) {
+	return syscall.Dup(fd)
+}
+
+func closeFDs(fds []int) {
+	for _, fd := range fds {
+		syscall.Close(fd)
+	}
+}
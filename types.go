@@ -11,6 +11,7 @@ var (
 	typeVariant        = reflect.TypeOf(Variant{})
 	typeSignature      = reflect.TypeOf(Signature(""))
 	typeBlankInterface = reflect.TypeOf((*interface{})(nil)).Elem()
+	typeUnixFD         = reflect.TypeOf(UnixFD(0))
 )
 
 type Signature string
@@ -19,6 +20,9 @@ func SignatureOf(t reflect.Type) (Signature, error) {
 	if t.AssignableTo(typeObjectPather) {
 		return Signature("o"), nil
 	}
+	if t == typeUnixFD {
+		return Signature("h"), nil
+	}
 	switch t.Kind() {
 	case reflect.Uint8:
 		return Signature("y"), nil
@@ -151,6 +155,14 @@ func (o ObjectPath) ObjectPath() ObjectPath {
 	return o
 }
 
+// UnixFD represents a Unix file descriptor passed in a message.
+//
+// File descriptors appended to a message must remain open until the
+// message has been sent.  File descriptors decoded from a received
+// message are owned by the receiver, who should close them when they
+// are no longer needed.
+type UnixFD int
+
 type Variant struct {
 	Value interface{}
 }